@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bonya_bot/en"
+)
+
+// AlertSettings thresholds of the notifications for the chat. Times are in minutes
+// before the deadline, empty list turns the notifications off
+type AlertSettings struct {
+	// LevelTime time left till the end of the level
+	LevelTime []int `json:"level_time"`
+	// HelpTime time left till the next hint
+	HelpTime []int `json:"help_time"`
+	// BonusTime time left to answer the bonus
+	BonusTime []int `json:"bonus_time"`
+	// SectorsLeft closed sectors are announced only when there are so many sectors
+	// left to close or less, 0 turns the notifications off
+	SectorsLeft int `json:"sectors_left"`
+}
+
+// DefaultAlertSettings thresholds for the chats that didn't change them
+var DefaultAlertSettings = AlertSettings{
+	LevelTime:   []int{60, 30, 15, 5, 1},
+	BonusTime:   []int{5},
+	SectorsLeft: 3,
+}
+
+func (as AlertSettings) String() string {
+	var sectors = AlertsOffString
+	if as.SectorsLeft > 0 {
+		sectors = fmt.Sprintf(AlertsSectorsString, as.SectorsLeft)
+	}
+	return fmt.Sprintf(AlertsString, alertMinutes(as.LevelTime), alertMinutes(as.HelpTime),
+		alertMinutes(as.BonusTime), sectors)
+}
+
+// checkpoints converts the thresholds to the checkpoints for en.DiffWith
+func (as AlertSettings) checkpoints() en.Checkpoints {
+	return en.Checkpoints{
+		Timeout: minutesToDurations(as.LevelTime),
+		Help:    minutesToDurations(as.HelpTime),
+		Bonus:   minutesToDurations(as.BonusTime),
+	}
+}
+
+// update changes the thresholds of the kind according to the values entered by user
+func (as *AlertSettings) update(kind string, values []string) error {
+	var target *[]int
+	switch kind {
+	case "time":
+		target = &as.LevelTime
+	case "help":
+		target = &as.HelpTime
+	case "bonus":
+		target = &as.BonusTime
+	case "sectors":
+	default:
+		return fmt.Errorf(AlertsUnknownKindString, kind)
+	}
+	if len(values) == 0 {
+		return fmt.Errorf(AlertsNoValuesString, kind)
+	}
+
+	// threshold for the sectors is a single number
+	if target == nil {
+		if len(values) > 1 {
+			return fmt.Errorf(AlertsIncorrectValueString, strings.Join(values, " "))
+		}
+		if strings.EqualFold(values[0], "off") {
+			as.SectorsLeft = 0
+			return nil
+		}
+		sectors, err := strconv.Atoi(values[0])
+		if err != nil || sectors < 0 {
+			return fmt.Errorf(AlertsIncorrectValueString, values[0])
+		}
+		as.SectorsLeft = sectors
+		return nil
+	}
+
+	minutes, err := parseMinutes(values)
+	if err != nil {
+		return err
+	}
+	*target = minutes
+	return nil
+}
+
+// parseMinutes returns unique thresholds in minutes from the largest to the smallest,
+// "off" returns empty list
+func parseMinutes(values []string) (minutes []int, err error) {
+	if len(values) == 1 && strings.EqualFold(values[0], "off") {
+		return []int{}, nil
+	}
+	var seen = map[int]bool{}
+	for _, value := range values {
+		number, err := strconv.Atoi(value)
+		if err != nil || number <= 0 {
+			return nil, fmt.Errorf(AlertsIncorrectValueString, value)
+		}
+		if !seen[number] {
+			seen[number] = true
+			minutes = append(minutes, number)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(minutes)))
+	return minutes, nil
+}
+
+func minutesToDurations(minutes []int) (durations []time.Duration) {
+	for _, m := range minutes {
+		durations = append(durations, time.Duration(m)*time.Minute)
+	}
+	return
+}
+
+func alertMinutes(minutes []int) string {
+	if len(minutes) == 0 {
+		return AlertsOffString
+	}
+	var values = make([]string, len(minutes))
+	for i, m := range minutes {
+		values[i] = strconv.Itoa(m)
+	}
+	return fmt.Sprintf(AlertsMinutesString, strings.Join(values, ", "))
+}
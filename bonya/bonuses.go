@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bonya_bot/en"
+)
+
+// bonusState returns the state of the bonus as it is shown in the list of the bonuses
+func bonusState(bonus en.BonusInfo) string {
+	switch {
+	case bonus.IsAnswered && bonus.AwardTime > 0:
+		return fmt.Sprintf(BonusDoneAwardString, prettyDuration(bonus.AwardTime))
+	case bonus.IsAnswered:
+		return BonusDoneString
+	case bonus.Expired:
+		return BonusExpiredString
+	case bonus.SecondsToStart > 0:
+		return fmt.Sprintf(BonusUpcomingString, prettyDuration(bonus.SecondsToStart))
+	case bonus.SecondsLeft > 0:
+		return fmt.Sprintf(BonusOpenTillString, prettyDuration(bonus.SecondsLeft))
+	}
+	return BonusOpenString
+}
+
+// listBonuses returns the list of all bonuses of the level with their state. Tasks
+// are shown only for the bonuses that are not answered and not expired yet
+func listBonuses(level *en.Level) string {
+	var lines []string
+	for _, bonus := range level.Bonuses {
+		line := fmt.Sprintf(BonusString, bonus.Number, escapeMarkdown(bonus.Name), bonusState(bonus))
+		if task := strings.TrimSpace(bonus.Task); task != "" && !bonus.IsAnswered && !bonus.Expired {
+			line += fmt.Sprintf(BonusTaskString, en.Render(task, en.Markdown))
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return NoBonusesString
+	}
+	return fmt.Sprintf(BonusesString, strings.Join(lines, "\n"))
+}
+
+// bonusAppearedText notification about the bonus that can be answered now
+func bonusAppearedText(bonus *en.BonusInfo) string {
+	var till, text string
+	if bonus.SecondsLeft > 0 {
+		till = fmt.Sprintf(BonusAppearedTillString, prettyDuration(bonus.SecondsLeft))
+	}
+	text = fmt.Sprintf(BonusAppearedString, bonus.Number, escapeMarkdown(bonus.Name), till)
+	if task := strings.TrimSpace(bonus.Task); task != "" {
+		text += fmt.Sprintf(BonusTaskString, en.Render(task, en.Markdown))
+	}
+	return text
+}
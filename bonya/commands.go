@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,7 +18,8 @@ import (
 type BaseCommand struct {
 	output  chan MessageSender
 	message tb.Message
-	level   *en.Level
+	// game for the chat where command was sent, nil if game is not configured for the chat
+	game *Game
 }
 
 // Command is an interface that all user-defined command handlers should
@@ -37,7 +42,7 @@ func (uc UnknownCommand) Process(args ...string) {
 
 	outputMessage := NewTextMessage(
 		uc.message.Chat,
-		"Unknown command",
+		UnknownCommandString,
 		uc.message,
 	)
 
@@ -45,8 +50,8 @@ func (uc UnknownCommand) Process(args ...string) {
 }
 
 // NewUnknownCommand - constructor for the InfoCommand
-func NewUnknownCommand(output chan MessageSender, message tb.Message, level *en.Level) (Command, error) {
-	return UnknownCommand{BaseCommand{output, message, level}}, nil
+func NewUnknownCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return UnknownCommand{BaseCommand{output, message, game}}, nil
 }
 
 // var CommandRegister = make(map[string]Command)
@@ -66,66 +71,51 @@ type InfoCommand struct {
 // Process is required to implement Command interface
 func (ic InfoCommand) Process(args ...string) {
 	var (
+		level    *en.Level
 		taskText string
 		messages []string
+		batch    = NewBatch()
+		err      error
 	)
 	if DEBUG {
 		log.Printf("InfoCommand is executed")
 	}
 
-	messages = append(messages, ic.level.GetLevelDetails())
-
-	taskText = ic.level.GetLevelTask()
-	for _, coordinate := range ic.level.Coords {
-		messages = append(messages, coordinate.String())
+	if ic.game == nil {
+		ic.output <- NewTextMessage(ic.message.Chat, NoGameString, ic.message)
+		return
+	}
+	if level, err = ic.game.Engine.GetLevelInfo(); err != nil {
+		log.Printf("[ERROR] Can't get level info for chat %d: %s", ic.message.Chat.ID, err)
+		ic.output <- NewTextMessage(ic.message.Chat, NoLevelString, ic.message)
+		return
 	}
-	// TODO: use constant
-	messages = append(messages, SplitText(taskText, 4096)...)
+
+	messages = append(messages, level.GetLevelDetails())
+
+	taskText = level.GetLevelTask()
+	messages = append(messages, taskText)
 
 	for _, message := range messages {
-		ic.output <- NewTextMessage(
+		batch.Messages = append(batch.Messages, NewTextMessage(
 			ic.message.Chat,
 			message,
 			tb.Message{},
-		)
-		time.Sleep(2 * time.Millisecond)
+		))
 	}
-	for _, coordinate := range ic.level.Coords {
-		ic.output <- NewLocationMessage(
-			ic.message.Chat,
-			&tb.Venue{
-				Location: tb.Location{
-					Latitude:  float32(coordinate.Lat),
-					Longitude: float32(coordinate.Lon)},
-				Title: coordinate.String()},
-			tb.Message{},
-		)
-		time.Sleep(2 * time.Millisecond)
-	}
-	for _, image := range ic.level.Images {
-		log.Printf("[INFO] Reading file %s", image.Filepath)
-		file, _ := tb.NewFile(image.Filepath)
-		thumbnail := tb.Thumbnail{
-			File:   file,
-			Width:  60,
-			Height: 60,
-		}
-		ic.output <- NewPhotoMessage(
-			ic.message.Chat,
-			&tb.Photo{
-				File:      file,
-				Thumbnail: thumbnail,
-				Caption:   image.Caption,
-			},
-			tb.Message{},
-		)
-		time.Sleep(2 * time.Millisecond)
+	if len(level.Coords) > 0 {
+		batch.Messages = append(batch.Messages, coordinatesMessage(ic.message.Chat, ic.game.MapProvider(),
+			level.Coords, level.AllCoords(), tb.Message{}))
+	}
+	if images := imageService.Download(ic.game.Settings.GameID, level.Number, level.Images); len(images) > 0 {
+		batch.Messages = append(batch.Messages, imageService.Message(ic.message.Chat, images))
 	}
+	ic.output <- batch
 }
 
 // NewInfoCommand - constructor for the InfoCommand
-func NewInfoCommand(output chan MessageSender, message tb.Message, level *en.Level) (Command, error) {
-	return InfoCommand{BaseCommand{output, message, level}}, nil
+func NewInfoCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return InfoCommand{BaseCommand{output, message, game}}, nil
 }
 
 // StartCommand handler for 'start' command, that is used for basic bot configuration
@@ -136,68 +126,881 @@ type StartCommand struct {
 
 // Process is required to implement Command interface
 func (sc StartCommand) Process(args ...string) {
+	if DEBUG {
+		log.Printf("StartCommand is executed")
+	}
+	settingsMachines.Start(&sc.output, sc.message.Chat.ID, sc.message.Sender.ID)
+}
+
+// NewStartCommand - constructor for the StartCommand
+func NewStartCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return StartCommand{BaseCommand{output, message, game}}, nil
+}
+
+// HistoryCommand handler for 'history' command, sends the timeline of the current
+// level or the level with the number from the argument
+type HistoryCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (hc HistoryCommand) Process(args ...string) {
 	var (
-		buttons = make([][]tb.KeyboardButton, len(QuestDomains)/ButtonsPerRow+len(QuestDomains)%ButtonsPerRow)
-		i       int8
+		levelNumber int8
+		lines       []string
 	)
-	for domain := range QuestDomains {
-		if len(buttons[i]) == ButtonsPerRow {
-			i++
+	if DEBUG {
+		log.Printf("HistoryCommand is executed")
+	}
+
+	if hc.game == nil {
+		hc.output <- NewTextMessage(hc.message.Chat, NoGameString, hc.message)
+		return
+	}
+	if arg := strings.TrimSpace(strings.Join(args, " ")); arg != "" {
+		number, err := strconv.ParseInt(arg, 10, 8)
+		if err != nil || number <= 0 {
+			hc.output <- NewTextMessage(hc.message.Chat, fmt.Sprintf(IncorrectLevelString, arg), hc.message)
+			return
 		}
-		buttons[i] = append(buttons[i], tb.KeyboardButton{Text: domain, Data: QuestDomains[domain]})
+		levelNumber = int8(number)
+	} else if level := hc.game.CurrentLevel(); level != nil {
+		levelNumber = level.Number
+	} else {
+		hc.output <- NewTextMessage(hc.message.Chat, NoLevelString, hc.message)
+		return
+	}
+
+	records, err := eventStore.Level(hc.game.Chat.ID, hc.game.Settings.GameID, levelNumber)
+	if err != nil {
+		log.Printf("[ERROR] Can't read history for chat %d: %s", hc.message.Chat.ID, err)
+	}
+	if len(records) == 0 {
+		hc.output <- NewTextMessage(hc.message.Chat, fmt.Sprintf(EmptyHistoryString, levelNumber), hc.message)
+		return
+	}
+	for _, record := range records {
+		lines = append(lines, escapeMarkdown(record.String()))
 	}
+	hc.output <- NewTextMessage(hc.message.Chat, fmt.Sprintf(HistoryString, levelNumber, strings.Join(lines, "\n")), hc.message)
+}
+
+// NewHistoryCommand - constructor for the HistoryCommand
+func NewHistoryCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return HistoryCommand{BaseCommand{output, message, game}}, nil
+}
+
+// PenaltyHelpsCommand handler for 'ph' command, lists penalty hints of the current level
+// or asks to confirm the request of the hint with the number from the argument
+type PenaltyHelpsCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (pc PenaltyHelpsCommand) Process(args ...string) {
+	var level *en.Level
 	if DEBUG {
-		log.Printf("[INFO] buttons %d %s", len(buttons), buttons)
+		log.Printf("PenaltyHelpsCommand is executed")
 	}
-	message := NewTextInlineMessage(sc.message.Chat, "Выберите домен:", buttons)
 
-	sc.output <- message
+	if pc.game == nil {
+		pc.output <- NewTextMessage(pc.message.Chat, NoGameString, pc.message)
+		return
+	}
+	if level = pc.game.CurrentLevel(); level == nil {
+		pc.output <- NewTextMessage(pc.message.Chat, NoLevelString, pc.message)
+		return
+	}
+	if number := strings.TrimSpace(strings.Join(args, " ")); number != "" {
+		confirmPenaltyHelp(pc.output, pc.message, level, number)
+		return
+	}
+	pc.output <- NewTextMessage(pc.message.Chat, listPenaltyHelps(level), pc.message)
 }
 
-// NewStartCommand - constructor for the StartCommand
-func NewStartCommand(output chan MessageSender, message tb.Message, level *en.Level) (Command, error) {
-	return StartCommand{BaseCommand{output, message, level}}, nil
+// NewPenaltyHelpsCommand - constructor for the PenaltyHelpsCommand
+func NewPenaltyHelpsCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return PenaltyHelpsCommand{BaseCommand{output, message, game}}, nil
+}
+
+// BonusesCommand handler for 'bonuses' command, lists all bonuses of the current level
+type BonusesCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (bc BonusesCommand) Process(args ...string) {
+	var level *en.Level
+	if DEBUG {
+		log.Printf("BonusesCommand is executed")
+	}
+
+	if bc.game == nil {
+		bc.output <- NewTextMessage(bc.message.Chat, NoGameString, bc.message)
+		return
+	}
+	if level = bc.game.CurrentLevel(); level == nil {
+		bc.output <- NewTextMessage(bc.message.Chat, NoLevelString, bc.message)
+		return
+	}
+	bc.output <- NewTextMessage(bc.message.Chat, listBonuses(level), bc.message)
+}
+
+// NewBonusesCommand - constructor for the BonusesCommand
+func NewBonusesCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return BonusesCommand{BaseCommand{output, message, game}}, nil
+}
+
+// AlertsCommand handler for 'alerts' command, shows or changes thresholds of the
+// notifications for the chat
+type AlertsCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (ac AlertsCommand) Process(args ...string) {
+	var fields = strings.Fields(strings.Join(args, " "))
+	if DEBUG {
+		log.Printf("AlertsCommand is executed")
+	}
+
+	if ac.game == nil {
+		ac.output <- NewTextMessage(ac.message.Chat, NoGameString, ac.message)
+		return
+	}
+	if len(fields) == 0 {
+		ac.output <- NewTextMessage(ac.message.Chat, ac.game.Alerts().String(), ac.message)
+		return
+	}
+
+	var alerts = ac.game.Alerts()
+	if kind := strings.ToLower(fields[0]); kind == "reset" {
+		alerts = DefaultAlertSettings
+	} else if err := alerts.update(kind, fields[1:]); err != nil {
+		ac.output <- NewTextMessage(ac.message.Chat, err.Error(), ac.message)
+		return
+	}
+	ac.game.SetAlerts(alerts)
+	saveSettings(ac.game)
+	ac.output <- NewTextMessage(ac.message.Chat, AlertsSavedString+"\n\n"+alerts.String(), ac.message)
+}
+
+// NewAlertsCommand - constructor for the AlertsCommand
+func NewAlertsCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return AlertsCommand{BaseCommand{output, message, game}}, nil
+}
+
+// MapsCommand handler for 'maps' command, shows or changes the maps that are used for
+// the links to coordinates in the chat
+type MapsCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (mc MapsCommand) Process(args ...string) {
+	var name = strings.ToLower(strings.TrimSpace(strings.Join(args, " ")))
+	if DEBUG {
+		log.Printf("MapsCommand is executed")
+	}
+
+	if mc.game == nil {
+		mc.output <- NewTextMessage(mc.message.Chat, NoGameString, mc.message)
+		return
+	}
+	var names = strings.Join(mapProviderNames(), ", ")
+	if name == "" {
+		mc.output <- NewTextMessage(mc.message.Chat, fmt.Sprintf(MapsString, mc.game.MapProvider().Title, names), mc.message)
+		return
+	}
+	if !mc.game.SetMapProvider(name) {
+		mc.output <- NewTextMessage(mc.message.Chat, fmt.Sprintf(UnknownMapsString, name, names), mc.message)
+		return
+	}
+	saveSettings(mc.game)
+	mc.output <- NewTextMessage(mc.message.Chat, MapsSavedString+"\n\n"+
+		fmt.Sprintf(MapsString, mc.game.MapProvider().Title, names), mc.message)
+}
+
+// NewMapsCommand - constructor for the MapsCommand
+func NewMapsCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return MapsCommand{BaseCommand{output, message, game}}, nil
+}
+
+// RouteCommand handler for 'route' command, sends links to all coordinates of the current
+// level, including hints and bonuses, and the route through them
+type RouteCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (rc RouteCommand) Process(args ...string) {
+	var level *en.Level
+	if DEBUG {
+		log.Printf("RouteCommand is executed")
+	}
+
+	if rc.game == nil {
+		rc.output <- NewTextMessage(rc.message.Chat, NoGameString, rc.message)
+		return
+	}
+	if level = rc.game.CurrentLevel(); level == nil {
+		rc.output <- NewTextMessage(rc.message.Chat, NoLevelString, rc.message)
+		return
+	}
+	coords := level.AllCoords()
+	rc.output <- coordinatesMessage(rc.message.Chat, rc.game.MapProvider(), coords, coords, rc.message)
+}
+
+// NewRouteCommand - constructor for the RouteCommand
+func NewRouteCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return RouteCommand{BaseCommand{output, message, game}}, nil
+}
+
+// TokenCommand handler for 'token' command, issues new token of the API for the chat
+// or revokes all tokens of the chat with 'revoke' argument. Token gives access to the
+// game, so it is never posted to the group, it is sent privately to the user
+type TokenCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (tc TokenCommand) Process(args ...string) {
+	var chatID = tc.message.Chat.ID
+	if DEBUG {
+		log.Printf("TokenCommand is executed")
+	}
+
+	if tc.game == nil {
+		tc.output <- NewTextMessage(tc.message.Chat, NoGameString, tc.message)
+		return
+	}
+	if arg := strings.ToLower(strings.TrimSpace(strings.Join(args, " "))); arg == "revoke" {
+		revoked, err := tokens.Revoke(chatID)
+		if err != nil {
+			log.Printf("[ERROR] Can't revoke tokens for chat %d: %s", chatID, err)
+			tc.output <- NewTextMessage(tc.message.Chat, TokenErrorString, tc.message)
+			return
+		}
+		log.Printf("[INFO] %d tokens are revoked for chat %d by %s", revoked, chatID, senderName(tc.message.Sender))
+		tc.output <- NewTextMessage(tc.message.Chat, fmt.Sprintf(TokensRevokedString, revoked), tc.message)
+		return
+	}
+
+	token, err := tokens.Issue(chatID, senderName(tc.message.Sender))
+	if err != nil {
+		log.Printf("[ERROR] Can't issue token for chat %d: %s", chatID, err)
+		tc.output <- NewTextMessage(tc.message.Chat, TokenErrorString, tc.message)
+		return
+	}
+	log.Printf("[INFO] Token is issued for chat %d by %s", chatID, senderName(tc.message.Sender))
+	title := tc.message.Chat.Title
+	if title == "" {
+		title = strconv.FormatInt(chatID, 10)
+	}
+	text := fmt.Sprintf(TokenIssuedString, escapeMarkdown(title), token)
+	if tc.message.Chat.Type == tb.Private {
+		tc.output <- NewTextMessage(tc.message.Chat, text, tc.message)
+		return
+	}
+	// id of the private chat with the user is the id of the user, bot can't write
+	// there until the user starts the chat
+	private := NewReportedMessage(tb.Chat{ID: int64(tc.message.Sender.ID), Type: tb.Private}, text, tb.Message{})
+	tc.output <- private
+	select {
+	case err = <-private.Result:
+	case <-time.After(TokenSendTimeout):
+		err = errors.New("timeout")
+	}
+	if err != nil {
+		log.Printf("[WARNING] Can't send token to %s: %s", senderName(tc.message.Sender), err)
+		tc.output <- NewTextMessage(tc.message.Chat, TokenNotSentString, tc.message)
+		return
+	}
+	tc.output <- NewTextMessage(tc.message.Chat, TokenSentString, tc.message)
+}
+
+// NewTokenCommand - constructor for the TokenCommand
+func NewTokenCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return TokenCommand{BaseCommand{output, message, game}}, nil
+}
+
+// NotAllowedCommand is returned by the store instead of the command, when the role of
+// the user is lower than the role the command requires
+type NotAllowedCommand struct {
+	BaseCommand
+	required Role
+	role     Role
+}
+
+// Process is required to implement Command interface
+func (nc NotAllowedCommand) Process(args ...string) {
+	log.Printf("[INFO] %s with role %s is not allowed to run %q in chat %d",
+		senderName(nc.message.Sender), nc.role, nc.message.Text, nc.message.Chat.ID)
+	nc.output <- NewTextMessage(nc.message.Chat, fmt.Sprintf(NotAllowedString, nc.required.Title(), nc.role.Title()), nc.message)
+}
+
+// newNotAllowedCommand returns the factory of the NotAllowedCommand for the roles
+func newNotAllowedCommand(required Role, role Role) CommandFactory {
+	return func(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+		return NotAllowedCommand{BaseCommand{output, message, game}, required, role}, nil
+	}
+}
+
+// RolesCommand handler for 'roles' command, shows the role of the user and the roles
+// assigned in the chat
+type RolesCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (rc RolesCommand) Process(args ...string) {
+	var lines []string
+	if DEBUG {
+		log.Printf("RolesCommand is executed")
+	}
+
+	if rc.game == nil {
+		rc.output <- NewTextMessage(rc.message.Chat, NoGameString, rc.message)
+		return
+	}
+	members := rc.game.MemberRoles()
+	for _, member := range members {
+		lines = append(lines, fmt.Sprintf(RoleLineString, escapeMarkdown(member.Name), member.Role.Title()))
+	}
+	sort.Strings(lines)
+	if len(lines) == 0 {
+		lines = append(lines, NoRolesString)
+	}
+	role := permissions.Role(rc.message.Chat, rc.message.Sender, rc.game)
+	rc.output <- NewTextMessage(rc.message.Chat, fmt.Sprintf(RolesString, role.Title(), strings.Join(lines, "\n")), rc.message)
+}
+
+// NewRolesCommand - constructor for the RolesCommand
+func NewRolesCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return RolesCommand{BaseCommand{output, message, game}}, nil
+}
+
+// RoleCommand handler for 'role' command, assigns the role from the argument to the
+// author of the message the command replies to, or removes it with 'reset' argument
+type RoleCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (rc RoleCommand) Process(args ...string) {
+	var (
+		fields = strings.Fields(strings.Join(args, " "))
+		chat   = rc.message.Chat
+	)
+	if DEBUG {
+		log.Printf("RoleCommand is executed")
+	}
+
+	if rc.game == nil {
+		rc.output <- NewTextMessage(chat, NoGameString, rc.message)
+		return
+	}
+	if len(fields) != 1 || rc.message.ReplyTo == nil || rc.message.ReplyTo.Sender.ID == 0 {
+		rc.output <- NewTextMessage(chat, RoleUsageString, rc.message)
+		return
+	}
+
+	var (
+		target  = rc.message.ReplyTo.Sender
+		name    = escapeMarkdown(senderName(target))
+		role    = permissions.Role(chat, rc.message.Sender, rc.game)
+		current = permissions.Role(chat, target, rc.game)
+	)
+	if strings.ToLower(fields[0]) == "reset" {
+		if !canAssign(role, current, ObserverRole) {
+			rc.output <- NewTextMessage(chat, fmt.Sprintf(RoleNotAllowedString, name), rc.message)
+			return
+		}
+		rc.game.ResetMemberRole(target.ID)
+		saveSettings(rc.game)
+		current = permissions.Role(chat, target, rc.game)
+		rc.output <- NewTextMessage(chat, fmt.Sprintf(RoleResetString, name, current.Title()), rc.message)
+		return
+	}
+
+	assigned, err := ParseRole(fields[0])
+	if err != nil {
+		rc.output <- NewTextMessage(chat, fmt.Sprintf(UnknownRoleString, fields[0]), rc.message)
+		return
+	}
+	if !canAssign(role, current, assigned) {
+		rc.output <- NewTextMessage(chat, fmt.Sprintf(RoleNotAllowedString, name), rc.message)
+		return
+	}
+	log.Printf("[INFO] %s assigns role %s to %s in chat %d", senderName(rc.message.Sender), assigned, senderName(target), chat.ID)
+	rc.game.SetMemberRole(target.ID, MemberRole{Name: senderName(target), Role: assigned})
+	saveSettings(rc.game)
+	rc.output <- NewTextMessage(chat, fmt.Sprintf(RoleSavedString, name, assigned.Title()), rc.message)
+}
+
+// NewRoleCommand - constructor for the RoleCommand
+func NewRoleCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return RoleCommand{BaseCommand{output, message, game}}, nil
+}
+
+// currentLevel returns the current level of the game, if there is no game or level
+// yet, then replies to the command and returns nil
+func (bc BaseCommand) currentLevel() *en.Level {
+	if bc.game == nil {
+		bc.output <- NewTextMessage(bc.message.Chat, NoGameString, bc.message)
+		return nil
+	}
+	level := bc.game.CurrentLevel()
+	if level == nil {
+		bc.output <- NewTextMessage(bc.message.Chat, NoLevelString, bc.message)
+	}
+	return level
+}
+
+// usage replies that arguments of the command are missing
+func (bc BaseCommand) usage(command string) {
+	bc.output <- NewTextMessage(bc.message.Chat, fmt.Sprintf(UsageString, command), bc.message)
+}
+
+// SetChatCommand handler for 'setchat' command, creates the game with the default
+// settings from the environment for the chat
+type SetChatCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (sc SetChatCommand) Process(args ...string) {
+	if DEBUG {
+		log.Printf("SetChatCommand is executed")
+	}
+
+	// default settings hold the credentials of the bot operator, so the role assigned
+	// in the chat is not enough
+	if !permissions.IsOwner(sc.message.Sender) {
+		sc.output <- NewTextMessage(sc.message.Chat, BotOwnersOnlyString, sc.message)
+		return
+	}
+	if sc.game != nil {
+		stopWatching(sc.game)
+	}
+	setChat(sc.message.Chat)
+	sc.output <- NewTextMessage(sc.message.Chat, ChatSetString, sc.message)
+}
+
+// NewSetChatCommand - constructor for the SetChatCommand
+func NewSetChatCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return SetChatCommand{BaseCommand{output, message, game}}, nil
+}
+
+// WatchCommand handler for 'watch' command, starts monitoring of the game
+type WatchCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (wc WatchCommand) Process(args ...string) {
+	if DEBUG {
+		log.Printf("WatchCommand is executed")
+	}
+
+	if wc.game == nil {
+		wc.output <- NewTextMessage(wc.message.Chat, NoGameString, wc.message)
+		return
+	}
+	startWatching(wc.game)
+	saveSettings(wc.game)
+	wc.output <- NewTextMessage(wc.message.Chat, WatchStartedString, wc.message)
+}
+
+// NewWatchCommand - constructor for the WatchCommand
+func NewWatchCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return WatchCommand{BaseCommand{output, message, game}}, nil
+}
+
+// StopWatchingCommand handler for 'stopwatching' command, stops monitoring of the game
+type StopWatchingCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (sc StopWatchingCommand) Process(args ...string) {
+	if DEBUG {
+		log.Printf("StopWatchingCommand is executed")
+	}
+
+	if sc.game == nil {
+		sc.output <- NewTextMessage(sc.message.Chat, NoGameString, sc.message)
+		return
+	}
+	stopWatching(sc.game)
+	saveSettings(sc.game)
+	sc.output <- NewTextMessage(sc.message.Chat, WatchStoppedString, sc.message)
+}
+
+// NewStopWatchingCommand - constructor for the StopWatchingCommand
+func NewStopWatchingCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return StopWatchingCommand{BaseCommand{output, message, game}}, nil
+}
+
+// CodeCommand handler for 'c' command, sends the codes separated by spaces to the engine
+type CodeCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (cc CodeCommand) Process(args ...string) {
+	var codes = strings.Fields(strings.Join(args, " "))
+	if DEBUG {
+		log.Printf("CodeCommand is executed")
+	}
+
+	if len(codes) == 0 {
+		cc.usage("c")
+		return
+	}
+	if cc.currentLevel() == nil {
+		return
+	}
+	sendCode(cc.game, codes, cc.message)
+}
+
+// NewCodeCommand - constructor for the CodeCommand
+func NewCodeCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return CodeCommand{BaseCommand{output, message, game}}, nil
+}
+
+// CompositeCodeCommand handler for 'cc' command, sends the whole argument to the engine
+// as one code, so that codes with spaces can be sent
+type CompositeCodeCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (cc CompositeCodeCommand) Process(args ...string) {
+	var code = strings.TrimSpace(strings.Join(args, " "))
+	if DEBUG {
+		log.Printf("CompositeCodeCommand is executed")
+	}
+
+	if code == "" {
+		cc.usage("cc")
+		return
+	}
+	if cc.currentLevel() == nil {
+		return
+	}
+	sendCode(cc.game, []string{code}, cc.message)
+}
+
+// NewCompositeCodeCommand - constructor for the CompositeCodeCommand
+func NewCompositeCodeCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return CompositeCodeCommand{BaseCommand{output, message, game}}, nil
+}
+
+// BonusCodeCommand handler for 'b' command, sends the bonus codes separated by spaces
+// to the engine
+type BonusCodeCommand struct {
+	BaseCommand
+}
+
+// Process is required to implement Command interface
+func (bc BonusCodeCommand) Process(args ...string) {
+	var codes = strings.Fields(strings.Join(args, " "))
+	if DEBUG {
+		log.Printf("BonusCodeCommand is executed")
+	}
+
+	if len(codes) == 0 {
+		bc.usage("b")
+		return
+	}
+	if bc.currentLevel() == nil {
+		return
+	}
+	sendBonusCode(bc.game, codes, bc.message)
+}
+
+// NewBonusCodeCommand - constructor for the BonusCodeCommand
+func NewBonusCodeCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return BonusCodeCommand{BaseCommand{output, message, game}}, nil
+}
+
+// LevelInfoCommand handler for the commands that send some information about the
+// current level: sectors left, time left, hints and time before the next hint
+type LevelInfoCommand struct {
+	BaseCommand
+	send func(tb.Recipient, *en.Level)
+}
+
+// Process is required to implement Command interface
+func (lc LevelInfoCommand) Process(args ...string) {
+	if DEBUG {
+		log.Printf("LevelInfoCommand is executed")
+	}
+
+	if level := lc.currentLevel(); level != nil {
+		lc.send(lc.game.Chat, level)
+	}
+}
+
+// newLevelInfoCommand returns the factory of the LevelInfoCommand that sends the
+// information with the function
+func newLevelInfoCommand(send func(tb.Recipient, *en.Level)) CommandFactory {
+	return func(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+		return LevelInfoCommand{BaseCommand{output, message, game}, send}, nil
+	}
+}
+
+// HelpCommand handler for 'help' command, lists the commands available for the user
+// or describes the command from the argument
+type HelpCommand struct {
+	BaseCommand
+	store *CommandStore
+}
+
+// Process is required to implement Command interface
+func (hc HelpCommand) Process(args ...string) {
+	var (
+		name = strings.TrimPrefix(strings.TrimSpace(strings.Join(args, " ")), "/")
+		role = permissions.Role(hc.message.Chat, hc.message.Sender, hc.game)
+	)
+	if DEBUG {
+		log.Printf("HelpCommand is executed")
+	}
+
+	if name != "" {
+		info, ok := hc.store.Info(name)
+		if !ok {
+			hc.output <- NewTextMessage(hc.message.Chat, fmt.Sprintf(UnknownHelpString, escapeMarkdown(name)), hc.message)
+			return
+		}
+		hc.output <- NewTextMessage(hc.message.Chat, fmt.Sprintf(CommandHelpString,
+			info.Name, info.Args, info.Help, info.Role.Title()), hc.message)
+		return
+	}
+
+	var lines []string
+	for _, info := range hc.store.Commands(role) {
+		var aliases string
+		for _, alias := range info.Aliases {
+			aliases += ", /" + alias
+		}
+		lines = append(lines, fmt.Sprintf(HelpLineString, info.Name, aliases, info.Description))
+	}
+	hc.output <- NewTextMessage(hc.message.Chat, fmt.Sprintf(HelpString, strings.Join(lines, "\n")), hc.message)
 }
 
 // CommandFactory factory type that is stored in the CommandStore. User-defined commands
 // should implement this method
-type CommandFactory func(chan MessageSender, tb.Message, *en.Level) (Command, error)
+type CommandFactory func(chan MessageSender, tb.Message, *Game) (Command, error)
 
-// CommandStore structure to store user-defined command factories
+// CommandInfo command in the CommandStore with its description for the help
+type CommandInfo struct {
+	// Name of the command, it is shown in the menu of the bot in Telegram
+	Name string
+	// Aliases other names of the command, e.g. in Cyrillic
+	Aliases []string
+	// Role lowest role of the user that can run the command
+	Role Role
+	// Args arguments of the command for the help
+	Args string
+	// Description short description of the command for the list of the commands
+	Description string
+	// Help detailed description of the command
+	Help    string
+	Factory CommandFactory
+}
+
+// CommandStore structure to store user-defined command factories by names and aliases
+// of the commands
 type CommandStore struct {
 	*sync.RWMutex
-	register map[string]CommandFactory
+	register map[string]*CommandInfo
+	// commands in the order of registration, without aliases
+	commands []*CommandInfo
 }
 
 // NewCommandStore creates a new store and return a reference to it
 func NewCommandStore() *CommandStore {
 	return &CommandStore{
 		RWMutex:  &sync.RWMutex{},
-		register: make(map[string]CommandFactory),
+		register: make(map[string]*CommandInfo),
 	}
 }
 
-// Get tries to find the command handler in store for the provided key. If command
-// is not found then returns error that command is not registered in store
-func (cr CommandStore) Get(key string) (CommandFactory, error) {
+// Get tries to find the command handler in store for the provided key. If the role
+// of the user is lower than the command requires, then returns the handler that
+// replies that command is not allowed and ErrNotAllowed. If command is not found
+// then returns error that command is not registered in store
+func (cr *CommandStore) Get(key string, role Role) (CommandFactory, error) {
 	cr.RLock()
 	defer cr.RUnlock()
-	handler, exist := cr.register[key]
-	if exist {
-		return handler, nil
+	info, exist := cr.register[key]
+	if !exist {
+		return NewUnknownCommand, fmt.Errorf("Command '%s' is not registered in store", key)
+	}
+	if role < info.Role {
+		return newNotAllowedCommand(info.Role, role), fmt.Errorf("%w: '%s' requires %s role", ErrNotAllowed, key, info.Role)
 	}
+	return info.Factory, nil
+}
 
-	return NewUnknownCommand, fmt.Errorf("Command '%s' is not registered in store", key)
+// Info returns the command by its name or alias
+func (cr *CommandStore) Info(key string) (CommandInfo, bool) {
+	cr.RLock()
+	defer cr.RUnlock()
+	if info, exist := cr.register[key]; exist {
+		return *info, true
+	}
+	return CommandInfo{}, false
 }
 
-// Register is used to add user-defined command factory to the store
-func (cr CommandStore) Register(command string, commandFactory CommandFactory) {
+// Commands returns the commands that the user with the role can run
+func (cr *CommandStore) Commands(role Role) (commands []CommandInfo) {
+	cr.RLock()
+	defer cr.RUnlock()
+	for _, info := range cr.commands {
+		if info.Role <= role {
+			commands = append(commands, *info)
+		}
+	}
+	return
+}
+
+// BotCommands returns the commands that the user with the role can run in the format
+// of the menu of the bot in Telegram
+func (cr *CommandStore) BotCommands(role Role) (commands []BotCommand) {
+	for _, info := range cr.Commands(role) {
+		commands = append(commands, BotCommand{Command: info.Name, Description: info.Description})
+	}
+	return
+}
+
+// Register is used to add user-defined command to the store
+func (cr *CommandStore) Register(info CommandInfo) {
 	cr.Lock()
 	defer cr.Unlock()
-	cr.register[command] = commandFactory
+	cr.commands = append(cr.commands, &info)
+	cr.register[info.Name] = &info
+	for _, alias := range info.Aliases {
+		cr.register[alias] = &info
+	}
+}
+
+// newHelpCommand constructor for the HelpCommand that describes the commands of the store
+func (cr *CommandStore) newHelpCommand(output chan MessageSender, message tb.Message, game *Game) (Command, error) {
+	return HelpCommand{BaseCommand{output, message, game}, cr}, nil
+}
+
+func (cr *CommandStore) init() {
+	cr.Register(CommandInfo{Name: "help", Role: ObserverRole, Args: "команда",
+		Description: "Список команд",
+		Help:        "Показывает команды, доступные вам, или описание команды из аргумента",
+		Factory:     cr.newHelpCommand})
+	cr.Register(CommandInfo{Name: "info", Role: ObserverRole,
+		Description: "Задание текущего уровня",
+		Help:        "Присылает задание, координаты и картинки текущего уровня",
+		Factory:     NewInfoCommand})
+	cr.Register(CommandInfo{Name: "c", Aliases: []string{"с"}, Role: PlayerRole, Args: "код1 код2 ...",
+		Description: "Отправить коды",
+		Help:        "Отправляет в движок коды, разделенные пробелами, и отвечает, какие из них верные",
+		Factory:     NewCodeCommand})
+	cr.Register(CommandInfo{Name: "cc", Aliases: []string{"сс"}, Role: PlayerRole, Args: "код с пробелами",
+		Description: "Отправить код с пробелами",
+		Help:        "Отправляет в движок весь текст после команды одним кодом",
+		Factory:     NewCompositeCodeCommand})
+	cr.Register(CommandInfo{Name: "b", Aliases: []string{"б"}, Role: PlayerRole, Args: "код1 код2 ...",
+		Description: "Отправить бонусные коды",
+		Help:        "Отправляет в движок бонусные коды, разделенные пробелами, на уровнях, где бонусы вводятся отдельно",
+		Factory:     NewBonusCodeCommand})
+	cr.Register(CommandInfo{Name: "sl", Aliases: []string{"ос"}, Role: ObserverRole,
+		Description: "Оставшиеся сектора",
+		Help:        "Показывает, сколько секторов осталось закрыть на уровне, и их названия",
+		Factory:     newLevelInfoCommand(sectorsLeft)})
+	cr.Register(CommandInfo{Name: "tl", Aliases: []string{"ов"}, Role: ObserverRole,
+		Description: "Время до автоперехода",
+		Help:        "Показывает, сколько времени осталось до автоперехода на следующий уровень",
+		Factory:     newLevelInfoCommand(timeLeft)})
+	cr.Register(CommandInfo{Name: "lh", Role: ObserverRole,
+		Description: "Подсказки уровня",
+		Help:        "Присылает тексты всех подсказок текущего уровня",
+		Factory:     newLevelInfoCommand(listHelps)})
+	cr.Register(CommandInfo{Name: "ht", Role: ObserverRole,
+		Description: "Время до подсказки",
+		Help:        "Показывает, сколько времени осталось до следующей подсказки",
+		Factory:     newLevelInfoCommand(timeHelpLeft)})
+	cr.Register(CommandInfo{Name: "ph", Role: PlayerRole, Args: "номер",
+		Description: "Штрафные подсказки",
+		Help:        "Без аргумента показывает штрафные подсказки уровня, с номером просит взять подсказку, подтвердить ее может капитан",
+		Factory:     NewPenaltyHelpsCommand})
+	cr.Register(CommandInfo{Name: "bonuses", Role: ObserverRole,
+		Description: "Бонусы уровня",
+		Help:        "Показывает все бонусы текущего уровня и их состояние",
+		Factory:     NewBonusesCommand})
+	cr.Register(CommandInfo{Name: "route", Role: ObserverRole,
+		Description: "Маршрут по координатам уровня",
+		Help:        "Присылает ссылки на все координаты уровня, подсказок и бонусов и маршрут через них",
+		Factory:     NewRouteCommand})
+	cr.Register(CommandInfo{Name: "history", Role: ObserverRole, Args: "номер уровня",
+		Description: "История уровня",
+		Help:        "Показывает коды, сектора и подсказки текущего уровня или уровня с указанным номером",
+		Factory:     NewHistoryCommand})
+	cr.Register(CommandInfo{Name: "watch", Role: CaptainRole,
+		Description: "Следить за игрой",
+		Help:        "Бот начинает следить за игрой и присылает в чат новые уровни, подсказки и закрытые сектора",
+		Factory:     NewWatchCommand})
+	cr.Register(CommandInfo{Name: "stopwatching", Role: CaptainRole,
+		Description: "Перестать следить за игрой",
+		Help:        "Бот перестает следить за игрой и присылать события уровня",
+		Factory:     NewStopWatchingCommand})
+	cr.Register(CommandInfo{Name: "alerts", Role: CaptainRole, Args: "тип значения",
+		Description: "Настройки уведомлений",
+		Help:        "Без аргументов показывает пороги уведомлений, с аргументами меняет их, reset возвращает настройки по умолчанию",
+		Factory:     NewAlertsCommand})
+	cr.Register(CommandInfo{Name: "maps", Role: CaptainRole, Args: "карты",
+		Description: "Карты для ссылок на координаты",
+		Help:        "Без аргумента показывает карты чата, с названием меняет карты для ссылок на координаты",
+		Factory:     NewMapsCommand})
+	cr.Register(CommandInfo{Name: "token", Role: CaptainRole, Args: "revoke",
+		Description: "Токен API для штаба",
+		Help:        "Выдает новый токен API и ссылку на штаб, с аргументом revoke отзывает все токены чата",
+		Factory:     NewTokenCommand})
+	cr.Register(CommandInfo{Name: "roles", Role: ObserverRole,
+		Description: "Роли в чате",
+		Help:        "Показывает вашу роль и роли, назначенные в чате",
+		Factory:     NewRolesCommand})
+	cr.Register(CommandInfo{Name: "role", Role: CaptainRole, Args: "роль или reset",
+		Description: "Назначить роль",
+		Help:        "В ответ на сообщение участника назначает ему роль или сбрасывает ее",
+		Factory:     NewRoleCommand})
+	cr.Register(CommandInfo{Name: "start", Role: CaptainRole,
+		Description: "Настроить игру для чата",
+		Help:        "Пошагово настраивает домен, игру, логин и пароль игрока для этого чата",
+		Factory:     NewStartCommand})
+	cr.Register(CommandInfo{Name: "setchat", Role: OwnerRole,
+		Description: "Игра по умолчанию для чата",
+		Help:        "Настраивает для чата игру из настроек бота по умолчанию",
+		Factory:     NewSetChatCommand})
 }
 
-func (cr CommandStore) init() {
-	cr.Register("info", NewInfoCommand)
-	cr.Register("start", NewStartCommand)
+// commandScopes lowest roles of the users that see the commands in the menu of the
+// bot in the scope
+var commandScopes = []struct {
+	scope string
+	role  Role
+}{
+	{DefaultCommandScope, PlayerRole},
+	{PrivateChatsCommandScope, CaptainRole},
+	// creator and admins of the chat are captains, owners of the bot are not known
+	// to Telegram and see the commands of captains too
+	{AdminsCommandScope, CaptainRole},
+}
+
+// registerCommands sets the commands of the store as the menu of the bot in Telegram
+func registerCommands(bot *TelegramBot, store *CommandStore) {
+	for _, scope := range commandScopes {
+		if err := bot.SetMyCommands(store.BotCommands(scope.role), scope.scope); err != nil {
+			log.Printf("[ERROR] Can't register commands for %s scope: %s", scope.scope, err)
+		}
+	}
 }
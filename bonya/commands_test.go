@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tb "github.com/tucnak/telebot"
+)
+
+func TestCommandStoreAliases(t *testing.T) {
+	var store = NewCommandStore()
+	store.init()
+
+	var tests = []struct {
+		key  string
+		name string
+	}{
+		{"c", "c"},
+		{"с", "c"},
+		{"сс", "cc"},
+		{"б", "b"},
+		{"ос", "sl"},
+		{"ов", "tl"},
+		{"help", "help"},
+	}
+	for _, test := range tests {
+		if info, ok := store.Info(test.key); !ok || info.Name != test.name {
+			t.Errorf("Expected %q to be alias of %q, got %+v", test.key, test.name, info)
+		}
+		if _, err := store.Get(test.key, OwnerRole); err != nil {
+			t.Errorf("Command %q is not registered: %s", test.key, err)
+		}
+	}
+
+	for _, info := range store.Commands(OwnerRole) {
+		if info.Description == "" || info.Help == "" {
+			t.Errorf("Command %q has no help", info.Name)
+		}
+		if strings.Trim(info.Name, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+			t.Errorf("Command %q can't be registered in Telegram", info.Name)
+		}
+	}
+	for _, info := range store.Commands(PlayerRole) {
+		if info.Role > PlayerRole {
+			t.Errorf("Command %q is not available for players", info.Name)
+		}
+	}
+}
+
+func TestHelpCommand(t *testing.T) {
+	var (
+		store    = NewCommandStore()
+		messages = collectMessages()
+		message  = tb.Message{Chat: tb.Chat{ID: testChatID}, Sender: tb.User{ID: 7}}
+	)
+	defer messages.stop()
+	store.init()
+
+	factory, _ := store.Get("help", PlayerRole)
+	command, _ := factory(messageChan, message, nil)
+	command.Process("")
+	messages.waitFor(t, "/c, /с — Отправить коды", time.Second)
+	command.Process("/ос")
+	messages.waitFor(t, "*/sl* \nПоказывает, сколько секторов осталось закрыть", time.Second)
+	command.Process("unknown")
+	messages.waitFor(t, "Команды /unknown нет", time.Second)
+
+	messages.Lock()
+	defer messages.Unlock()
+	if strings.Contains(messages.texts[0], "/setchat") {
+		t.Errorf("Player sees commands of the owner: %s", messages.texts[0])
+	}
+}
+
+func TestLegacyCommandsEndToEnd(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		game     = newTestGame(t, engine)
+		messages = collectMessages()
+		store    = NewCommandStore()
+		message  = tb.Message{Chat: game.Chat, Sender: tb.User{ID: testCaptainID, Username: "captain"}}
+		run      = func(name string, args string) {
+			factory, err := store.Get(name, CaptainRole)
+			if err != nil {
+				t.Fatalf("Can't run %s: %s", name, err)
+			}
+			command, _ := factory(messageChan, message, game)
+			command.Process(args)
+		}
+	)
+	defer engine.Close()
+	defer messages.stop()
+	store.init()
+
+	run("ос", "")
+	messages.waitFor(t, NoLevelString, time.Second)
+
+	run("watch", "")
+	messages.waitFor(t, WatchStartedString, time.Second)
+	waitForLevel(t, game)
+
+	run("с", "  ")
+	messages.waitFor(t, "Не хватает аргументов, подробнее: /help c", time.Second)
+	run("c", "code1 wrong")
+	messages.waitFor(t, "*+* code1", time.Second)
+	messages.waitFor(t, "*-* wrong", time.Second)
+	run("cc", "code 2")
+	messages.waitFor(t, "*-* code 2", time.Second)
+	run("sl", "")
+	messages.waitFor(t, "Осталось *2* из *3*", time.Second)
+	run("tl", "")
+	messages.waitFor(t, "*ГО, КиПеш, ГО!!!*", time.Second)
+
+	run("stopwatching", "")
+	messages.waitFor(t, WatchStoppedString, time.Second)
+	game.RLock()
+	defer game.RUnlock()
+	if game.cancel != nil {
+		t.Errorf("Game is still monitored")
+	}
+}
+
+func TestRegisterCommands(t *testing.T) {
+	var requests = make(map[string][]BotCommand)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request setMyCommandsRequest
+		if r.URL.Path != "/bottoken/setMyCommands" || json.NewDecoder(r.Body).Decode(&request) != nil {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		requests[request.Scope.Type] = request.Commands
+		w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+	defer server.Close()
+
+	var store = NewCommandStore()
+	store.init()
+	bot := NewTelegramBot(nil, "token")
+	bot.APIURL = server.URL
+	registerCommands(bot, store)
+
+	if len(requests) != len(commandScopes) {
+		t.Fatalf("Expected commands for %d scopes, got %v", len(commandScopes), requests)
+	}
+	if !reflect.DeepEqual(requests[DefaultCommandScope], store.BotCommands(PlayerRole)) ||
+		!reflect.DeepEqual(requests[AdminsCommandScope], store.BotCommands(CaptainRole)) {
+		t.Errorf("Unexpected commands %v", requests)
+	}
+	if requests[DefaultCommandScope][1] != (BotCommand{Command: "info", Description: "Задание текущего уровня"}) {
+		t.Errorf("Unexpected command %v", requests[DefaultCommandScope][1])
+	}
+}
+
+func TestTokenCommand(t *testing.T) {
+	var (
+		game    = NewGame(tb.Chat{ID: testChatID, Title: "Команда_1"}, &GameSettings{ChatID: testChatID})
+		sender  = tb.User{ID: testCaptainID, Username: "captain"}
+		message = tb.Message{Chat: game.Chat, Sender: sender}
+	)
+
+	for _, example := range []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"sent", nil, TokenSentString},
+		{"chat is not started", errors.New("telebot: Forbidden: bot can't initiate conversation with a user"), TokenNotSentString},
+	} {
+		command, _ := NewTokenCommand(messageChan, message, game)
+		go command.Process("")
+		private := (<-messageChan).(*ReportedMessage)
+		if private.Recipient.Destination() != strconv.Itoa(testCaptainID) ||
+			!strings.Contains(private.Text, "*Токен для API чата* Команда\\_1:") {
+			t.Errorf("%s: expected token in the private chat, got %q to %s", example.name, private.Text, private.Recipient.Destination())
+		}
+		private.sent(example.err)
+		reply := (<-messageChan).(*TextMessage)
+		if reply.Recipient.Destination() != game.Chat.Destination() || reply.Text != example.expected {
+			t.Errorf("%s: expected reply %q without the token in the group, got %q", example.name, example.expected, reply.Text)
+		}
+		token := strings.Split(private.Text, "`")[1]
+		if chatID, ok := tokens.Chat(token); !ok || chatID != testChatID {
+			t.Errorf("%s: expected token for the group chat, got %d", example.name, chatID)
+		}
+	}
+}
+
+func TestSetChatCommandNotOwner(t *testing.T) {
+	var (
+		messages = collectMessages()
+		chat     = tb.Chat{ID: 777, Type: tb.Group}
+		// the role in the chat doesn't allow to use the settings of the bot operator
+		message = tb.Message{Chat: chat, Sender: tb.User{ID: testCaptainID}}
+	)
+	defer messages.stop()
+
+	command, _ := NewSetChatCommand(messageChan, message, nil)
+	command.Process()
+	messages.waitFor(t, BotOwnersOnlyString, time.Second)
+	if _, err := games.Get(chat.ID); err == nil {
+		t.Errorf("Expected game not to be configured for the chat")
+	}
+}
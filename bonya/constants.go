@@ -1,72 +1,346 @@
 package main
 
-// BotCommand - int8 alias
-type BotCommand int8
+// QuestDomains dictionary that stores the urls to different game engines
+// Later this can be stored in the database and extended by talking to bot
+var QuestDomains = map[string]string{
+	"quest.ua":         "http://quest.ua",
+	"kharkov.quest.ua": "http://kharkov.quest.ua",
+	"kharkov.en.cx":    "http://kharkov.en.cx",
+}
+
+// EngineDomainSuffixes domains of the engines that can be entered manually besides
+// the QuestDomains, e.g. "moscow.en.cx"
+var EngineDomainSuffixes = []string{".en.cx", ".quest.ua"}
+
+// ButtonsPerRow number of buttons that should be displayed in one row
+const ButtonsPerRow = 2
+
+const (
+	// NoGameString message for the chats where game is not configured yet
+	NoGameString = "Для этого чата игра не настроена, используйте /start или /setchat"
+
+	// NoLevelString message when there is no information about level yet
+	NoLevelString = "Нет информации об уровне, используйте /watch"
+)
 
 const (
-	// InfoCommand - command 'info' shows information of the level
-	// InfoCommand BotCommand = 1 + iota
+	// ChooseDomainString first step of the game configuration
+	ChooseDomainString = "Выберите домен:"
 
-	// SetChatIDCommand - command 'setchat' defines the chat where to send level updates
-	SetChatIDCommand BotCommand = 2 + iota
+	// IncorrectDomainString domain is not known and is not a domain of the engine
+	IncorrectDomainString = "Неизвестный домен %q, выберите домен из списка"
 
-	// WatchCommand - command 'watch' tells bot to monitor state of game
-	WatchCommand
+	// DomainChosenString confirmation of the chosen domain
+	DomainChosenString = "Домен: *%s*"
 
-	// StopWatchingCommand - command 'stopwatching' tells to stop monitor game
-	StopWatchingCommand
+	// EnterGameString asks the id of the game
+	EnterGameString = "Введите номер игры или ссылку на игру:"
 
-	// CodeCommand - command 'c' sends code to EN engine
-	CodeCommand
+	// IncorrectGameString game id is not a number
+	IncorrectGameString = "Неверный номер игры %q, попробуйте еще раз"
 
-	// CompositeCodeCommand - command 'cc' sends composite code to EN engine
-	CompositeCodeCommand
+	// EnterLoginString asks the login
+	EnterLoginString = "Введите логин игрока, под которым бот будет заходить в игру:"
 
-	// SectorsLeftCommand - command 'sl' sends message with list of sectors left
-	SectorsLeftCommand
+	// EnterPasswordString asks the password
+	EnterPasswordString = "Введите пароль:"
 
-	// TimeLeftCommand - command 'tl' sends message with time left for level
-	TimeLeftCommand
+	// PasswordDeletedString warns that the password was entered in the group chat
+	PasswordDeletedString = "Сообщение с паролем удалено. Не отправляйте пароль в общий чат, " +
+		"настраивайте игру в личных сообщениях с ботом"
 
-	// ListHelpsCommand - command 'lh' sends message with helps
-	ListHelpsCommand
+	// LoginFailedString test login failed
+	LoginFailedString = "Не удалось войти в движок: %s"
 
-	// HelpTimeCommand - command 'ht' sends message with time before help
-	HelpTimeCommand
+	// ConfirmSettingsString all the settings before saving
+	ConfirmSettingsString = `
+*Домен:* %s
+*Игра:* %d
+*Логин:* %s
+Вход выполнен успешно, сохранить настройки?`
 
-	// StartCommand - command 'start' starts the process of configuing bot to monitor game
-	// StartCommand
+	// SettingsSavedString settings are saved
+	SettingsSavedString = "Настройки сохранены, используйте /watch чтобы следить за игрой"
 
-	// TestHelpChange - [REMOVE] - command '' test command to send message with help
-	TestHelpChange
+	// SettingsCanceledString settings are not saved
+	SettingsCanceledString = "Настройки не сохранены"
 )
 
-// BotCommandDict - dictionary with all bot commands
-var BotCommandDict = map[string]BotCommand{
-	// "info":         InfoCommand,
-	"setchat":      SetChatIDCommand,
-	"watch":        WatchCommand,
-	"stopwatching": StopWatchingCommand,
-	"c":            CodeCommand,
-	"с":            CodeCommand,
-	"cc":           CompositeCodeCommand,
-	"сс":           CompositeCodeCommand,
-	"sl":           SectorsLeftCommand,
-	"ос":           SectorsLeftCommand,
-	"tl":           TimeLeftCommand,
-	"ов":           TimeLeftCommand,
-	"lh":           ListHelpsCommand,
-	"ht":           HelpTimeCommand,
-	// "start":        StartCommand,
-	"helpchange": TestHelpChange}
+const (
+	// HistoryString timeline of the level
+	HistoryString = "*История уровня %d:*\n%s"
 
-// QuestDomains dictionary that stores the urls to different game engines
-// Later this can be stored in the database and extended by talking to bot
-var QuestDomains = map[string]string{
-	"quest.ua":         "http://quest.ua",
-	"kharkov.quest.ua": "http://kharkov.quest.ua",
-	"kharkov.en.cx":    "http://kharkov.en.cx",
-}
+	// EmptyHistoryString there are no records for the level
+	EmptyHistoryString = "История уровня %d пуста"
 
-// ButtonsPerRow number of buttons that should be displayed in one row
-const ButtonsPerRow = 2
+	// IncorrectLevelString level number in the argument is not a number
+	IncorrectLevelString = "Неверный номер уровня %q"
+
+	// HistoryAuthorString record with the player who entered the code
+	HistoryAuthorString = "%s (%s)"
+
+	// HistoryLevelString level is started
+	HistoryLevelString = "Уровень %d: %s"
+
+	// HistoryHelpString hint is opened
+	HistoryHelpString = "Подсказка %d"
+
+	// HistoryPenaltyHelpString penalty hint is opened
+	HistoryPenaltyHelpString = "Штрафная подсказка %d"
+
+	// HistorySectorString sector is closed
+	HistorySectorString = "Сектор %s: %s"
+
+	// HistoryBonusString bonus is closed
+	HistoryBonusString = "Бонус %s: %s"
+
+	// HistoryCorrectCodeString correct code is entered
+	HistoryCorrectCodeString = "+ %s"
+
+	// HistoryIncorrectCodeString incorrect code is entered
+	HistoryIncorrectCodeString = "- %s"
+)
+
+const (
+	// NoPenaltyHelpsString there are no penalty hints on the level
+	NoPenaltyHelpsString = "Штрафных подсказок на уровне нет"
+
+	// PenaltyHelpsString list of the penalty hints
+	PenaltyHelpsString = "*Штрафные подсказки:*\n%s"
+
+	// PenaltyHelpString penalty hint in the list, number, penalty, comment and state
+	PenaltyHelpString = "*%d.* штраф %s%s: %s"
+
+	// PenaltyHelpOpenedString state of the hint that is already opened
+	PenaltyHelpOpenedString = "открыта"
+
+	// PenaltyHelpWaitString state of the hint that is not available yet
+	PenaltyHelpWaitString = "будет доступна через %s"
+
+	// PenaltyHelpAvailableString state of the hint that can be requested
+	PenaltyHelpAvailableString = "можно взять /ph %d"
+
+	// PenaltyHelpNotFoundString there is no penalty hint with the number
+	PenaltyHelpNotFoundString = "Штрафной подсказки %q нет на уровне"
+
+	// PenaltyHelpAlreadyOpenedString hint is opened already
+	PenaltyHelpAlreadyOpenedString = "Штрафная подсказка %d уже открыта"
+
+	// PenaltyHelpNotAvailableString hint can't be requested yet
+	PenaltyHelpNotAvailableString = "Штрафная подсказка %d будет доступна через %s"
+
+	// PenaltyHelpConfirmString asks to confirm the request of the hint
+	PenaltyHelpConfirmString = "Взять штрафную подсказку %d?\nВы уверены? -%s"
+
+	// PenaltyHelpRequestedString hint is opened after the request
+	PenaltyHelpRequestedString = "%s взял штрафную подсказку %d"
+
+	// PenaltyHelpFailedString engine didn't open the hint
+	PenaltyHelpFailedString = "Не удалось взять штрафную подсказку %d: %s"
+
+	// PenaltyHelpCanceledString request of the hint is canceled
+	PenaltyHelpCanceledString = "Штрафная подсказка не взята"
+
+	// PenaltyHelpRequestingString hint is requested by someone else already
+	PenaltyHelpRequestingString = "Штрафная подсказка %d уже запрошена"
+
+	// PenaltyHelpAnsweredString confirmation of the hint without the keyboard
+	PenaltyHelpAnsweredString = "%s\n\nОтвет от %s"
+)
+
+const (
+	// NoBonusesString there are no bonuses on the level
+	NoBonusesString = "Бонусов на уровне нет"
+
+	// BonusesString list of the bonuses
+	BonusesString = "*Бонусы:*\n%s"
+
+	// BonusString bonus in the list, number, name and state. Names are escaped and put
+	// outside of the entities, escapes don't work inside the entity in Markdown
+	BonusString = "*%d.* %s: %s"
+
+	// BonusTaskString task of the bonus in the list
+	BonusTaskString = "\n%s"
+
+	// BonusOpenString bonus can be answered
+	BonusOpenString = "открыт"
+
+	// BonusOpenTillString bonus can be answered for some time
+	BonusOpenTillString = "открыт, осталось %s"
+
+	// BonusUpcomingString bonus is not available yet
+	BonusUpcomingString = "будет доступен через %s"
+
+	// BonusExpiredString time of the bonus is over
+	BonusExpiredString = "время вышло"
+
+	// BonusDoneString bonus is answered
+	BonusDoneString = "выполнен"
+
+	// BonusDoneAwardString bonus is answered and has award time
+	BonusDoneAwardString = "выполнен, бонус %s"
+
+	// BonusAppearedString notification that bonus can be answered
+	BonusAppearedString = "Бонус *%d.* %s доступен для ввода%s"
+
+	// BonusAppearedTillString time left for the bonus that became available
+	BonusAppearedTillString = ", осталось %s"
+
+	// BonusExpiringString notification that bonus is about to close
+	BonusExpiringString = "Бонус *%d.* %s закроется через %s"
+)
+
+const (
+	// AlertsString current thresholds of the notifications for the chat
+	AlertsString = `*Оповещения:*
+Время уровня: %s
+Подсказки: %s
+Бонусы: %s
+Секторы: %s
+
+Изменить: /alerts time|help|bonus <минуты> или off, /alerts sectors <количество> или off, /alerts reset`
+
+	// AlertsMinutesString thresholds in minutes
+	AlertsMinutesString = "за %s мин"
+
+	// AlertsSectorsString threshold for the sectors
+	AlertsSectorsString = "когда осталось %d и меньше"
+
+	// AlertsOffString notifications are turned off
+	AlertsOffString = "выключены"
+
+	// AlertsSavedString thresholds are changed
+	AlertsSavedString = "Оповещения изменены"
+
+	// AlertsUnknownKindString user entered unknown kind of notifications
+	AlertsUnknownKindString = "Неизвестный тип оповещений %q, используйте time, help, bonus, sectors или reset"
+
+	// AlertsNoValuesString user didn't enter values for the notifications
+	AlertsNoValuesString = "Укажите значения для %s или off"
+
+	// AlertsIncorrectValueString user entered incorrect value
+	AlertsIncorrectValueString = "Некорректное значение %q"
+)
+
+const (
+	// CoordinatesString list of the coordinates with links to the map
+	CoordinatesString = "*Координаты:*\n%s"
+
+	// CoordinateString coordinate in the list, number, latitude, longitude and link
+	CoordinateString = "%d. [%.6f, %.6f](%s)"
+
+	// CoordinateSourceString where coordinate was found
+	CoordinateSourceString = " (%s)"
+
+	// NoCoordinatesString there are no coordinates on the level
+	NoCoordinatesString = "Координат на уровне нет"
+
+	// RouteButtonString button with the route through all points
+	RouteButtonString = "Маршрут по всем точкам"
+
+	// MapsString maps chosen for the chat
+	MapsString = `*Карты:* %s
+Доступны: %s
+
+Изменить: /maps <карты>`
+
+	// MapsSavedString maps are changed
+	MapsSavedString = "Карты сохранены"
+
+	// UnknownMapsString maps are not supported
+	UnknownMapsString = "Неизвестные карты %q, доступны: %s"
+)
+
+const (
+	// TokenIssuedString new token of the API for the chat, it is sent privately
+	TokenIssuedString = "*Токен для API чата* %s: `%s`\n\n" +
+		"Передавайте его в заголовке `Authorization: Bearer <токен>` или в параметре `token`, " +
+		"штаб: `/dashboard#token=<токен>`\n" +
+		"Отозвать все токены чата: /token revoke"
+
+	// TokenSentString token is sent to the private chat with the user
+	TokenSentString = "Токен отправлен в личные сообщения"
+
+	// TokenNotSentString token can't be sent to the private chat with the user
+	TokenNotSentString = "Не удалось отправить токен в личные сообщения. Откройте чат с ботом, нажмите Start и повторите /token"
+
+	// TokensRevokedString tokens of the chat are revoked
+	TokensRevokedString = "Отозвано токенов: %d"
+
+	// TokenErrorString token can't be issued or revoked
+	TokenErrorString = "Не удалось изменить токены, попробуйте позже"
+)
+
+const (
+	// NotAllowedString role of the user is not enough to run the command
+	NotAllowedString = "Недостаточно прав: нужна роль «%s», ваша роль — «%s»"
+
+	// RolesString roles of the members in the chat
+	RolesString = `*Ваша роль:* %s
+
+*Назначенные роли:*
+%s
+
+Создатель чата — владелец, админы — капитаны, остальные — игроки.
+Назначить роль: ответьте на сообщение участника командой /role <роль>, сбросить: /role reset
+Роли: owner, captain, player, observer`
+
+	// RoleLineString member and the role assigned to them
+	RoleLineString = "%s — %s"
+
+	// NoRolesString no roles are assigned in the chat
+	NoRolesString = "нет"
+
+	// RoleUsageString how to assign the role
+	RoleUsageString = "Ответьте на сообщение участника командой /role <роль> или /role reset"
+
+	// UnknownRoleString role is not supported
+	UnknownRoleString = "Неизвестная роль %q, доступны: owner, captain, player, observer"
+
+	// RoleNotAllowedString role of the member can't be changed by the user
+	RoleNotAllowedString = "Вы не можете изменить роль %s"
+
+	// RoleSavedString role is assigned
+	RoleSavedString = "%s теперь %s"
+
+	// RoleResetString assigned role is removed
+	RoleResetString = "Роль %s сброшена, теперь %s"
+)
+
+const (
+	// UnknownCommandString command is not registered
+	UnknownCommandString = "Неизвестная команда, список команд: /help"
+
+	// HelpString list of the commands available for the user
+	HelpString = "*Команды:*\n%s\n\nПодробнее о команде: /help команда"
+
+	// HelpLineString command, its aliases and description in the list of the commands
+	HelpLineString = "/%s%s — %s"
+
+	// CommandHelpString detailed description of the command
+	CommandHelpString = "*/%s* %s\n%s\n\nДоступна с ролью «%s»"
+
+	// UnknownHelpString there is no command to show the help for
+	UnknownHelpString = "Команды /%s нет, список команд: /help"
+
+	// UsageString arguments of the command are missing
+	UsageString = "Не хватает аргументов, подробнее: /help %s"
+
+	// BotOwnersOnlyString default settings are used by someone who doesn't own the bot
+	BotOwnersOnlyString = "Игру по умолчанию могут настроить только владельцы бота, используйте /start"
+
+	// ChatSetString game with default settings is created for the chat
+	ChatSetString = "Игра по умолчанию настроена для этого чата, используйте /watch чтобы следить за игрой"
+
+	// WatchStartedString monitoring of the game is started
+	WatchStartedString = "Слежу за игрой, события уровня будут приходить в этот чат"
+
+	// WatchStoppedString monitoring of the game is stopped
+	WatchStoppedString = "Больше не слежу за игрой"
+
+	// PollingStoppedString monitoring of the game is stopped because of the error
+	PollingStoppedString = "Из-за внутренней ошибки бот перестал следить за игрой, используйте /watch чтобы продолжить"
+
+	// NoHelpsLeftString all hints of the level are opened
+	NoHelpsLeftString = "Подсказок на уровне больше нет"
+)
@@ -0,0 +1,26 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboardPage live dashboard of the game, it reads the state of the level and the
+// history from the events stream of the API
+//
+//go:embed dashboard/index.html
+var dashboardPage []byte
+
+// serveDashboard sends the page of the dashboard, the game is chosen by `chat` query
+// parameter on the page itself
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method is not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// links from the texts of the level must not see the address of the dashboard
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Write(dashboardPage)
+}
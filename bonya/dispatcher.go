@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// GlobalMessageInterval interval between messages for all chats, Telegram allows
+	// about 30 messages per second for the bot
+	GlobalMessageInterval = time.Second / 30
+	// GlobalMessageBurst messages that can be sent at once to all chats
+	GlobalMessageBurst = 30
+	// ChatMessageInterval interval between messages in one chat, Telegram allows
+	// 20 messages per minute in groups, so the burst and the messages sent with this
+	// interval during the rest of the minute should not exceed 20
+	ChatMessageInterval = 4 * time.Second
+	// ChatMessageBurst messages that can be sent at once to one chat
+	ChatMessageBurst = 5
+	// MaxSendRetries how many times message is resent after "Too Many Requests" response
+	MaxSendRetries = 5
+)
+
+// retryAfterRe extracts the time to wait from the "Too Many Requests: retry after N"
+// error returned by Telegram
+var retryAfterRe = regexp.MustCompile(`(?i)retry after (\d+)`)
+
+// retryAfter returns the time to wait before the message can be resent or false if
+// error is not about rate limits
+func retryAfter(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	match := retryAfterRe.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0, false
+	}
+	seconds, _ := strconv.Atoi(match[1])
+	return time.Duration(seconds) * time.Second, true
+}
+
+// rateLimiter allows burst of events at once and then one event per interval
+type rateLimiter struct {
+	sync.Mutex
+	interval time.Duration
+	burst    int
+	next     time.Time
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{interval: interval, burst: burst}
+}
+
+// reserve takes place for the event and returns how long to wait before it
+func (rl *rateLimiter) reserve() time.Duration {
+	rl.Lock()
+	defer rl.Unlock()
+
+	var (
+		now      = time.Now()
+		earliest = now.Add(-time.Duration(rl.burst-1) * rl.interval)
+	)
+	if rl.next.Before(earliest) {
+		rl.next = earliest
+	}
+	wait := rl.next.Sub(now)
+	rl.next = rl.next.Add(rl.interval)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// idle returns true if the whole burst is allowed again, i.e. limiter is in the
+// same state as the new one
+func (rl *rateLimiter) idle() bool {
+	rl.Lock()
+	defer rl.Unlock()
+	return !rl.next.After(time.Now().Add(-time.Duration(rl.burst-1) * rl.interval))
+}
+
+// wait blocks until the event is allowed
+func (rl *rateLimiter) wait() {
+	if d := rl.reserve(); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// chatQueue messages waiting to be sent to the chat, urgent messages are sent first
+type chatQueue struct {
+	urgent  []MessageSender
+	regular []MessageSender
+	limiter *rateLimiter
+	// sending is true while worker of the chat is running
+	sending bool
+}
+
+// Dispatcher sends messages to Telegram respecting rate limits for each chat and
+// for the bot in general. Messages for one chat are sent one by one in the order
+// they were queued, urgent messages are sent before the regular ones
+type Dispatcher struct {
+	*sync.Mutex
+	bot    BotSender
+	global *rateLimiter
+	chats  map[string]*chatQueue
+
+	// ChatInterval and ChatBurst limits for each chat
+	ChatInterval time.Duration
+	ChatBurst    int
+	// Retries how many times message is resent after "Too Many Requests" response
+	Retries int
+}
+
+// NewDispatcher constructor for the Dispatcher with Telegram limits
+func NewDispatcher(bot BotSender) *Dispatcher {
+	return &Dispatcher{
+		Mutex:        &sync.Mutex{},
+		bot:          bot,
+		global:       newRateLimiter(GlobalMessageInterval, GlobalMessageBurst),
+		chats:        make(map[string]*chatQueue),
+		ChatInterval: ChatMessageInterval,
+		ChatBurst:    ChatMessageBurst,
+		Retries:      MaxSendRetries,
+	}
+}
+
+// Run dispatches all messages from the channel
+func (d *Dispatcher) Run(messages <-chan MessageSender) {
+	for message := range messages {
+		d.Dispatch(message)
+	}
+}
+
+// Dispatch queues the message for sending, messages of the batch and parts of
+// the long text are queued one after another
+func (d *Dispatcher) Dispatch(message MessageSender) {
+	var messages = []MessageSender{message}
+	switch batch := message.(type) {
+	case *Batch:
+		messages = batch.Messages
+	case Batch:
+		messages = batch.Messages
+	}
+	for _, message := range messages {
+		if m, ok := message.(interface{ parts() []MessageSender }); ok {
+			for _, part := range m.parts() {
+				d.push(part)
+			}
+			continue
+		}
+		d.push(message)
+	}
+}
+
+func (d *Dispatcher) push(message MessageSender) {
+	var key string
+	if m, ok := message.(interface{ chat() string }); ok {
+		key = m.chat()
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.prune()
+	queue, exist := d.chats[key]
+	if !exist {
+		queue = &chatQueue{limiter: newRateLimiter(d.ChatInterval, d.ChatBurst)}
+		d.chats[key] = queue
+	}
+	if m, ok := message.(interface{ urgent() bool }); ok && m.urgent() {
+		queue.urgent = append(queue.urgent, message)
+	} else {
+		queue.regular = append(queue.regular, message)
+	}
+	if !queue.sending {
+		queue.sending = true
+		go d.work(key, queue)
+	}
+}
+
+// prune removes queues of the chats that have nothing to send and whose limits
+// are restored, so that the queues of the idle chats are not kept forever. Should
+// be called with the lock held
+func (d *Dispatcher) prune() {
+	for key, queue := range d.chats {
+		if !queue.sending && queue.limiter.idle() {
+			delete(d.chats, key)
+		}
+	}
+}
+
+// pop returns the next message for the chat or false if there are no messages,
+// worker of the chat should be stopped in that case
+func (d *Dispatcher) pop(queue *chatQueue) (message MessageSender, ok bool) {
+	d.Lock()
+	defer d.Unlock()
+	switch {
+	case len(queue.urgent) > 0:
+		message, queue.urgent = queue.urgent[0], queue.urgent[1:]
+	case len(queue.regular) > 0:
+		message, queue.regular = queue.regular[0], queue.regular[1:]
+	default:
+		queue.sending = false
+		return nil, false
+	}
+	return message, true
+}
+
+// work sends messages of the chat until the queue is empty
+func (d *Dispatcher) work(key string, queue *chatQueue) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Println(fmt.Errorf("[dispatcher] внутренняя ошибка: %v", p))
+			d.Lock()
+			queue.sending = false
+			d.Unlock()
+		}
+	}()
+
+	for {
+		message, ok := d.pop(queue)
+		if !ok {
+			return
+		}
+		err := d.send(key, queue, message)
+		if m, ok := message.(interface{ sent(error) }); ok {
+			m.sent(err)
+		}
+	}
+}
+
+// send sends the message and retries it after "Too Many Requests" response, returns
+// the error if message is not sent
+func (d *Dispatcher) send(key string, queue *chatQueue, message MessageSender) error {
+	for attempt := 0; ; attempt++ {
+		queue.limiter.wait()
+		d.global.wait()
+
+		err := message.Send(d.bot)
+		wait, limited := retryAfter(err)
+		switch {
+		case err == nil:
+			return nil
+		case !limited:
+			log.Printf("[ERROR] Can't send message to chat %s: %s", key, err)
+			return err
+		case attempt >= d.Retries:
+			log.Printf("[ERROR] Message to chat %s is dropped after %d retries: %s", key, attempt, err)
+			return err
+		}
+		log.Printf("[WARNING] Too many requests to chat %s, retry in %s", key, wait)
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	tb "github.com/tucnak/telebot"
+)
+
+// recordingBot remembers texts of the sent messages, returns errors from the list
+// before the message is sent and waits for the gate if it is set
+type recordingBot struct {
+	sync.Mutex
+	texts   []string
+	errors  []error
+	gate    chan struct{}
+	started chan struct{}
+	sent    chan string
+}
+
+func newRecordingBot() *recordingBot {
+	return &recordingBot{started: make(chan struct{}, 100), sent: make(chan string, 100)}
+}
+
+func (rb *recordingBot) SendMessage(recipient tb.Recipient, text string, options *tb.SendOptions) error {
+	rb.started <- struct{}{}
+	if rb.gate != nil {
+		<-rb.gate
+	}
+	rb.Lock()
+	if len(rb.errors) > 0 {
+		err := rb.errors[0]
+		rb.errors = rb.errors[1:]
+		rb.Unlock()
+		return err
+	}
+	rb.texts = append(rb.texts, recipient.Destination()+":"+text)
+	rb.Unlock()
+	rb.sent <- text
+	return nil
+}
+
+func (rb *recordingBot) SendPhoto(recipient tb.Recipient, photo *tb.Photo, options *tb.SendOptions) error {
+	return rb.SendMessage(recipient, "photo", options)
+}
+
+func (rb *recordingBot) SendVenue(recipient tb.Recipient, venue *tb.Venue, options *tb.SendOptions) error {
+	return rb.SendMessage(recipient, venue.Title, options)
+}
+
+func (rb *recordingBot) wait(t *testing.T, n int) []string {
+	for i := 0; i < n; i++ {
+		select {
+		case <-rb.sent:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %d messages to be sent, got %d", n, i)
+		}
+	}
+	rb.Lock()
+	defer rb.Unlock()
+	return rb.texts
+}
+
+func newTestDispatcher(bot BotSender) *Dispatcher {
+	var dispatcher = NewDispatcher(bot)
+	dispatcher.ChatInterval = time.Millisecond
+	return dispatcher
+}
+
+func TestDispatcherOrder(t *testing.T) {
+	var (
+		bot        = newRecordingBot()
+		dispatcher = newTestDispatcher(bot)
+		first      = testRecipient{name: "1"}
+		second     = testRecipient{name: "2"}
+	)
+	bot.gate = make(chan struct{})
+
+	dispatcher.Dispatch(NewTextMessage(first, "level", tb.Message{}))
+	// the first message is being sent while the rest are queued
+	<-bot.started
+	dispatcher.Dispatch(NewBatch(
+		LocationMessage{Message{Recipient: first}, &tb.Venue{Title: "venue 1"}},
+		LocationMessage{Message{Recipient: first}, &tb.Venue{Title: "venue 2"}}))
+	dispatcher.Dispatch(TextMessage{Message: Message{Recipient: first, Urgent: true}, Text: "codes"})
+	dispatcher.Dispatch(NewTextMessage(second, "other chat", tb.Message{}))
+	close(bot.gate)
+
+	var byChat = map[string][]string{}
+	for _, text := range bot.wait(t, 5) {
+		byChat[text[:1]] = append(byChat[text[:1]], text[2:])
+	}
+	if expected := []string{"level", "codes", "venue 1", "venue 2"}; !reflect.DeepEqual(byChat["1"], expected) {
+		t.Errorf("Expected messages %q in the first chat, got %q", expected, byChat["1"])
+	}
+	if expected := []string{"other chat"}; !reflect.DeepEqual(byChat["2"], expected) {
+		t.Errorf("Expected messages %q in the second chat, got %q", expected, byChat["2"])
+	}
+}
+
+func TestDispatcherRetry(t *testing.T) {
+	for _, example := range []struct {
+		name     string
+		errors   []error
+		retries  int
+		expected []string
+	}{
+		{"too many requests", []error{errors.New("telebot: Too Many Requests: retry after 0")}, 1,
+			[]string{"1:first", "1:second"}},
+		{"retries exceeded", []error{
+			errors.New("telebot: Too Many Requests: retry after 0"),
+			errors.New("telebot: Too Many Requests: retry after 0")}, 1,
+			[]string{"1:second"}},
+		{"other error", []error{errors.New("telebot: Bad Request: chat not found")}, 1,
+			[]string{"1:second"}},
+	} {
+		var (
+			bot        = newRecordingBot()
+			dispatcher = newTestDispatcher(bot)
+			recipient  = testRecipient{name: "1"}
+		)
+		bot.errors = example.errors
+		dispatcher.Retries = example.retries
+
+		dispatcher.Dispatch(NewTextMessage(recipient, "first", tb.Message{}))
+		dispatcher.Dispatch(NewTextMessage(recipient, "second", tb.Message{}))
+		if texts := bot.wait(t, len(example.expected)); !reflect.DeepEqual(texts, example.expected) {
+			t.Errorf("%s: expected messages %q, got %q", example.name, example.expected, texts)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, example := range []struct {
+		err      error
+		expected time.Duration
+		limited  bool
+	}{
+		{nil, 0, false},
+		{errors.New("telebot: Too Many Requests: retry after 35"), 35 * time.Second, true},
+		{errors.New("telebot: Bad Request: message is too long"), 0, false},
+	} {
+		if wait, limited := retryAfter(example.err); wait != example.expected || limited != example.limited {
+			t.Errorf("%v: expected %s %t, got %s %t", example.err, example.expected, example.limited, wait, limited)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	var (
+		limiter = newRateLimiter(100*time.Millisecond, 3)
+		waits   []time.Duration
+	)
+	for i := 0; i < 5; i++ {
+		waits = append(waits, limiter.reserve().Round(50*time.Millisecond))
+	}
+	expected := []time.Duration{0, 0, 0, 100 * time.Millisecond, 200 * time.Millisecond}
+	if !reflect.DeepEqual(waits, expected) {
+		t.Errorf("Expected waits %v, got %v", expected, waits)
+	}
+}
+
+func TestChatMessageLimit(t *testing.T) {
+	var (
+		limiter = newRateLimiter(ChatMessageInterval, ChatMessageBurst)
+		count   int
+	)
+	for i := 0; i < 30; i++ {
+		if limiter.reserve() <= time.Minute {
+			count++
+		}
+	}
+	if count != 20 {
+		t.Errorf("Expected 20 messages per minute in the chat, got %d", count)
+	}
+}
+
+func TestDispatcherPrune(t *testing.T) {
+	var (
+		bot        = newRecordingBot()
+		dispatcher = newTestDispatcher(bot)
+	)
+	dispatcher.ChatBurst = 1
+	dispatcher.Dispatch(NewTextMessage(testRecipient{name: "1"}, "first", tb.Message{}))
+	bot.wait(t, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	dispatcher.Dispatch(NewTextMessage(testRecipient{name: "2"}, "second", tb.Message{}))
+	bot.wait(t, 1)
+	dispatcher.Lock()
+	_, exist := dispatcher.chats["1"]
+	dispatcher.Unlock()
+	if exist {
+		t.Errorf("Expected queue of the idle chat to be removed")
+	}
+}
+
+func TestDispatcherReportsResult(t *testing.T) {
+	var (
+		bot        = newRecordingBot()
+		dispatcher = newTestDispatcher(bot)
+		forbidden  = errors.New("telebot: Forbidden: bot can't initiate conversation with a user")
+	)
+	bot.errors = []error{forbidden}
+	for _, expected := range []error{forbidden, nil} {
+		message := NewReportedMessage(testRecipient{name: "1"}, "token", tb.Message{})
+		dispatcher.Dispatch(message)
+		select {
+		case err := <-message.Result:
+			if err != expected {
+				t.Errorf("Expected result %v, got %v", expected, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected result of the message to be reported")
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bonya_bot/en"
+	tb "github.com/tucnak/telebot"
+)
+
+// renderEvents sends notifications about the changes on the level to the chat of the game.
+// Time left till the end of the level is checked by the level time checking machine
+// of the game, so TimeoutApproaching is not rendered here
+func renderEvents(game *Game, events []en.Event) {
+	for _, event := range events {
+		renderEvent(game, event)
+	}
+}
+
+func renderEvent(game *Game, event en.Event) {
+	switch event.Type {
+	case en.LevelChanged:
+		SendImages(game, event.Level, event.Level.Images)
+		SendCoords(game, event.Level, event.Level.Coords)
+	case en.HelpOpened, en.PenaltyHelpOpened:
+		log.Printf("New hint #%d is available", event.Help.Number)
+		event.Help.ProcessText()
+		messageChan <- TextMessage{Message: Message{Recipient: game.Chat,
+			Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
+				DisableWebPagePreview: true,
+				ReplyTo:               event.Help.ReplyTo()}},
+			Text: event.Help.ToText()}
+		SendCoords(game, event.Level, event.Help.Coords)
+		SendImages(game, event.Level, event.Help.Images)
+	case en.HelpApproaching:
+		messageChan <- NewTextMessage(game.Chat, fmt.Sprintf(en.HelpTimeLeft, event.Help.Number,
+			en.PrettyTimePrint(event.Remaining/time.Second, false)), tb.Message{})
+	case en.SectorClosed:
+		log.Printf("Sector %q is closed, %d sectors left to close",
+			event.Sector.Name, event.Level.SectorsLeftToClose)
+		if left := game.Alerts().SectorsLeft; left > 0 && event.Level.SectorsLeftToClose <= int16(left) {
+			sectorsLeft(game.Chat, event.Level)
+		}
+	case en.BonusClosed:
+		log.Printf("Bonus %q is available, code %q", event.Bonus.Name, event.Bonus.Answer["Answer"])
+		if event.Bonus.Help == "" {
+			return
+		}
+		event.Bonus.ProcessText()
+		messageChan <- TextMessage{Message: Message{Recipient: game.Chat,
+			Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
+				DisableWebPagePreview: true,
+				ReplyTo:               event.Bonus.ReplyTo()}},
+			Text: event.Bonus.ToText()}
+		SendCoords(game, event.Level, event.Bonus.Coords)
+		SendImages(game, event.Level, event.Bonus.Images)
+	case en.BonusAppeared:
+		messageChan <- NewTextMessage(game.Chat, bonusAppearedText(event.Bonus), tb.Message{})
+	case en.BonusExpiring:
+		messageChan <- NewTextMessage(game.Chat, fmt.Sprintf(BonusExpiringString, event.Bonus.Number,
+			escapeMarkdown(event.Bonus.Name), prettyDuration(event.Remaining/time.Second)), tb.Message{})
+	}
+}
@@ -1,15 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/bonya_bot/en"
 	"github.com/tucnak/telebot"
 )
 
+var (
+	// ErrNoLevel returned when level information of the game wasn't received yet
+	ErrNoLevel = errors.New("level information is not received yet")
+	// ErrLevelBlocked returned when codes can't be sent to the level
+	ErrLevelBlocked = errors.New("level is passed or blocked")
+)
+
 // GameSettings structure to store some settings for the game
 type GameSettings struct {
+	tableName struct{} `sql:"game_settings"`
+
 	// ChatID id of the chat where to send notifications about current game
-	ChatID int64
+	ChatID int64 `sql:",pk"`
 	// Domain where game is running
 	Domain string
 	// GameID id of the game
@@ -18,6 +34,14 @@ type GameSettings struct {
 	UserName string
 	// Password for the user
 	Password string
+	// Watching is true if the game was monitored, used to resume monitoring after restart
+	Watching bool `sql:",notnull"`
+	// Alerts thresholds of the notifications, nil if defaults are used
+	Alerts *AlertSettings
+	// MapProvider name of the maps for the links to coordinates, empty if default is used
+	MapProvider string
+	// Roles assigned to the members of the chat by Telegram user id
+	Roles map[int]MemberRole
 }
 
 func (gs GameSettings) String() string {
@@ -32,58 +56,274 @@ func NewGameSettings() *GameSettings {
 	return &GameSettings{}
 }
 
-type DomainChecker struct {
-	Channel  *chan MessageSender
+// Game represents the game that is followed from the certain chat. Each game
+// has its own session in the engine, watcher and level time checking machine
+type Game struct {
+	*sync.RWMutex
+
+	// Settings settings that were used to create the game
 	Settings *GameSettings
-	Text     string
-}
-
-// getKeyboard returns keyboard with all available buttons.
-// TODO: move all available to DB
-func (gsc DomainChecker) getKeyboard() (keyboard [][]telebot.KeyboardButton) {
-	var (
-		kbQuest        = telebot.KeyboardButton{Text: "quest.ua", Data: "http://quest.ua"}
-		kbKharkovQuest = telebot.KeyboardButton{Text: "kharkov.quest.ua", Data: "http://kharkov.quest.ua"}
-		kbKharkovEn    = telebot.KeyboardButton{Text: "kharkov.en.cx", Data: "http://kharkov.en.cx"}
-	)
-	keyboard = [][]telebot.KeyboardButton{{kbKharkovQuest, kbKharkovEn}, {kbQuest}}
-	return
+	// Chat where all the notifications about the game are sent
+	Chat telebot.Chat
+	// Engine session in the EN engine for the game
+	Engine *en.API
+
+	// codesMutex is used to send codes one by one
+	codesMutex    sync.Mutex
+	levelInfoChan chan levelUpdate
+	fsm           *LevelTimeCheckingMachine
+	// cancel stops the poller of the game, nil if game isn't monitored
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// updatesMutex protects lastUpdate, lastRequest is the number of the last request
+	// to the engine and lastUpdate is the number of the request which level was sent
+	// to the channel
+	updatesMutex sync.Mutex
+	lastRequest  uint64
+	lastUpdate   uint64
+
+	// penaltyHelps ids of the penalty hints that are requested or being requested, so
+	// that the paid hint is not requested twice before the level is updated
+	penaltyHelps map[int]bool
+}
+
+// NewGame constructor for the Game, creates new session in the engine according
+// to the settings, but doesn't login to it
+func NewGame(chat telebot.Chat, settings *GameSettings) *Game {
+	return &Game{
+		RWMutex:       &sync.RWMutex{},
+		Settings:      settings,
+		Chat:          chat,
+		Engine:        en.NewAPI(settings.Domain, settings.UserName, settings.Password, settings.GameID),
+		levelInfoChan: make(chan levelUpdate, 10),
+		fsm:           initTimeLevelChecking(settings.alerts().LevelTime),
+		done:          make(chan struct{}),
+		penaltyHelps:  make(map[int]bool),
+	}
+}
+
+// reservePenaltyHelp marks the penalty hint as requested, returns false if the hint is
+// requested already
+func (g *Game) reservePenaltyHelp(id int) bool {
+	g.Lock()
+	defer g.Unlock()
+	if g.penaltyHelps[id] {
+		return false
+	}
+	g.penaltyHelps[id] = true
+	return true
+}
+
+// releasePenaltyHelp allows to request the hint again, e.g. after the request failed
+func (g *Game) releasePenaltyHelp(id int) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.penaltyHelps, id)
+}
+
+// alerts returns thresholds of the notifications from the settings or defaults
+func (gs GameSettings) alerts() AlertSettings {
+	if gs.Alerts == nil {
+		return DefaultAlertSettings
+	}
+	return *gs.Alerts
+}
+
+func (g *Game) String() string {
+	return fmt.Sprintf("<Game: %d %s>", g.Chat.ID, g.Settings)
+}
+
+// CurrentLevel returns the last known level of the game or nil if level information
+// wasn't received yet
+func (g *Game) CurrentLevel() *en.Level {
+	return g.Engine.Level()
+}
+
+func (g *Game) setCurrentLevel(level *en.Level) {
+	g.Engine.SetLevel(level)
+}
+
+// Alerts returns thresholds of the notifications for the chat of the game
+func (g *Game) Alerts() AlertSettings {
+	g.RLock()
+	defer g.RUnlock()
+	return g.Settings.alerts()
+}
+
+// SetAlerts changes thresholds of the notifications. Level time checking machine
+// is rebuilt and moved to the state for the current level, so that notifications
+// that are already passed are not sent again
+func (g *Game) SetAlerts(alerts AlertSettings) {
+	var fsm = initTimeLevelChecking(alerts.LevelTime)
+	if level := g.CurrentLevel(); level != nil {
+		fsm.ResetState(level.Timeout * time.Second)
+		for fsm.Process(level.TimeoutSecondsRemain * time.Second) {
+		}
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	g.Settings.Alerts = &alerts
+	g.fsm = fsm
+}
+
+// MapProvider returns the maps chosen for the chat of the game
+func (g *Game) MapProvider() MapProvider {
+	g.RLock()
+	defer g.RUnlock()
+	provider, _ := findMapProvider(g.Settings.MapProvider)
+	return provider
+}
+
+// SetMapProvider changes the maps for the chat, returns false if maps are unknown
+func (g *Game) SetMapProvider(name string) bool {
+	if _, ok := findMapProvider(name); !ok {
+		return false
+	}
+	g.Lock()
+	defer g.Unlock()
+	g.Settings.MapProvider = name
+	return true
+}
+
+// MemberRole returns the role assigned to the user in the chat of the game
+func (g *Game) MemberRole(userID int) (MemberRole, bool) {
+	g.RLock()
+	defer g.RUnlock()
+	member, ok := g.Settings.Roles[userID]
+	return member, ok
+}
+
+// MemberRoles returns all roles assigned in the chat of the game
+func (g *Game) MemberRoles() map[int]MemberRole {
+	g.RLock()
+	defer g.RUnlock()
+	var roles = make(map[int]MemberRole, len(g.Settings.Roles))
+	for userID, member := range g.Settings.Roles {
+		roles[userID] = member
+	}
+	return roles
+}
+
+// SetMemberRole assigns the role to the user in the chat of the game. Roles are copied,
+// so that saved settings don't share them with the game
+func (g *Game) SetMemberRole(userID int, member MemberRole) {
+	roles := g.MemberRoles()
+	roles[userID] = member
+	g.Lock()
+	defer g.Unlock()
+	g.Settings.Roles = roles
+}
+
+// ResetMemberRole removes the role assigned to the user, so that the role is taken
+// from Telegram again
+func (g *Game) ResetMemberRole(userID int) {
+	roles := g.MemberRoles()
+	delete(roles, userID)
+	g.Lock()
+	defer g.Unlock()
+	g.Settings.Roles = roles
 }
 
-func (gsc DomainChecker) String() string {
-	return fmt.Sprintf("<DomainChecker %s>", "a")
+// timeMachine returns the level time checking machine of the game
+func (g *Game) timeMachine() *LevelTimeCheckingMachine {
+	g.RLock()
+	defer g.RUnlock()
+	return g.fsm
 }
 
-func (gsc DomainChecker) Prepare() {
-	*gsc.Channel <- NewTextInlineMessage(telebot.Chat{ID: gsc.Settings.ChatID}, gsc.Text, gsc.getKeyboard())
+// IsWatching returns true if the game is monitored at the moment
+func (g *Game) IsWatching() bool {
+	g.RLock()
+	defer g.RUnlock()
+	return g.cancel != nil
 }
 
-// Process checks that
-func (gsc DomainChecker) Process(args ...interface{}) bool {
-	if gsc.Settings.Domain != "" {
-		*gsc.Channel <- NewTextMessage(telebot.Chat{ID: gsc.Settings.ChatID},
-			fmt.Sprintf("%s", gsc.Settings.Domain), telebot.Message{})
+// newRequest returns the number of the next request to the engine, it should be
+// taken before the request is sent
+func (g *Game) newRequest() uint64 {
+	return atomic.AddUint64(&g.lastRequest, 1)
+}
+
+// levelUpdate level information received in the response to the request
+type levelUpdate struct {
+	request uint64
+	level   *en.Level
+}
+
+// pushLevel sends the level received in the response to the request to the
+// handler of level updates. Response is dropped and false is returned if the
+// level from the later request was already sent, or if the context is canceled
+// or the game is stopped while the handler is busy. Handler drops the levels
+// that were sent concurrently and came out of order
+func (g *Game) pushLevel(ctx context.Context, request uint64, level *en.Level) bool {
+	g.updatesMutex.Lock()
+	if request < g.lastUpdate {
+		g.updatesMutex.Unlock()
+		log.Printf("[DEBUG] Dropping stale level information for %s", g)
+		return false
+	}
+	g.lastUpdate = request
+	g.updatesMutex.Unlock()
+
+	select {
+	case g.levelInfoChan <- levelUpdate{request, level}:
 		return true
+	case <-ctx.Done():
+		return false
+	case <-g.done:
+		return false
 	}
-	return false
 }
 
-// GameChecker helps to check that all the settings are set correctly
-// and if not then promt the user to set them
-type GameChecker struct {
-	Channel  *chan MessageSender
-	Settings *GameSettings
-	Text     string
+// GameRegistry structure to store games for all chats the bot is working in
+type GameRegistry struct {
+	*sync.RWMutex
+	games map[int64]*Game
 }
 
-func (gc GameChecker) String() string {
-	return fmt.Sprintf("<GameChecker %p>", gc.Settings)
+// NewGameRegistry creates a new registry and returns a reference to it
+func NewGameRegistry() *GameRegistry {
+	return &GameRegistry{
+		RWMutex: &sync.RWMutex{},
+		games:   make(map[int64]*Game),
+	}
 }
 
-type GameSettingsCheckingMachine struct {
-	CheckingMachine
+// Get tries to find the game for the chat. If there is no game for the chat
+// then returns error that game is not registered
+func (gr GameRegistry) Get(chatID int64) (*Game, error) {
+	gr.RLock()
+	defer gr.RUnlock()
+	game, exist := gr.games[chatID]
+	if exist {
+		return game, nil
+	}
+
+	return nil, fmt.Errorf("Game for chat %d is not registered", chatID)
 }
 
-func (fsm *GameSettingsCheckingMachine) ResetState() {
-	// fsm.SetState(fsm.machine.rules[0].Origin())
+// Register adds the game to the registry and starts processing level updates for it.
+// If there was a game for the same chat already, it is stopped and replaced
+func (gr GameRegistry) Register(game *Game) {
+	gr.Lock()
+	defer gr.Unlock()
+	if old, exist := gr.games[game.Chat.ID]; exist {
+		log.Printf("[INFO] Replacing game %s with %s", old, game)
+		stopWatching(old)
+		close(old.done)
+	}
+	gr.games[game.Chat.ID] = game
+	go handleLevelUpdates(game)
+}
+
+// All returns all registered games
+func (gr GameRegistry) All() (games []*Game) {
+	gr.RLock()
+	defer gr.RUnlock()
+	for _, game := range gr.games {
+		games = append(games, game)
+	}
+	return
 }
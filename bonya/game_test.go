@@ -0,0 +1,719 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonya_bot/en"
+	"github.com/bonya_bot/en/entest"
+	tb "github.com/tucnak/telebot"
+)
+
+const (
+	testLogin    = "player"
+	testPassword = "secret"
+	testGameID   = 25733
+	testChatID   = -100500
+	// testCaptainID admin of the test chat
+	testCaptainID = 42
+)
+
+// messageCollector reads all messages that are sent to messageChan
+type messageCollector struct {
+	sync.Mutex
+	texts []string
+	done  chan struct{}
+}
+
+func collectMessages() *messageCollector {
+	var mc = &messageCollector{done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case message := <-messageChan:
+				mc.Lock()
+				mc.texts = append(mc.texts, messageTexts(message)...)
+				mc.Unlock()
+			case <-mc.done:
+				return
+			}
+		}
+	}()
+	return mc
+}
+
+// messageTexts returns texts of the message, messages without text return empty string
+func messageTexts(message MessageSender) []string {
+	switch m := message.(type) {
+	case TextMessage:
+		return []string{m.Text}
+	case *TextMessage:
+		return []string{m.Text}
+	case *TextInlineMessage:
+		return []string{m.Text}
+	case *EditTextMessage:
+		return []string{m.Text}
+	case *Batch:
+		var texts []string
+		for _, item := range m.Messages {
+			texts = append(texts, messageTexts(item)...)
+		}
+		return texts
+	}
+	return []string{""}
+}
+
+func (mc *messageCollector) stop() {
+	close(mc.done)
+}
+
+// waitFor waits until message with the text is sent
+func (mc *messageCollector) waitFor(t *testing.T, text string, timeout time.Duration) {
+	var deadline = time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		mc.Lock()
+		for _, sent := range mc.texts {
+			if strings.Contains(sent, text) {
+				mc.Unlock()
+				return
+			}
+		}
+		mc.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	mc.Lock()
+	defer mc.Unlock()
+	t.Fatalf("Expected message with %q, got %q", text, mc.texts)
+}
+
+// waitForLevel waits until the game gets the current level and returns it
+func waitForLevel(t *testing.T, game *Game) *en.Level {
+	var deadline = time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if level := game.CurrentLevel(); level != nil {
+			return level
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected current level of the game %s", game)
+	return nil
+}
+
+func newTestEngine() *entest.Engine {
+	var engine = entest.NewEngine(testLogin, testPassword, testGameID)
+
+	engine.AddLevel(en.Level{
+		Name:                 "First",
+		Timeout:              7200,
+		TimeoutSecondsRemain: 7200,
+		RequiredSectorsCount: 3,
+		SectorsLeftToClose:   3,
+		Sectors: en.LevelSectors{
+			{SectorId: 1, Order: 1, Name: "Sector 1"},
+			{SectorId: 2, Order: 2, Name: "Sector 2"},
+			{SectorId: 3, Order: 3, Name: "Sector 3"},
+		},
+		Bonuses: en.LevelBonuses{
+			{BonusId: 10, Number: 1, Name: "Bonus 1"},
+		},
+		Helps: en.LevelHelps{
+			{HelpID: 20, Number: 1, RemainSeconds: 600},
+		},
+	})
+	engine.AddLevel(en.Level{Name: "Second", Timeout: 1800, TimeoutSecondsRemain: 1800})
+	engine.AddSectorCode(1, 1, "code1")
+	engine.AddBonusCode(1, 10, "bonus1")
+	return engine
+}
+
+func newTestGame(t *testing.T, engine *entest.Engine) *Game {
+	var game = NewGame(tb.Chat{ID: testChatID}, &GameSettings{
+		ChatID:   testChatID,
+		Domain:   engine.Domain(),
+		GameID:   testGameID,
+		UserName: testLogin,
+		Password: testPassword,
+	})
+
+	if err := game.Engine.Login2(testLogin, testPassword); err != nil {
+		t.Fatalf("Can't login to test engine: %s", err)
+	}
+	games.Register(game)
+	return game
+}
+
+func init() {
+	initChannels()
+	games = NewGameRegistry()
+	settingsMachines = NewSettingsMachines()
+	settingsRepository = NewMemoryGameSettingsRepository()
+
+	historyDir, err := ioutil.TempDir("", "bonya")
+	if err != nil {
+		panic(err)
+	}
+	eventStore = NewFileEventStore(filepath.Join(historyDir, "history.jsonl"))
+	imageService = NewImageService(filepath.Join(historyDir, "images"))
+	go imageService.Run(messageChan)
+	streams = NewEventBroker()
+	tokens, _ = NewTokenStore(NewMemoryTokenRepository())
+	permissions = NewPermissions(testChatMembers{statuses: map[int]tb.MemberStatus{testCaptainID: tb.Administrator}}, nil)
+}
+
+///////////////////////////////////////////////////////////////////////////////////
+
+func TestSendCodeEndToEnd(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		game     = newTestGame(t, engine)
+		messages = collectMessages()
+	)
+	defer engine.Close()
+	defer messages.stop()
+
+	level, err := game.Engine.GetLevelInfo()
+	if err != nil {
+		t.Fatalf("Not expected errors, got %s", err)
+	}
+	game.setCurrentLevel(level)
+
+	sendCode(game, []string{"code1", "wrong"}, tb.Message{})
+	messages.waitFor(t, "*+* code1", time.Second)
+	messages.waitFor(t, "*-* wrong", time.Second)
+	// Sector is closed and there are less than 3 sectors left
+	messages.waitFor(t, "Осталось *2* из *3*", time.Second)
+
+	sendBonusCode(game, []string{"bonus1"}, tb.Message{})
+	messages.waitFor(t, "bonus1 (бонус \"Bonus 1\")", time.Second)
+
+	engine.Block(time.Minute)
+	level, _ = game.Engine.GetLevelInfo()
+	game.setCurrentLevel(level)
+	sendCode(game, []string{"code2"}, tb.Message{})
+	messages.waitFor(t, "*блок:* code2", time.Second)
+}
+
+func TestWatcherEndToEnd(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		game     = newTestGame(t, engine)
+		messages = collectMessages()
+	)
+	defer engine.Close()
+	defer messages.stop()
+
+	startWatching(game)
+	defer stopWatching(game)
+
+	waitForLevel(t, game)
+
+	engine.OpenHelp(1, "Ищите у фонтана")
+	messages.waitFor(t, "Ищите у фонтана", 5*time.Second)
+
+	engine.CloseSector(1, "code1")
+	messages.waitFor(t, "Осталось *2* из *3*", 5*time.Second)
+
+	engine.LevelUp()
+	for game.CurrentLevel().Number != 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHistoryEndToEnd(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		game     = newTestGame(t, engine)
+		messages = collectMessages()
+		message  = tb.Message{Chat: tb.Chat{ID: testChatID}, Sender: tb.User{Username: "nick_name"}}
+	)
+	defer engine.Close()
+	defer messages.stop()
+
+	historyDir, err := ioutil.TempDir("", "bonya")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(historyDir)
+	eventStore = NewFileEventStore(filepath.Join(historyDir, "history.jsonl"))
+
+	startWatching(game)
+	defer stopWatching(game)
+	waitForLevel(t, game)
+
+	sendCode(game, []string{"code1", "wrong"}, message)
+	engine.OpenHelp(1, "Ищите у фонтана")
+	messages.waitFor(t, "Ищите у фонтана", 5*time.Second)
+
+	command, _ := NewHistoryCommand(messageChan, message, game)
+	command.Process("")
+	messages.waitFor(t, "*История уровня 1:*", time.Second)
+	messages.waitFor(t, "Уровень 1: First", time.Second)
+	messages.waitFor(t, "+ code1 (@nick\\_name)", time.Second)
+	messages.waitFor(t, "- wrong (@nick\\_name)", time.Second)
+	messages.waitFor(t, "Сектор Sector 1: code1", time.Second)
+	messages.waitFor(t, "Подсказка 1", time.Second)
+
+	command.Process("2")
+	messages.waitFor(t, "История уровня 2 пуста", time.Second)
+	command.Process("abc")
+	messages.waitFor(t, "Неверный номер уровня", time.Second)
+}
+
+func TestPenaltyHelpsEndToEnd(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		messages = collectMessages()
+		message  = tb.Message{Chat: tb.Chat{ID: testChatID}, Sender: tb.User{ID: testCaptainID, Username: "captain"}}
+	)
+	defer engine.Close()
+	defer messages.stop()
+
+	engine.AddPenaltyHelp(1, en.HelpInfo{HelpID: 30, Number: 1, Penalty: 600, PenaltyComment: "адрес"},
+		"Под скамейкой")
+	engine.AddPenaltyHelp(1, en.HelpInfo{HelpID: 31, Number: 2, Penalty: 1800, RemainSeconds: 300}, "Во дворе")
+	game := newTestGame(t, engine)
+
+	startWatching(game)
+	defer stopWatching(game)
+	waitForLevel(t, game)
+
+	command, _ := NewPenaltyHelpsCommand(messageChan, message, game)
+	command.Process("")
+	messages.waitFor(t, "*1.* штраф 10 минут (адрес): можно взять /ph 1", time.Second)
+	messages.waitFor(t, "*2.* штраф 30 минут: будет доступна через 5 минут", time.Second)
+
+	command.Process("2")
+	messages.waitFor(t, "Штрафная подсказка 2 будет доступна через 5 минут", time.Second)
+	command.Process("1")
+	messages.waitFor(t, "Вы уверены? -10 минут", time.Second)
+
+	confirmation := tb.Message{ID: 55, Chat: message.Chat, Text: "Взять штрафную подсказку 1?"}
+	processPenaltyHelpCallback(confirmation, tb.User{ID: testCaptainID + 1}, PenaltyHelpCallbackPrefix+"30")
+	messages.waitFor(t, fmt.Sprintf(NotAllowedString, "капитан", "игрок"), time.Second)
+	processPenaltyHelpCallback(confirmation, message.Sender, PenaltyHelpCallbackPrefix+penaltyHelpCancel)
+	messages.waitFor(t, PenaltyHelpCanceledString, time.Second)
+	// keyboard is removed from the answered confirmation
+	messages.waitFor(t, "Взять штрафную подсказку 1?\n\nОтвет от @captain", time.Second)
+
+	// the same hint is being requested after another confirmation
+	game.reservePenaltyHelp(30)
+	processPenaltyHelpCallback(confirmation, message.Sender, PenaltyHelpCallbackPrefix+"30")
+	messages.waitFor(t, "Штрафная подсказка 1 уже запрошена", time.Second)
+	game.releasePenaltyHelp(30)
+
+	processPenaltyHelpCallback(confirmation, message.Sender, PenaltyHelpCallbackPrefix+"30")
+	messages.waitFor(t, "@captain взял штрафную подсказку 1", time.Second)
+	if game.reservePenaltyHelp(30) {
+		t.Errorf("Expected opened hint not to be requested again")
+	}
+	messages.waitFor(t, "*Штрафная подсказка:* 1\n*Текст:* Под скамейкой", time.Second)
+
+	command.Process("1")
+	messages.waitFor(t, "Штрафная подсказка 1 уже открыта", time.Second)
+}
+
+func TestBonusesEndToEnd(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		messages = collectMessages()
+		message  = tb.Message{Chat: tb.Chat{ID: testChatID}}
+	)
+	defer engine.Close()
+	defer messages.stop()
+
+	engine.Update(func(level *en.Level) {
+		level.Bonuses = append(level.Bonuses,
+			en.BonusInfo{BonusId: 11, Number: 2, Name: "Bonus 2", Task: "Найдите<br/>фонтан", SecondsToStart: 600},
+			en.BonusInfo{BonusId: 12, Number: 3, Name: "Bonus 3", SecondsLeft: 360},
+			en.BonusInfo{BonusId: 13, Number: 4, Name: "Bonus 4", Expired: true})
+	})
+	game := newTestGame(t, engine)
+
+	startWatching(game)
+	defer stopWatching(game)
+	waitForLevel(t, game)
+
+	command, _ := NewBonusesCommand(messageChan, message, game)
+	command.Process()
+	messages.waitFor(t, "*Бонусы:*\n"+
+		"*1.* Bonus 1: открыт\n"+
+		"*2.* Bonus 2: будет доступен через 10 минут\nНайдите\nфонтан\n"+
+		"*3.* Bonus 3: открыт, осталось 6 минут\n"+
+		"*4.* Bonus 4: время вышло", time.Second)
+
+	engine.Update(func(level *en.Level) {
+		level.Bonuses[1].SecondsToStart = 0
+		level.Bonuses[1].SecondsLeft = 900
+		level.Bonuses[2].SecondsLeft = 240
+	})
+	messages.waitFor(t, "Бонус *2.* Bonus 2 доступен для ввода, осталось 15 минут\nНайдите\nфонтан", 3*time.Second)
+	messages.waitFor(t, "Бонус *3.* Bonus 3 закроется через 4 минуты", time.Second)
+}
+
+func TestAlertsEndToEnd(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		messages = collectMessages()
+		message  = tb.Message{Chat: tb.Chat{ID: testChatID}}
+	)
+	defer engine.Close()
+	defer messages.stop()
+
+	game := newTestGame(t, engine)
+	startWatching(game)
+	defer stopWatching(game)
+	waitForLevel(t, game)
+
+	command, _ := NewAlertsCommand(messageChan, message, game)
+	command.Process("")
+	messages.waitFor(t, "Время уровня: за 60, 30, 15, 5, 1 мин\nПодсказки: выключены\n"+
+		"Бонусы: за 5 мин\nСекторы: когда осталось 3 и меньше", time.Second)
+
+	command.Process("sound on")
+	messages.waitFor(t, `Неизвестный тип оповещений "sound"`, time.Second)
+	command.Process("help 0")
+	messages.waitFor(t, `Некорректное значение "0"`, time.Second)
+
+	command.Process("help 1 5 5")
+	messages.waitFor(t, "Подсказки: за 5, 1 мин", time.Second)
+	engine.Tick(6 * time.Minute)
+	messages.waitFor(t, "*Подсказка 1* будет через 4 минуты", 3*time.Second)
+
+	command.Process("time 100")
+	messages.waitFor(t, "Время уровня: за 100 мин", time.Second)
+	engine.Tick(15 * time.Minute)
+	messages.waitFor(t, "Осталось 1 час 39 минут", 3*time.Second)
+
+	command.Process("sectors off")
+	messages.waitFor(t, "Секторы: выключены", time.Second)
+	if alerts := game.Settings.Alerts; alerts == nil || alerts.SectorsLeft != 0 ||
+		!reflect.DeepEqual(alerts.LevelTime, []int{100}) {
+		t.Errorf("Expected alerts to be saved in the settings, got %+v", alerts)
+	}
+
+	command.Process("reset")
+	messages.waitFor(t, "Секторы: когда осталось 3 и меньше", time.Second)
+}
+
+func TestStopWatching(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		game     = newTestGame(t, engine)
+		messages = collectMessages()
+	)
+	defer engine.Close()
+	defer messages.stop()
+
+	// slow engine: requests of the poller must not overlap
+	engine.SetDelay(300 * time.Millisecond)
+	startWatching(game)
+	time.Sleep(time.Second)
+	stopWatching(game)
+	requests := engine.LevelRequests()
+	if requests == 0 || requests > 3 {
+		t.Errorf("Expected from 1 to 3 requests, got %d", requests)
+	}
+
+	time.Sleep(2 * DefaultPollInterval)
+	if engine.LevelRequests() != requests {
+		t.Errorf("Expected no requests after watching is stopped")
+	}
+	if game.IsWatching() {
+		t.Errorf("Expected game is not watched")
+	}
+}
+
+func TestBonusNamesOutsideEntities(t *testing.T) {
+	var (
+		bonus = en.BonusInfo{Number: 1, Name: "*Bonus_1*"}
+		name  = `\*Bonus\_1\*`
+	)
+	if text := listBonuses(&en.Level{Bonuses: []en.BonusInfo{bonus}}); !strings.Contains(text, "*1.* "+name+": ") {
+		t.Errorf("Expected escaped name outside of the entity, got %q", text)
+	}
+	if text := bonusAppearedText(&bonus); text != "Бонус *1.* "+name+" доступен для ввода" {
+		t.Errorf("Expected escaped name outside of the entity, got %q", text)
+	}
+}
+
+func TestFindClosedBonus(t *testing.T) {
+	var (
+		old   = &en.Level{Bonuses: en.LevelBonuses{{BonusId: 1}, {BonusId: 2}, {BonusId: 3, IsAnswered: true}}}
+		level = &en.Level{Bonuses: en.LevelBonuses{
+			// answered by somebody else, engine didn't send the answer
+			{BonusId: 1, IsAnswered: true},
+			{BonusId: 2, IsAnswered: true, Answer: map[string]interface{}{"Answer": "Bonus2"}},
+			{BonusId: 3, IsAnswered: true, Answer: map[string]interface{}{"Answer": "bonus3"}},
+		}}
+	)
+	for _, example := range []struct {
+		code    string
+		bonusID int32
+	}{
+		{"bonus2", 2},
+		{"bonus3", 0},
+		{"other", 0},
+	} {
+		var bonusID int32
+		if bonus := findClosedBonus(old, level, example.code); bonus != nil {
+			bonusID = bonus.BonusId
+		}
+		if bonusID != example.bonusID {
+			t.Errorf("For %q expected bonus %d, got %d", example.code, example.bonusID, bonusID)
+		}
+	}
+}
+
+func TestSendBonusCodeWithoutLevel(t *testing.T) {
+	var (
+		messages = collectMessages()
+		game     = NewGame(tb.Chat{ID: testChatID}, &GameSettings{ChatID: testChatID})
+	)
+	defer messages.stop()
+
+	sendBonusCode(game, []string{"bonus1"}, tb.Message{})
+	messages.waitFor(t, NoLevelString, time.Second)
+}
+
+func TestPollerRecover(t *testing.T) {
+	var (
+		messages = collectMessages()
+		game     = NewGame(tb.Chat{ID: testChatID}, &GameSettings{ChatID: testChatID})
+	)
+	defer messages.stop()
+
+	// requests to the engine panic without the session
+	game.Engine = nil
+	startWatching(game)
+	messages.waitFor(t, PollingStoppedString, time.Second)
+	if game.IsWatching() {
+		t.Errorf("Expected game is not watched after the poller stopped")
+	}
+}
+
+func TestPushLevel(t *testing.T) {
+	var game = NewGame(tb.Chat{ID: testChatID}, &GameSettings{ChatID: testChatID})
+
+	first, second := game.newRequest(), game.newRequest()
+	if !game.pushLevel(context.Background(), second, &en.Level{LevelID: 2}) {
+		t.Errorf("Expected level from the last request to be sent")
+	}
+	if game.pushLevel(context.Background(), first, &en.Level{LevelID: 1}) {
+		t.Errorf("Expected level from the stale request to be dropped")
+	}
+	if update := <-game.levelInfoChan; update.level.LevelID != 2 || len(game.levelInfoChan) != 0 {
+		t.Errorf("Expected only level from the last request in the channel")
+	}
+
+	// handler is busy and the channel is full
+	for i := 0; i < cap(game.levelInfoChan); i++ {
+		game.pushLevel(context.Background(), game.newRequest(), &en.Level{})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if game.pushLevel(ctx, game.newRequest(), &en.Level{}) {
+		t.Errorf("Expected level to be dropped when context is canceled")
+	}
+}
+
+func TestHandleLevelUpdatesRecover(t *testing.T) {
+	var (
+		messages = collectMessages()
+		game     = NewGame(tb.Chat{ID: testChatID}, &GameSettings{ChatID: testChatID})
+	)
+	defer messages.stop()
+	go handleLevelUpdates(game)
+	defer close(game.done)
+
+	// nil level panics in the handler, next updates should still be processed
+	game.pushLevel(context.Background(), game.newRequest(), nil)
+	game.pushLevel(context.Background(), game.newRequest(), &en.Level{LevelID: 1, Number: 1, Name: "Next"})
+	if level := waitForLevel(t, game); level.Name != "Next" {
+		t.Errorf("Expected level after the panic, got %+v", level)
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	for _, example := range []struct {
+		name     string
+		level    en.Level
+		changed  bool
+		interval time.Duration
+		expected time.Duration
+	}{
+		{"changed", en.Level{}, true, 4 * time.Second, DefaultPollInterval},
+		{"not changed", en.Level{}, false, 2 * time.Second, 3 * time.Second},
+		{"max", en.Level{}, false, MaxPollInterval, MaxPollInterval},
+		{"level timeout", en.Level{Timeout: 3600, TimeoutSecondsRemain: 20}, false, 5 * time.Second, MinPollInterval},
+		{"no level timeout", en.Level{TimeoutSecondsRemain: 20}, false, 2 * time.Second, 3 * time.Second},
+		{"help", en.Level{Helps: en.LevelHelps{{RemainSeconds: 10}}}, true, time.Second, MinPollInterval},
+		{"bonus", en.Level{Bonuses: en.LevelBonuses{{SecondsToStart: 25}}}, false, time.Second, MinPollInterval},
+		{"before deadline", en.Level{Helps: en.LevelHelps{{RemainSeconds: 32}}}, false, 4 * time.Second, 2 * time.Second},
+	} {
+		if interval := pollInterval(&example.level, example.changed, example.interval); interval != example.expected {
+			t.Errorf("%s: expected interval %s, got %s", example.name, example.expected, interval)
+		}
+	}
+}
+
+func TestLevelTimeCheckingMachine(t *testing.T) {
+	for _, example := range []struct {
+		name      string
+		minutes   []int
+		levelTime time.Duration
+		remaining []time.Duration
+		expected  []bool
+	}{
+		{"default thresholds", []int{60, 30, 15, 5, 1}, 2 * time.Hour,
+			[]time.Duration{70 * time.Minute, 59 * time.Minute, 50 * time.Minute, 20 * time.Minute, 14 * time.Minute},
+			[]bool{false, true, false, true, true}},
+		{"short level", []int{60, 30, 15, 5, 1}, 40 * time.Minute,
+			[]time.Duration{35 * time.Minute, 20 * time.Minute, 14 * time.Minute},
+			[]bool{false, false, true}},
+		{"level shorter than double threshold", []int{100}, 2 * time.Hour,
+			[]time.Duration{110 * time.Minute, 99 * time.Minute, 50 * time.Minute},
+			[]bool{false, true, false}},
+		{"several thresholds at once", []int{30, 15, 5}, time.Hour,
+			[]time.Duration{4 * time.Minute, 3 * time.Minute, 2 * time.Minute, time.Minute},
+			[]bool{true, true, true, false}},
+		{"no thresholds", nil, time.Hour,
+			[]time.Duration{30 * time.Minute, time.Minute},
+			[]bool{false, false}},
+	} {
+		var (
+			machine = initTimeLevelChecking(example.minutes)
+			result  []bool
+		)
+		machine.ResetState(example.levelTime)
+		for _, remaining := range example.remaining {
+			result = append(result, machine.Process(remaining))
+		}
+		if !reflect.DeepEqual(result, example.expected) {
+			t.Errorf("%s: expected notifications %v, got %v", example.name, example.expected, result)
+		}
+	}
+}
+
+func TestAllowedDomain(t *testing.T) {
+	for domain, allowed := range map[string]bool{
+		"kharkov.en.cx":          true,
+		"quest.ua":               true,
+		"moscow.en.cx":           true,
+		"demo.quest.ua":          true,
+		"en.cx.example.com":      false,
+		"example.com":            false,
+		"169.254.169.254":        false,
+		"127.0.0.1:8080":         false,
+		"user@moscow.en.cx":      false,
+		"moscow.en.cx/login":     false,
+		"evil.com?.en.cx":        false,
+		"localhost":              false,
+		"[::1]":                  false,
+		"10.0.0.1.en.cx.evil.io": false,
+	} {
+		if allowedDomain(domain) != allowed {
+			t.Errorf("Expected domain %q to be allowed: %t", domain, allowed)
+		}
+	}
+}
+
+func TestSettingsMachineEndToEnd(t *testing.T) {
+	const (
+		chatID = 42
+		userID = 7
+	)
+	var (
+		engine   = newTestEngine()
+		messages = collectMessages()
+	)
+	defer engine.Close()
+	defer messages.stop()
+	// test engine is running on the local address that can't be entered otherwise
+	QuestDomains[engine.Domain()] = "http://" + engine.Domain()
+	defer delete(QuestDomains, engine.Domain())
+
+	settingsMachines.Start(&messageChan, chatID, userID)
+	messages.waitFor(t, ChooseDomainString, time.Second)
+
+	if settingsMachines.Process(chatID, userID+1, engine.Domain()) {
+		t.Errorf("Expected input from another user to be ignored")
+	}
+
+	for _, input := range []string{engine.Domain(), "abc", "25733", testLogin, "wrong", testLogin} {
+		if !settingsMachines.Process(chatID, userID, input) {
+			t.Fatalf("Expected input %q to be processed", input)
+		}
+	}
+	password := tb.Message{ID: 7, Chat: tb.Chat{ID: chatID, Type: tb.Group},
+		Sender: tb.User{ID: userID}, Text: testPassword}
+	if !settingsMachines.ProcessMessage(password) {
+		t.Fatalf("Expected password to be processed")
+	}
+	messages.waitFor(t, "Неверный номер игры", time.Second)
+	messages.waitFor(t, "Не удалось войти в движок", time.Second)
+	messages.waitFor(t, PasswordDeletedString, time.Second)
+	messages.waitFor(t, "Вход выполнен успешно", time.Second)
+
+	settingsMachines.Process(chatID, userID, settingsConfirm)
+	messages.waitFor(t, SettingsSavedString, time.Second)
+
+	game, err := games.Get(chatID)
+	if err != nil {
+		t.Fatalf("Expected game to be registered, got %s", err)
+	}
+	if game.Settings.GameID != testGameID || game.Settings.UserName != testLogin {
+		t.Errorf("Unexpected settings %s", game.Settings)
+	}
+	if settingsMachines.Process(chatID, userID, "anything") {
+		t.Errorf("Expected configuration to be finished")
+	}
+}
+
+// blockingStep step that waits until it is released, e.g. login to the slow engine
+type blockingStep struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (bs blockingStep) Prepare() {}
+
+func (bs blockingStep) Process(args ...interface{}) bool {
+	bs.started <- struct{}{}
+	<-bs.release
+	return false
+}
+
+func TestSettingsMachinesSlowStep(t *testing.T) {
+	var (
+		machines = NewSettingsMachines()
+		output   = make(chan MessageSender, 10)
+		step     = blockingStep{started: make(chan struct{}), release: make(chan struct{})}
+		done     = make(chan bool)
+	)
+	defer close(step.release)
+	machines.Start(&output, 1, testCaptainID)
+	machines.Start(&output, 2, testCaptainID)
+	machines.machines[1].steps[stepDomain] = step
+
+	go machines.Process(1, testCaptainID, "slow")
+	<-step.started
+	go func() {
+		done <- machines.Process(2, testCaptainID, "abc")
+	}()
+	select {
+	case processed := <-done:
+		if !processed {
+			t.Errorf("Expected input in another chat to be processed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Input in another chat is blocked by the slow step")
+	}
+}
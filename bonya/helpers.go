@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"log"
 	"regexp"
-	"strings"
 
 	"github.com/bonya_bot/en"
 	"github.com/tucnak/telebot"
@@ -17,6 +16,24 @@ type EnvConfig struct {
 	MainChat     int64  `envconfig:"main_chat"`
 	User         string
 	Password     string
+	// DbName database where game settings are stored, if it is empty settings
+	// are kept in memory only
+	DbName     string `envconfig:"db_name"`
+	DbAddr     string `envconfig:"db_addr" default:"localhost:5432"`
+	DbUser     string `envconfig:"db_user" default:"bonya"`
+	DbPassword string `envconfig:"db_password" default:"bonya"`
+	// HistoryFile file where history of the games is stored if database is not configured
+	HistoryFile string `envconfig:"history_file" default:"history.jsonl"`
+	// ImagesDir directory where images of the levels are cached
+	ImagesDir string `envconfig:"images_dir" default:"/tmp/bonya/images"`
+	// ListenAddr address of the API server
+	ListenAddr string `envconfig:"listen_addr" default:":8081"`
+	// TLS serves the API over https with the certificate and key from TLSCert and TLSKey
+	TLS     bool   `envconfig:"tls"`
+	TLSCert string `envconfig:"tls_cert" default:"bonya-cert.pem"`
+	TLSKey  string `envconfig:"tls_key" default:"bonya-key.pem"`
+	// Owners ids of the Telegram users that have the owner role in all chats
+	Owners []int `envconfig:"owners"`
 }
 
 type BotMessage struct {
@@ -112,22 +129,3 @@ func isNewLevel(oldLevel *en.Level, newLevel *en.Level) bool {
 	}
 	return oldLevel.LevelID != newLevel.LevelID
 }
-
-// SplitText splits the input text into chunks of size `size`
-func SplitText(text string, size int) (result []string) {
-	if len(text) > size {
-		var lIndex = 0
-		for {
-			spaceIndex := strings.LastIndex(text[lIndex:lIndex+size+1], " ")
-			result = append(result, text[lIndex:lIndex+spaceIndex])
-			lIndex = lIndex + spaceIndex + 1
-			if len(text[lIndex:]) < 4096 {
-				result = append(result, text[lIndex:])
-				break
-			}
-		}
-	} else {
-		result = append(result, text)
-	}
-	return
-}
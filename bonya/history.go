@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonya_bot/en"
+	"github.com/go-pg/pg"
+	tb "github.com/tucnak/telebot"
+)
+
+// HistoryRecord one record in the history of the game: level change, entered code,
+// opened hint, closed sector or bonus
+type HistoryRecord struct {
+	tableName struct{} `sql:"history"`
+
+	ID          int64
+	ChatID      int64
+	GameID      int32
+	LevelNumber int8
+	// Type name of the en.EventType that is recorded
+	Type string
+	// Text short description of the event
+	Text string
+	// Author who entered the code, telegram user for the codes sent through
+	// the bot or player login in the engine
+	Author    string
+	CreatedAt time.Time
+}
+
+func (hr HistoryRecord) String() string {
+	var text = hr.Text
+	if hr.Author != "" {
+		text = fmt.Sprintf(HistoryAuthorString, text, hr.Author)
+	}
+	return fmt.Sprintf("%s %s", hr.CreatedAt.Local().Format("15:04:05"), text)
+}
+
+// EventStore append-only storage for the history of the games
+type EventStore interface {
+	// Append adds record to the end of the history
+	Append(record *HistoryRecord) error
+	// Level returns history of the level in the order records were added
+	Level(chatID int64, gameID int32, levelNumber int8) ([]HistoryRecord, error)
+}
+
+// PgEventStore stores history in PostgreSQL database, table is created by the migrations
+type PgEventStore struct {
+	db *pg.DB
+}
+
+// NewPgEventStore constructor for the PgEventStore
+func NewPgEventStore(db *pg.DB) *PgEventStore {
+	return &PgEventStore{db: db}
+}
+
+// Append implements EventStore interface
+func (s *PgEventStore) Append(record *HistoryRecord) error {
+	return s.db.Insert(record)
+}
+
+// Level implements EventStore interface
+func (s *PgEventStore) Level(chatID int64, gameID int32, levelNumber int8) (records []HistoryRecord, err error) {
+	err = s.db.Model(&records).
+		Where("chat_id = ?", chatID).
+		Where("game_id = ?", gameID).
+		Where("level_number = ?", levelNumber).
+		Order("id").
+		Select()
+	return
+}
+
+// FileEventStore stores history in the local file, one json record per line.
+// It is used when database is not configured
+type FileEventStore struct {
+	*sync.Mutex
+	path string
+}
+
+// NewFileEventStore constructor for the FileEventStore
+func NewFileEventStore(path string) *FileEventStore {
+	return &FileEventStore{
+		Mutex: &sync.Mutex{},
+		path:  path,
+	}
+}
+
+// Append implements EventStore interface
+func (s *FileEventStore) Append(record *HistoryRecord) error {
+	s.Lock()
+	defer s.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(record)
+}
+
+// Level implements EventStore interface
+func (s *FileEventStore) Level(chatID int64, gameID int32, levelNumber int8) (records []HistoryRecord, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Printf("[WARNING] Skipping broken history record: %s", err)
+			continue
+		}
+		if record.ChatID == chatID && record.GameID == gameID && record.LevelNumber == levelNumber {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// appendHistory adds the record for the game to the event store
+func appendHistory(game *Game, levelNumber int8, eventType en.EventType, text string, author string) {
+	var record = &HistoryRecord{
+		ChatID:      game.Chat.ID,
+		GameID:      game.Settings.GameID,
+		LevelNumber: levelNumber,
+		Type:        eventType.String(),
+		Text:        text,
+		Author:      author,
+		CreatedAt:   time.Now(),
+	}
+	if err := eventStore.Append(record); err != nil {
+		log.Printf("[ERROR] Can't save history record for %s: %s", game, err)
+	}
+	streams.Publish(game.Chat.ID, StreamEvent{Name: HistoryStreamEvent, Data: historyResponse(*record)})
+}
+
+// recordEvents writes the events that should be kept in the history to the event store
+func recordEvents(game *Game, events []en.Event) {
+	for _, event := range events {
+		var (
+			level  = event.Level
+			text   string
+			author string
+		)
+		switch event.Type {
+		case en.LevelChanged:
+			text = fmt.Sprintf(HistoryLevelString, level.Number, level.Name)
+		case en.HelpOpened:
+			text = fmt.Sprintf(HistoryHelpString, event.Help.Number)
+		case en.PenaltyHelpOpened:
+			text = fmt.Sprintf(HistoryPenaltyHelpString, event.Help.Number)
+		case en.SectorClosed:
+			text = fmt.Sprintf(HistorySectorString, event.Sector.Name, answerOf(event.Sector.Answer))
+			author = loginOf(event.Sector.Answer)
+		case en.BonusClosed:
+			text = fmt.Sprintf(HistoryBonusString, event.Bonus.Name, answerOf(event.Bonus.Answer))
+			author = loginOf(event.Bonus.Answer)
+		case en.CodeEntered:
+			// codes sent through the bot are recorded with the telegram user by sendCode
+			if strings.EqualFold(event.Action.Login, game.Engine.Username) {
+				continue
+			}
+			text = codeHistoryText(event.Action.Answer, event.Action.IsCorrect)
+			author = event.Action.Login
+		default:
+			continue
+		}
+		appendHistory(game, level.Number, event.Type, text, author)
+	}
+}
+
+func codeHistoryText(code string, correct bool) string {
+	if correct {
+		return fmt.Sprintf(HistoryCorrectCodeString, code)
+	}
+	return fmt.Sprintf(HistoryIncorrectCodeString, code)
+}
+
+// senderName returns the name of the telegram user to show in the history
+func senderName(user tb.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+func answerOf(answer map[string]interface{}) string {
+	value, _ := answer["Answer"].(string)
+	return value
+}
+
+func loginOf(answer map[string]interface{}) string {
+	value, _ := answer["Login"].(string)
+	return value
+}
+
+// escapeMarkdown escapes symbols that have special meaning in the Markdown parse
+// mode, it is used for the texts that are entered by players
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
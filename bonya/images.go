@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonya_bot/en"
+	tb "github.com/tucnak/telebot"
+)
+
+const (
+	// MaxImageSize the largest image that can be sent to Telegram as photo
+	MaxImageSize = 10 << 20
+	// ImageDownloadWorkers how many images are downloaded at once
+	ImageDownloadWorkers = 4
+	// ImageDownloadTimeout time to download one image
+	ImageDownloadTimeout = 30 * time.Second
+	// MaxMediaGroupSize how many photos can be sent in one media group
+	MaxMediaGroupSize = 10
+	// ImageQueueSize how many sets of images can wait for the download
+	ImageQueueSize = 100
+)
+
+var (
+	// ErrNotImage returned when the downloaded file is not an image
+	ErrNotImage = errors.New("file is not an image")
+	// ErrImageTooLarge returned when the image is larger than MaxImageSize
+	ErrImageTooLarge = errors.New("image is too large")
+)
+
+// imageExtensions extensions of the cached files for the content types
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// ImageService downloads images of the levels, hints and bonuses and caches them
+// per game and level. Telegram file_id of the uploaded image is remembered, so that
+// the image is uploaded only once
+type ImageService struct {
+	*sync.RWMutex
+	client *http.Client
+	dir    string
+	// fileIDs Telegram file_id for the hash of the image URL
+	fileIDs map[string]string
+	// queue images that are waiting to be downloaded and sent
+	queue chan imageJob
+
+	// Workers how many images are downloaded at once
+	Workers int
+	// MaxSize the largest image that is downloaded
+	MaxSize int64
+}
+
+// NewImageService constructor for the ImageService, images are stored in the dir
+func NewImageService(dir string) *ImageService {
+	return &ImageService{
+		RWMutex: &sync.RWMutex{},
+		client:  &http.Client{Timeout: ImageDownloadTimeout},
+		dir:     dir,
+		fileIDs: make(map[string]string),
+		queue:   make(chan imageJob, ImageQueueSize),
+		Workers: ImageDownloadWorkers,
+		MaxSize: MaxImageSize,
+	}
+}
+
+// imageKey returns the key of the image in the cache
+func imageKey(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+// FileID returns Telegram file_id of the image if it was uploaded already
+func (s *ImageService) FileID(url string) (string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	id, ok := s.fileIDs[imageKey(url)]
+	return id, ok
+}
+
+// SetFileID remembers Telegram file_id of the uploaded image
+func (s *ImageService) SetFileID(url string, id string) {
+	if id == "" {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.fileIDs[imageKey(url)] = id
+}
+
+// Download downloads images of the level in parallel. Images that were uploaded to
+// Telegram already or are in the cache are not downloaded again. Returns images
+// that can be sent, Filepath is set for the ones that are not uploaded yet
+func (s *ImageService) Download(gameID int32, level int8, images en.Images) en.Images {
+	var (
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, s.Workers)
+		result  = make([]*en.Image, len(images))
+		dir     = filepath.Join(s.dir, strconv.Itoa(int(gameID)), strconv.Itoa(int(level)))
+	)
+	for i, image := range images {
+		if _, ok := s.FileID(image.URL); ok {
+			image := image
+			result[i] = &image
+			continue
+		}
+		wg.Add(1)
+		go func(i int, image en.Image) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			filename, err := s.download(dir, image.URL)
+			if err != nil {
+				log.Printf("[ERROR] Can't download image %s: %s", image.URL, err)
+				return
+			}
+			image.Filepath = filename
+			result[i] = &image
+		}(i, image)
+	}
+	wg.Wait()
+
+	var downloaded en.Images
+	for _, image := range result {
+		if image != nil {
+			downloaded = append(downloaded, *image)
+		}
+	}
+	return downloaded
+}
+
+// imageJob images of the level, hint or bonus that should be sent to the chat
+type imageJob struct {
+	recipient tb.Recipient
+	gameID    int32
+	level     int8
+	images    en.Images
+}
+
+// Queue adds images to the queue of the worker, so that the caller is not blocked
+// while they are downloaded. Images are dropped if the queue is full
+func (s *ImageService) Queue(recipient tb.Recipient, gameID int32, level int8, images en.Images) {
+	select {
+	case s.queue <- imageJob{recipient: recipient, gameID: gameID, level: level, images: images}:
+	default:
+		log.Printf("[ERROR] Queue of the images is full, %d images are dropped", len(images))
+	}
+}
+
+// Run downloads queued images one set after another and sends them to the output,
+// so that images are sent in the order they were queued
+func (s *ImageService) Run(output chan<- MessageSender) {
+	for job := range s.queue {
+		if images := s.Download(job.gameID, job.level, job.images); len(images) > 0 {
+			output <- s.Message(job.recipient, images)
+		}
+	}
+}
+
+// cached returns the file of the image in the directory if it was downloaded already
+func cached(dir string, key string) (string, bool) {
+	files, _ := filepath.Glob(filepath.Join(dir, key+".*"))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.Size() > 0 && !strings.HasSuffix(file, ".tmp") {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// download saves the image into the directory, file is named by the hash of URL
+func (s *ImageService) download(dir string, url string) (string, error) {
+	var key = imageKey(url)
+	if filename, ok := cached(dir, key); ok {
+		return filename, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	response, err := s.client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", response.Status)
+	}
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(response.Header.Get("Content-Type"), ";")[0]))
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("%w: %q", ErrNotImage, contentType)
+	}
+	if response.ContentLength > s.MaxSize {
+		return "", fmt.Errorf("%w: %d bytes", ErrImageTooLarge, response.ContentLength)
+	}
+
+	extension, ok := imageExtensions[contentType]
+	if !ok {
+		extension = strings.ToLower(path.Ext(url))
+	}
+	file, err := ioutil.TempFile(dir, key+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	written, err := io.Copy(file, io.LimitReader(response.Body, s.MaxSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case err != nil:
+		return "", err
+	case written > s.MaxSize:
+		return "", fmt.Errorf("%w: more than %d bytes", ErrImageTooLarge, s.MaxSize)
+	}
+
+	filename := filepath.Join(dir, key+extension)
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// photo returns the photo for the image, file_id is used if image was uploaded already
+func (s *ImageService) photo(image en.Image) (*tb.Photo, error) {
+	if id, ok := s.FileID(image.URL); ok {
+		return &tb.Photo{File: tb.File{FileID: id}, Caption: image.Caption}, nil
+	}
+	file, err := tb.NewFile(image.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return &tb.Photo{File: file, Caption: image.Caption}, nil
+}
+
+// Message returns the message with the images, images are sent as media groups
+func (s *ImageService) Message(recipient tb.Recipient, images en.Images) *ImagesMessage {
+	return &ImagesMessage{Message: Message{Recipient: recipient}, service: s, Images: images}
+}
+
+// ImagesMessage images that are sent to chat in media groups, file_id of the sent
+// images are remembered by the image service
+type ImagesMessage struct {
+	Message
+
+	Images  en.Images
+	service *ImageService
+}
+
+// Send implementation of Sender interface for ImagesMessage type
+func (im ImagesMessage) Send(bot BotSender) (err error) {
+	for _, part := range im.parts() {
+		if e := part.Send(bot); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// parts returns media groups of the images, so that dispatcher can send and retry
+// them one by one
+func (im ImagesMessage) parts() (messages []MessageSender) {
+	var group = imageGroup{AlbumMessage: AlbumMessage{Message: im.Message}, service: im.service}
+	for _, image := range im.Images {
+		photo, err := im.service.photo(image)
+		if err != nil {
+			log.Printf("[ERROR] Can't read image %s: %s", image.URL, err)
+			continue
+		}
+		group.Photos = append(group.Photos, photo)
+		group.urls = append(group.urls, image.URL)
+		if len(group.Photos) == MaxMediaGroupSize {
+			messages = append(messages, group)
+			group = imageGroup{AlbumMessage: AlbumMessage{Message: im.Message}, service: im.service}
+		}
+	}
+	if len(group.Photos) > 0 {
+		messages = append(messages, group)
+	}
+	return
+}
+
+// imageGroup media group that remembers file_id of the photos after they are sent
+type imageGroup struct {
+	AlbumMessage
+
+	urls    []string
+	service *ImageService
+}
+
+// Send implementation of Sender interface for imageGroup type
+func (ig imageGroup) Send(bot BotSender) error {
+	err := ig.AlbumMessage.Send(bot)
+	for i, photo := range ig.Photos {
+		ig.service.SetFileID(ig.urls[i], photo.FileID)
+	}
+	return err
+}
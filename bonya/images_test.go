@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonya_bot/en"
+	tb "github.com/tucnak/telebot"
+)
+
+// imageServer serves images and counts the requests for each path
+type imageServer struct {
+	sync.Mutex
+	*httptest.Server
+	requests map[string]int
+}
+
+func newImageServer() *imageServer {
+	var server = &imageServer{requests: map[string]int{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.Lock()
+		server.requests[r.URL.Path]++
+		server.Unlock()
+		switch r.URL.Path {
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html></html>")
+		case "/big.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			fmt.Fprint(w, strings.Repeat("x", 200))
+		case "/missing.png":
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "png "+r.URL.Path)
+		}
+	}))
+	return server
+}
+
+func (is *imageServer) count(path string) int {
+	is.Lock()
+	defer is.Unlock()
+	return is.requests[path]
+}
+
+func newTestImageService(t *testing.T) *ImageService {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var service = NewImageService(dir)
+	service.MaxSize = 100
+	return service
+}
+
+func TestImageServiceDownload(t *testing.T) {
+	var (
+		server  = newImageServer()
+		service = newTestImageService(t)
+		images  = en.Images{
+			{URL: server.URL + "/1.png", Caption: "Картинка #1"},
+			{URL: server.URL + "/page.html", Caption: "Картинка #2"},
+			{URL: server.URL + "/big.jpg", Caption: "Картинка #3"},
+			{URL: server.URL + "/missing.png", Caption: "Картинка #4"},
+			{URL: server.URL + "/5.png", Caption: "Картинка #5"},
+		}
+	)
+	defer server.Close()
+
+	downloaded := service.Download(1, 2, images)
+	var captions []string
+	for _, image := range downloaded {
+		captions = append(captions, image.Caption)
+		content, err := ioutil.ReadFile(image.Filepath)
+		if err != nil {
+			t.Fatalf("Can't read downloaded image: %s", err)
+		}
+		if !strings.HasPrefix(string(content), "png ") || !strings.HasSuffix(image.Filepath, ".png") {
+			t.Errorf("Unexpected image %s: %q", image.Filepath, content)
+		}
+	}
+	if expected := []string{"Картинка #1", "Картинка #5"}; !reflect.DeepEqual(captions, expected) {
+		t.Errorf("Expected images %q, got %q", expected, captions)
+	}
+
+	// images are cached per game and level
+	service.Download(1, 2, images[:1])
+	if count := server.count("/1.png"); count != 1 {
+		t.Errorf("Expected cached image to be downloaded once, got %d requests", count)
+	}
+	service.Download(1, 3, images[:1])
+	if count := server.count("/1.png"); count != 2 {
+		t.Errorf("Expected image of another level to be downloaded, got %d requests", count)
+	}
+
+	// uploaded images are not downloaded at all
+	service.SetFileID(images[4].URL, "file-5")
+	downloaded = service.Download(2, 1, images[4:])
+	if count := server.count("/5.png"); count != 1 || len(downloaded) != 1 {
+		t.Errorf("Expected uploaded image not to be downloaded, got %d requests", count)
+	}
+	if photo, err := service.photo(downloaded[0]); err != nil || photo.FileID != "file-5" {
+		t.Errorf("Expected photo with file_id, got %v %v", photo, err)
+	}
+}
+
+func TestImageServiceQueue(t *testing.T) {
+	var (
+		server  = newImageServer()
+		service = newTestImageService(t)
+		output  = make(chan MessageSender)
+	)
+	defer server.Close()
+
+	// queue doesn't block when the worker is busy
+	service.queue = make(chan imageJob, 2)
+	for i := 1; i <= 3; i++ {
+		service.Queue(testRecipient{name: "1"}, 1, 1, en.Images{{URL: fmt.Sprintf("%s/%d.png", server.URL, i), Caption: fmt.Sprint(i)}})
+	}
+
+	go service.Run(output)
+	for _, expected := range []string{"1", "2"} {
+		select {
+		case message := <-output:
+			images := message.(*ImagesMessage).Images
+			if len(images) != 1 || images[0].Caption != expected {
+				t.Errorf("Expected image %s, got %v", expected, images)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected images %s to be sent", expected)
+		}
+	}
+	select {
+	case message := <-output:
+		t.Errorf("Expected images to be dropped when the queue is full, got %v", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// albumBot sends media groups and assigns file_id to the photos
+type albumBot struct {
+	recordingBot
+	albums []int
+}
+
+func (ab *albumBot) SendMediaGroup(recipient tb.Recipient, photos []*tb.Photo, options *tb.SendOptions) error {
+	ab.albums = append(ab.albums, len(photos))
+	for _, photo := range photos {
+		photo.FileID = "id:" + photo.Caption
+	}
+	return nil
+}
+
+func TestImagesMessage(t *testing.T) {
+	var (
+		server  = newImageServer()
+		service = newTestImageService(t)
+		images  en.Images
+		bot     = &albumBot{recordingBot: *newRecordingBot()}
+	)
+	defer server.Close()
+	for i := 1; i <= 11; i++ {
+		images = append(images, en.Image{URL: fmt.Sprintf("%s/%d.png", server.URL, i), Caption: fmt.Sprint(i)})
+	}
+
+	message := service.Message(testRecipient{name: "1"}, service.Download(1, 1, images))
+	if len(message.parts()) != 2 {
+		t.Fatalf("Expected 2 media groups, got %d", len(message.parts()))
+	}
+	if err := message.Send(bot); err != nil {
+		t.Fatalf("Can't send images: %s", err)
+	}
+	// the last group has one photo only, so it is sent as photo
+	if expected := []int{10}; !reflect.DeepEqual(bot.albums, expected) {
+		t.Errorf("Expected albums %v, got %v", expected, bot.albums)
+	}
+	if id, ok := service.FileID(images[0].URL); !ok || id != "id:1" {
+		t.Errorf("Expected file_id to be remembered, got %q", id)
+	}
+}
+
+func TestSendMediaGroup(t *testing.T) {
+	var (
+		service = newTestImageService(t)
+		images  = newImageServer()
+		form    = map[string]string{}
+		files   []string
+	)
+	defer images.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottoken/sendMediaGroup" {
+			t.Errorf("Unexpected request %s", r.URL.Path)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		for key := range r.MultipartForm.Value {
+			form[key] = r.FormValue(key)
+		}
+		for key := range r.MultipartForm.File {
+			files = append(files, key)
+		}
+		fmt.Fprint(w, `{"ok":true,"result":[{"photo":[{"file_id":"small"},{"file_id":"large"}]},{"photo":[{"file_id":"old"}]}]}`)
+	}))
+	defer server.Close()
+
+	downloaded := service.Download(1, 1, en.Images{{URL: images.URL + "/1.png", Caption: "Картинка #1"}})
+	photos := []*tb.Photo{
+		{File: mustFile(t, downloaded[0].Filepath), Caption: "Картинка #1"},
+		{File: tb.File{FileID: "old"}},
+	}
+	bot := NewTelegramBot(nil, "token")
+	bot.APIURL = server.URL
+	if err := bot.SendMediaGroup(testRecipient{name: "42"}, photos, &tb.SendOptions{ReplyTo: tb.Message{ID: 7}}); err != nil {
+		t.Fatalf("Can't send media group: %s", err)
+	}
+
+	var media []inputMediaPhoto
+	if err := json.Unmarshal([]byte(form["media"]), &media); err != nil {
+		t.Fatalf("Can't parse media: %s", err)
+	}
+	expected := []inputMediaPhoto{
+		{Type: "photo", Media: "attach://photo0", Caption: "Картинка #1"},
+		{Type: "photo", Media: "old"},
+	}
+	if !reflect.DeepEqual(media, expected) {
+		t.Errorf("Expected media %v, got %v", expected, media)
+	}
+	if form["chat_id"] != "42" || form["reply_to_message_id"] != "7" {
+		t.Errorf("Unexpected form %v", form)
+	}
+	if !reflect.DeepEqual(files, []string{"photo0"}) {
+		t.Errorf("Expected one uploaded file, got %v", files)
+	}
+	if photos[0].FileID != "large" {
+		t.Errorf("Expected file_id of the largest photo, got %q", photos[0].FileID)
+	}
+}
+
+func TestSendMediaGroupError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"ok":false,"description":"Too Many Requests: retry after 5"}`)
+	}))
+	defer server.Close()
+
+	bot := NewTelegramBot(nil, "token")
+	bot.APIURL = server.URL
+	err := bot.SendMediaGroup(testRecipient{name: "42"}, []*tb.Photo{{File: tb.File{FileID: "a"}}, {File: tb.File{FileID: "b"}}}, nil)
+	if wait, limited := retryAfter(err); !limited || wait.Seconds() != 5 {
+		t.Errorf("Expected rate limit error, got %v", err)
+	}
+}
+
+func mustFile(t *testing.T, filename string) tb.File {
+	file, err := tb.NewFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
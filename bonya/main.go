@@ -1,21 +1,15 @@
 package main
 
 import (
-	"container/list"
+	"context"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/http/cookiejar"
-	"os"
-	"path"
 	"regexp"
-	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/bonya_bot/en"
+	"github.com/go-pg/pg"
 	"github.com/kelseyhightower/envconfig"
 	tb "github.com/tucnak/telebot"
 )
@@ -44,69 +38,58 @@ var DEBUG = true
 // }
 
 var (
-	quit chan struct{}
-
 	// sendInfoChan   chan en.ToChat
 	// photoInfoChan  chan *PhotoInfo
 	// coordsInfoChan chan *CoordInfo
-	levelInfoChan chan *en.Level
-	messageChan   chan MessageSender
-
-	mainChat tb.Chat
+	messageChan chan MessageSender
+
+	// games registry of the games for all chats
+	games *GameRegistry
+	// defaultSettings settings from the environment that are used for the chats
+	// where /setchat was called
+	defaultSettings *GameSettings
+	// settingsRepository storage for the settings of all games
+	settingsRepository GameSettingsRepository
+	// settingsMachines configurations of the games that are in progress
+	settingsMachines *SettingsMachines
+	// eventStore history of all games
+	eventStore EventStore
+	// imageService downloads images and remembers the ones uploaded to Telegram
+	imageService *ImageService
+	// streams subscribers of the live events of the games
+	streams *EventBroker
+	// tokens tokens of the API issued for the chats
+	tokens *TokenStore
+	// permissions roles of the users in the chats
+	permissions *Permissions
 )
 
 // Helpers
 
-func SendImageFromUrl(recipient tb.Recipient, images en.Images) {
-	var (
-		file *os.File
-	)
-	for _, img := range images {
-
-		resp, err := http.Get(img.URL)
-		if err != nil {
-			log.Println("Can't download image:", err)
-		}
-
-		filename := fmt.Sprintf("/tmp/%s", path.Base(img.URL))
-		fileInfo, err := os.Stat(filename)
-		if err == nil && fileInfo.Size() > 0 {
-			file, err = os.Open(filename)
-		} else {
-			log.Println("Image is not downloaded yet:", err)
-			file, err = os.Create(filename)
-			if err != nil {
-				log.Fatal("Cannot create file:", err)
-				return
-			}
-			// Use io.Copy to just dump the response body to the file. This supports huge files
-			_, err = io.Copy(file, resp.Body)
-			resp.Body.Close()
-			file.Close()
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
-		telebotFile, _ := tb.NewFile(file.Name())
-		log.Printf("Sending photo to the channel (%s)", file.Name())
-		thumbnail := tb.Thumbnail{File: telebotFile, Width: 120, Height: 120}
-		messageChan <- PhotoMessage{Message{Recipient: recipient, Options: nil},
-			&tb.Photo{File: telebotFile, Thumbnail: thumbnail, Caption: img.Caption}}
-		// photoInfoChan <- &PhotoInfo{Recepient: recepient, Photo: &telebot.Photo{File: telebotFile,
-		// 	Thumbnail: thumbnail, Caption: img.Caption}, Options: nil}
+// SendImages queues images of the level, hint or bonus, they are downloaded and sent
+// to the chat of the game by the worker of the image service
+func SendImages(game *Game, level *en.Level, images en.Images) {
+	var number int8
+	if len(images) == 0 {
+		return
 	}
+	if level != nil {
+		number = level.Number
+	}
+	imageService.Queue(game.Chat, game.Settings.GameID, number, images)
 }
 
-func SendCoords(recipient tb.Recipient, coords en.Coordinates) {
-	for _, coord := range coords {
-		messageChan <- LocationMessage{Message{recipient, nil},
-			&tb.Venue{Location: tb.Location{Latitude: float32(coord.Lat), Longitude: float32(coord.Lon)},
-				Title: coord.OriginalString}}
-		// coordsInfoChan <- &CoordInfo{Recepient: recepient,
-		// 	Location: &telebot.Venue{Location: telebot.Location{Latitude: float32(coord.Lat), Longitude: float32(coord.Lon)},
-		// 		Title: coord.OriginalString},
-		// 	Options: nil}
+// SendCoords sends one message with the links to the coordinates on the maps chosen
+// for the chat and the route through all points of the level
+func SendCoords(game *Game, level *en.Level, coords en.Coordinates) {
+	if len(coords) == 0 {
+		return
 	}
+	var route = coords
+	if level != nil {
+		route = level.AllCoords().Add(coords...)
+	}
+	messageChan <- coordinatesMessage(game.Chat, game.MapProvider(), coords, route, tb.Message{})
 }
 
 // IsBotCommand returns true if the message is a bot command or false otherwise
@@ -127,143 +110,206 @@ func IsBotCommand(m *tb.Message) bool {
 
 ///////////////////////////////
 
-func processLevel(recepient tb.Recipient, engine *en.API) {
+func startWatching(game *Game) {
+	var ctx context.Context
+
+	game.Lock()
+	defer game.Unlock()
+	if game.cancel != nil {
+		log.Printf("[INFO] Game %s is already monitored", game)
+		return
+	}
+
+	log.Printf("Start monitoring game %s", game)
+	ctx, game.cancel = context.WithCancel(context.Background())
+	go poll(ctx, game)
+}
+
+func stopWatching(game *Game) {
+	game.Lock()
+	defer game.Unlock()
+	if game.cancel != nil {
+		game.cancel()
+		game.cancel = nil
+	}
+}
+
+// setChat creates the game with default settings for the chat, so that all
+// level updates are sent there
+func setChat(chat tb.Chat) {
 	var (
-		retries   = 0
-		levelInfo *en.Level
-		err       error
+		settings = *defaultSettings
+		game     *Game
 	)
 
-	for {
-		// retries = 0
-		levelInfo, err = engine.GetLevelInfo()
-		if err != nil {
-			if err.Error() == "No level info" {
-				return
-			}
-			if retries > 3 {
-				break
-			}
-			retries++
-			time.Sleep(time.Second)
-			log.Printf("Attempt #%d. Can't get level info: %s", retries, err)
-			engine.Login()
-			continue
-		}
-		break
+	settings.ChatID = chat.ID
+	settings.Watching = false
+	keepChatSettings(&settings)
+	game = NewGame(chat, &settings)
+	if err := game.Engine.Login2(settings.UserName, settings.Password); err != nil {
+		log.Printf("[ERROR] Can't login for chat %d: %s", chat.ID, err)
 	}
-	if levelInfo == nil {
-		log.Println("Can't find level information")
+	games.Register(game)
+	saveSettings(game)
+}
+
+// restoreGames creates games for all settings that were saved before and resumes
+// monitoring for the games that were monitored before restart
+func restoreGames(bot *tb.Bot) {
+	allSettings, err := settingsRepository.All()
+	if err != nil {
+		log.Printf("[ERROR] Can't load game settings: %s", err)
 		return
 	}
-	engine.CurrentLevel = levelInfo
 
-	engine.CurrentLevel.Tasks[0].TaskText, engine.CurrentLevel.Coords =
-		en.ExtractCoordinates(engine.CurrentLevel.Tasks[0].TaskText)
-
-	engine.CurrentLevel.Tasks[0].TaskText, engine.CurrentLevel.Images =
-		en.ExtractImages(engine.CurrentLevel.Tasks[0].TaskText, "Картинка")
+	for i := range allSettings {
+		var (
+			settings = &allSettings[i]
+			game     = NewGame(initChat(bot, settings.ChatID), settings)
+		)
+		log.Printf("[INFO] Restoring game %s", settings)
+		if err := game.Engine.Login2(settings.UserName, settings.Password); err != nil {
+			log.Printf("[ERROR] Can't login for chat %d: %s", settings.ChatID, err)
+		}
+		games.Register(game)
+		if settings.Watching {
+			startWatching(game)
+		}
+	}
+}
 
-	engine.CurrentLevel.Tasks[0].TaskText = en.ReplaceCommonTags(engine.CurrentLevel.Tasks[0].TaskText)
+// submitCode sends one code to the engine, records it in the history and passes the
+// new level information to the handler of level updates. Codes must be submitted
+// under codesMutex of the game. Returns true if the code is correct
+func submitCode(game *Game, code string, author string) (bool, error) {
+	level := game.CurrentLevel()
+	if level == nil {
+		return false, ErrNoLevel
+	}
+	if level.IsPassed || level.Dismissed || (level.BlockDuration > 0 && level.HasAnswerBlockRule) {
+		return false, ErrLevelBlocked
+	}
 
-	messageChan <- NewTextMessage(mainChat, engine.CurrentLevel.ToText(), tb.Message{})
-	// sendInfoChan <- engine.CurrentLevel
-	SendImageFromUrl(mainChat, engine.CurrentLevel.Images)
-	SendCoords(mainChat, engine.CurrentLevel.Coords)
-	//log.Printf("In func %p", &en.CurrentLevel.Coords)
-	//SendLevelInfo(recepient, en.CurrentLevel)
+	request := game.newRequest()
+	lvl, err := game.Engine.SendCode(code)
+	if err != nil {
+		return false, err
+	}
+	correct := len(lvl.MixedActions) > 0 && lvl.MixedActions[0].IsCorrect
+	appendHistory(game, level.Number, en.CodeEntered, codeHistoryText(code, correct), author)
+	game.pushLevel(context.Background(), request, lvl)
+	return correct, nil
 }
 
-func startWatching(engine *en.API) {
-	var (
-		ticker *time.Ticker
-	)
+func sendCode(game *Game, codesToSend []string, replyTo tb.Message) {
+	var codes = en.Codes{Message: replyTo}
 
-	log.Print("Start monitoring game")
-	ticker = time.NewTicker(1000 * time.Millisecond)
-	quit = make(chan struct{})
-	go func() {
-		defer func() {
-			if p := recover(); p != nil {
-				log.Println(fmt.Errorf("внутренняя ошибка: %v", p))
-			}
-		}()
+	game.codesMutex.Lock()
+	defer game.codesMutex.Unlock()
 
-		for {
-			select {
-			case <-ticker.C:
-				go func() {
-					levelInfo, err := engine.GetLevelInfo()
-					if err != nil {
-						log.Println("Error:", err)
-						return
-					}
-					levelInfoChan <- levelInfo
-				}()
-			case <-quit:
-				ticker.Stop()
-			}
+	defer func() {
+		if p := recover(); p != nil {
+			log.Println(fmt.Errorf("[sendCode] внутренняя ошибка: %v", p))
 		}
 	}()
-}
 
-func stopWatching() {
-	close(quit)
+	for _, code := range codesToSend {
+		log.Printf("Sending code %q to EN engine", code)
+		// TODO: 3) Do we need to send codes that were blocked ???
+		correct, err := submitCode(game, code, senderName(replyTo.Sender))
+		switch {
+		case err != nil:
+			log.Printf("Can't send code %q: %s", code, err)
+			codes.NotSent = append(codes.NotSent, code)
+			continue
+		case correct:
+			codes.Correct = append(codes.Correct, code)
+		default:
+			codes.Incorrect = append(codes.Incorrect, code)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	// sendInfoChan <- &codes
+	messageChan <- codesMessage(game.Chat, codes)
 }
 
-func setChat(chat tb.Chat) {
-	var mu sync.RWMutex
-
-	mu.Lock()
-	defer mu.Unlock()
+// codesMessage notification about the sent codes, it is sent before level updates
+func codesMessage(recipient tb.Recipient, codes en.Codes) TextMessage {
+	return TextMessage{Message: Message{Recipient: recipient,
+		Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
+			DisableWebPagePreview: true,
+			ReplyTo:               codes.ReplyTo()},
+		Urgent: true},
+		Text: codes.ToText()}
+}
 
-	mainChat = chat
+// findClosedBonus returns the bonus that became answered with the given code after
+// it was sent to the engine, or nil if code didn't close any bonus. Bonuses closed
+// at the same time by other players have other answers and are skipped
+func findClosedBonus(oldLevel *en.Level, newLevel *en.Level, code string) *en.BonusInfo {
+	var answered = map[int32]bool{}
+	for _, bonus := range oldLevel.Bonuses {
+		answered[bonus.BonusId] = bonus.IsAnswered
+	}
+	for i, bonus := range newLevel.Bonuses {
+		if !bonus.IsAnswered || answered[bonus.BonusId] {
+			continue
+		}
+		if answer, ok := bonus.Answer["Answer"].(string); !ok || !strings.EqualFold(answer, code) {
+			continue
+		}
+		return &newLevel.Bonuses[i]
+	}
+	return nil
 }
 
-func sendCode(engine *en.API, codesToSend []string, replyTo tb.Message) {
+func sendBonusCode(game *Game, codesToSend []string, replyTo tb.Message) {
 	var (
-		mu    sync.RWMutex
-		codes = en.Codes{Message: replyTo}
+		engine = game.Engine
+		codes  = en.Codes{Message: replyTo}
 	)
 
-	mu.Lock()
-	defer mu.Unlock()
+	game.codesMutex.Lock()
+	defer game.codesMutex.Unlock()
 
 	defer func() {
 		if p := recover(); p != nil {
-			log.Println(fmt.Errorf("[sendCode] внутренняя ошибка: %v", p))
+			log.Println(fmt.Errorf("[sendBonusCode] внутренняя ошибка: %v", p))
 		}
 	}()
 
 	for _, code := range codesToSend {
-		log.Printf("Sending code %q to EN engine", code)
-		// TODO: 3) Do we need to send codes that were blocked ???
-		if engine.CurrentLevel.IsPassed || engine.CurrentLevel.Dismissed ||
-			(engine.CurrentLevel.BlockDuration > 0 && engine.CurrentLevel.HasAnswerBlockRule) {
-			log.Printf("Level is blocked, can't send code %q", code)
+		log.Printf("Sending bonus code %q to EN engine", code)
+		level := game.CurrentLevel()
+		if level == nil {
+			messageChan <- NewTextMessage(game.Chat, NoLevelString, replyTo)
+			return
+		}
+		if level.IsPassed || level.Dismissed {
+			log.Printf("Level is closed, can't send bonus code %q", code)
 			codes.NotSent = append(codes.NotSent, code)
 			continue
 		}
 
-		lvl, err := engine.SendCode(code)
-		//log.Println(lvl.HasAnswerBlockRule)
+		request := game.newRequest()
+		lvl, err := engine.SendBonusCode(code)
 		if err != nil {
-			log.Println("Failed to send code:", err)
+			log.Println("Failed to send bonus code:", err)
+			codes.NotSent = append(codes.NotSent, code)
+			continue
 		}
-		if lvl.MixedActions[0].IsCorrect {
-			codes.Correct = append(codes.Correct, code)
+		bonus := findClosedBonus(level, lvl, code)
+		if bonus != nil {
+			codes.Correct = append(codes.Correct, fmt.Sprintf(en.BonusCodeString, code, escapeMarkdown(bonus.Name)))
 		} else {
 			codes.Incorrect = append(codes.Incorrect, code)
 		}
-		levelInfoChan <- lvl
+		appendHistory(game, level.Number, en.CodeEntered,
+			codeHistoryText(code, bonus != nil), senderName(replyTo.Sender))
+		game.pushLevel(context.Background(), request, lvl)
 		time.Sleep(500 * time.Millisecond)
 	}
-	// sendInfoChan <- &codes
-	messageChan <- TextMessage{Message: Message{Recipient: mainChat,
-		Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
-			DisableWebPagePreview: true,
-			ReplyTo:               codes.ReplyTo()}},
-		Text: codes.ToText()}
+	messageChan <- codesMessage(game.Chat, codes)
 }
 
 func extractCommandAndArguments(m tb.Message) (command string, args string) {
@@ -279,53 +325,55 @@ func extractCommandAndArguments(m tb.Message) (command string, args string) {
 			command = command[:idx]
 		}
 	} else {
+		// Telegram doesn't mark commands in Cyrillic as commands
 		re := regexp.MustCompile("/([А-я]+)\\s*(.*)")
-		result := re.FindStringSubmatch(m.Text)
-		command, args = result[1], result[2]
+		if result := re.FindStringSubmatch(m.Text); result != nil {
+			command, args = result[1], result[2]
+		}
 	}
 	return
 }
 
-func sectorsLeft(levelInfo *en.Level) {
+func sectorsLeft(recipient tb.Recipient, levelInfo *en.Level) {
 	var sectors = en.NewExtendedLevelSectors(levelInfo)
 	// sendInfoChan <- sectors
-	messageChan <- TextMessage{Message: Message{Recipient: mainChat,
+	messageChan <- TextMessage{Message: Message{Recipient: recipient,
 		Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
 			DisableWebPagePreview: true,
 			ReplyTo:               sectors.ReplyTo()}},
 		Text: sectors.ToText()}
 }
 
-func timeLeft(levelInfo *en.Level) {
+func timeLeft(recipient tb.Recipient, levelInfo *en.Level) {
 	var msg = fmt.Sprintf(en.TimeLeftString, en.PrettyTimePrint(levelInfo.TimeoutSecondsRemain, true))
 	// sendInfoChan <- NewBotMessage(msg)
-	messageChan <- TextMessage{Message: Message{Recipient: mainChat,
+	messageChan <- TextMessage{Message: Message{Recipient: recipient,
 		Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
 			DisableWebPagePreview: true}},
 		Text: msg}
 }
 
-func listHelps(levelInfo *en.Level) {
+func listHelps(recipient tb.Recipient, levelInfo *en.Level) {
 	for _, helpInfo := range levelInfo.Helps {
 		//log.Printf("==========================================: %s", helpInfo.HelpText)
 		helpInfo.ProcessText()
 		// sendInfoChan <- &helpInfo
-		messageChan <- TextMessage{Message: Message{Recipient: mainChat,
+		messageChan <- TextMessage{Message: Message{Recipient: recipient,
 			Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
 				DisableWebPagePreview: true,
 				ReplyTo:               helpInfo.ReplyTo()}},
 			Text: helpInfo.ToText()}
-		//SendImageFromUrl(mainChat, helpInfo.images)
-		//SendCoords(mainChat, helpInfo.coords)
+		//SendImages(game, engine.CurrentLevel, helpInfo.Images)
+		//SendCoords(game.Chat, helpInfo.coords)
 	}
 }
 
-func timeHelpLeft(levelInfo *en.Level) {
+func timeHelpLeft(recipient tb.Recipient, levelInfo *en.Level) {
 	for _, help := range levelInfo.Helps {
 		if help.RemainSeconds > 0 {
 			var msg = fmt.Sprintf(en.HelpTimeLeft, help.Number, en.PrettyTimePrint(help.RemainSeconds, false))
 			// sendInfoChan <- NewBotMessage(msg)
-			messageChan <- TextMessage{Message: Message{Recipient: mainChat,
+			messageChan <- TextMessage{Message: Message{Recipient: recipient,
 				Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
 					DisableWebPagePreview: true}},
 				Text: msg}
@@ -333,190 +381,65 @@ func timeHelpLeft(levelInfo *en.Level) {
 		}
 	}
 	// sendInfoChan <- NewBotMessage("Подсказок на уровне больше нет")
-	messageChan <- NewTextMessage(mainChat, "Подсказок на уровне больше нет", tb.Message{})
+	messageChan <- NewTextMessage(recipient, NoHelpsLeftString, tb.Message{})
 }
 
-func ProcessBotCommand(m tb.Message, en *en.API, bot *tb.Bot) {
-	var (
-		command     string
-		args        string
-		commandCode BotCommand
-		ok          bool
-		//ent         *telebot.MessageEntity
-	)
-
-	command, args = extractCommandAndArguments(m)
-	if commandCode, ok = BotCommandDict[command]; !ok {
-		log.Println("Unknown command:", command)
-	}
-	log.Printf("Command: %s, args: %s", command, args)
-
-	switch commandCode {
-	// case StartCommand:
-	// 	var (
-	// 		kbQuest        = tb.KeyboardButton{Text: "quest.ua", Data: "http://quest.ua"}
-	// 		kbKharkovQuest = tb.KeyboardButton{Text: "kharkov.quest.ua", Data: "http://kharkov.quest.ua"}
-	// 		kbKharkovEn    = tb.KeyboardButton{Text: "kharkov.en.cx", Data: "http://kharkov.en.cx"}
-	// 	)
-	// 	// var keyboard = tb.InlineKeyboardMarkup{}
-	// 	// var reply = tb.ReplyMarkup{InlineKeyboard: }
-	// 	var kbMarkup = [][]tb.KeyboardButton{{kbKharkovQuest, kbKharkovEn}, {kbQuest}}
-	// 	err := bot.SendMessage(m.Chat, "Выберите домен",
-	// 		&tb.SendOptions{ReplyTo: m,
-	// 			ReplyMarkup: tb.ReplyMarkup{
-	// 				Selective:       true,
-	// 				ForceReply:      true,
-	// 				ResizeKeyboard:  true,
-	// 				OneTimeKeyboard: true,
-	// 				InlineKeyboard:  kbMarkup,
-	// 				// CustomKeyboard:  [][]string{[]string{"kharkov.en.cx", "kharkov.quest.ua"}, []string{"quest.ua"}},
-	// 			}})
-	// 	if err != nil {
-	// 		log.Printf("ERROR: %s", err)
-	// 	}
-	// case InfoCommand:
-	// 	//if m.Sender.Username == "kkolesnikov" && m.Chat == nil {
-	// 	//	processLevel(m.Sender, en)
-	// 	//} else {
-	// 	processLevel(m.Chat, en)
-	// 	//log.Printf("After func %p", &en.CurrentLevel.Coords)
-	// 	//}
-	case WatchCommand:
-		startWatching(en)
-	case StopWatchingCommand:
-		stopWatching()
-	case SetChatIDCommand:
-		setChat(m.Chat)
-	case CodeCommand:
-		re := regexp.MustCompile("\\s+")
-		sendCode(en, re.Split(args, -1), m)
-	case CompositeCodeCommand:
-		sendCode(en, []string{args}, m)
-	case SectorsLeftCommand:
-		sectorsLeft(en.CurrentLevel)
-	case TimeLeftCommand:
-		timeLeft(en.CurrentLevel)
-	case ListHelpsCommand:
-		listHelps(en.CurrentLevel)
-	case HelpTimeCommand:
-		timeHelpLeft(en.CurrentLevel)
+func CheckLevelTimeLeft(game *Game, li *en.Level) {
+	if game.timeMachine().Process(li.TimeoutSecondsRemain * time.Second) {
+		timeLeft(game.Chat, li)
+		//log.Printf(TimeLeftString, PrettyTimePrint(li.TimeoutSecondsRemain, true))
 	}
 }
 
-func CheckHelps(oldLevel *en.Level, newLevel *en.Level) {
-	//log.Println("Check helps state")
-	for i := range oldLevel.Helps {
-		if oldLevel.Helps[i].Number == newLevel.Helps[i].Number {
-			if oldLevel.Helps[i].HelpText == "" && newLevel.Helps[i].HelpText != "" {
-				log.Println("New hint is available")
-				newLevel.Helps[i].ProcessText()
-				// sendInfoChan <- &newLevel.Helps[i]
-				messageChan <- TextMessage{Message: Message{Recipient: mainChat,
-					Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
-						DisableWebPagePreview: true,
-						ReplyTo:               newLevel.Helps[i].ReplyTo()}},
-					Text: newLevel.Helps[i].ToText()}
-				SendCoords(mainChat, newLevel.Helps[i].Coords)
-				SendImageFromUrl(mainChat, newLevel.Helps[i].Images)
-			}
-		}
-	}
-	//log.Println("Finish checking changes in Helps section")
+func initChannels() {
+	// sendInfoChan = make(chan en.ToChat, 10)
+	// photoInfoChan = make(chan *PhotoInfo, 10)
+	// coordsInfoChan = make(chan *CoordInfo, 10)
+	messageChan = make(chan MessageSender, 10)
 }
 
-func CheckSectors(oldLevel *en.Level, newLevel *en.Level) {
-	//log.Println("Start checking changes in Sectors section")
-	for i := range oldLevel.Sectors {
-		if oldLevel.Sectors[i].Name == newLevel.Sectors[i].Name {
-			if oldLevel.Sectors[i].IsAnswered != newLevel.Sectors[i].IsAnswered {
-				log.Printf("Sector %q is closed, %d sectors left to close",
-					newLevel.Sectors[i].Name, newLevel.SectorsLeftToClose)
-				// TODO: Replace with constant or parameter from configuration
-				if newLevel.SectorsLeftToClose <= 3 {
-					//sectorChangeChan <- ExtendedSectorInfo{
-					// sendInfoChan <- en.NewExtendedLevelSectors(newLevel)
-					messageChan <- TextMessage{Message: Message{Recipient: mainChat,
-						Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
-							DisableWebPagePreview: true,
-							ReplyTo:               en.NewExtendedLevelSectors(newLevel).ReplyTo()}},
-						Text: en.NewExtendedLevelSectors(newLevel).ToText()}
-				}
-			}
-		}
-	}
-	//log.Println("Finish checking changes in Sectors section")
-}
+// handleLevelUpdates receives level information for the game, either from the watcher or
+// after the code was sent, and notifies the chat about changes on the level
+func handleLevelUpdates(game *Game) {
+	var lastRequest uint64
 
-func CheckBonuses(oldLevel *en.Level, newLevel *en.Level) {
-	for i := range oldLevel.Bonuses {
-		if oldLevel.Bonuses[i].Name == newLevel.Bonuses[i].Name {
-			if oldLevel.Bonuses[i].IsAnswered != newLevel.Bonuses[i].IsAnswered {
-				log.Printf("Bonus %q is available, code %q", newLevel.Bonuses[i].Name,
-					newLevel.Bonuses[i].Answer["Answer"])
-				if newLevel.Bonuses[i].Help != "" {
-					newLevel.Bonuses[i].ProcessText()
-					// sendInfoChan <- &newLevel.Bonuses[i]
-					messageChan <- TextMessage{Message: Message{Recipient: mainChat,
-						Options: &tb.SendOptions{ParseMode: tb.ModeMarkdown,
-							DisableWebPagePreview: true,
-							ReplyTo:               newLevel.Bonuses[i].ReplyTo()}},
-						Text: newLevel.Bonuses[i].ToText()}
-					SendCoords(mainChat, newLevel.Bonuses[i].Coords)
-					SendImageFromUrl(mainChat, newLevel.Bonuses[i].Images)
-				}
+	for {
+		select {
+		case update := <-game.levelInfoChan:
+			if update.request < lastRequest {
+				log.Printf("[DEBUG] Dropping level information for %s that came out of order", game)
+				continue
 			}
+			lastRequest = update.request
+			handleLevelUpdate(game, update.level)
+		case <-game.done:
+			return
 		}
 	}
 }
 
-func CheckLevelTimeLeft(fsm *LevelTimeCheckingMachine, li *en.Level) {
-	//log.Printf("FUNC fsm: %d", fsm.CurrentState().(TimeChecker).compareTime)
-	if fsm.Process(li.TimeoutSecondsRemain * time.Second) {
-		timeLeft(li)
-		//log.Printf(TimeLeftString, PrettyTimePrint(li.TimeoutSecondsRemain, true))
-	}
-}
-
-func CheckMixedActions(oldLevel *en.Level, newLevel *en.Level) {
-	log.Println("Start checking changes in MixedActions section")
-	sort.Sort(newLevel.MixedActions)
-	//fmt.Println(len(newLevel.MixedActions))
-	if len(newLevel.MixedActions) > 0 {
-		if len(oldLevel.MixedActions) == 0 {
-			for _, item := range newLevel.MixedActions {
-				if item.IsCorrect {
-					// sendInfoChan <- item
-					messageChan <- NewTextMessage(mainChat, item.ToText(), item.ReplyTo())
-				}
-			}
-		} else {
-			lastActionID := oldLevel.MixedActions[0].ActionID
-			for _, item := range newLevel.MixedActions {
-				fmt.Println(item.ActionID, item.Answer, lastActionID)
-				if item.ActionID == lastActionID {
-					break
-				}
-				if item.IsCorrect {
-					// sendInfoChan <- item
-					messageChan <- NewTextMessage(mainChat, item.ToText(), item.ReplyTo())
-				}
-			}
+// handleLevelUpdate notifies the chat about changes on the level, panic is recovered
+// here, so that one bad update doesn't stop processing of the next ones
+func handleLevelUpdate(game *Game, li *en.Level) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Println(fmt.Errorf("[handleLevelUpdates] внутренняя ошибка: %v", p))
 		}
-	}
-	//if len(oldLevel.MixedActions) < len(newLevel.MixedActions) {
-	//	for i := len(oldLevel.MixedActions); i < len(newLevel.MixedActions); i++ {
-	//		mixedActionChangeChan <- newLevel.MixedActions[i]
-	//	}
-	//}
-	log.Println("Finish checking changes in MixedActions section")
-}
+	}()
 
-func initChannels() {
-	// sendInfoChan = make(chan en.ToChat, 10)
-	// photoInfoChan = make(chan *PhotoInfo, 10)
-	// coordsInfoChan = make(chan *CoordInfo, 10)
-	levelInfoChan = make(chan *en.Level, 10)
-	messageChan = make(chan MessageSender, 10)
+	events := en.DiffWith(game.CurrentLevel(), li, game.Alerts().checkpoints())
+	// every response of the engine has raw texts, coordinates and images are
+	// extracted from them on each update, so that the current level always has them
+	li.ProcessText()
+	if len(events) > 0 && events[0].Type == en.LevelChanged {
+		log.Printf("New level #%d for chat %d", li.Number, game.Chat.ID)
+		game.timeMachine().ResetState(li.Timeout * time.Second)
+	}
+	renderEvents(game, events)
+	recordEvents(game, events)
+	CheckLevelTimeLeft(game, li)
+	game.setCurrentLevel(li)
+	publishLevel(game, li)
 }
 
 func initChat(bot *tb.Bot, chatID int64) tb.Chat {
@@ -528,36 +451,10 @@ func initChat(bot *tb.Bot, chatID int64) tb.Chat {
 	return chat
 }
 
-func sendLevelInfo(info en.ToChat, channel chan en.ToChat, callback func() string, args ...string) {
-	channel <- info
-
-	if callback != nil {
-		callback()
-	}
-}
-
-func initTimeLevelChecking() *LevelTimeCheckingMachine {
-	var (
-		hourTimeChecker           = TimeChecker{60 * 60 * time.Second}
-		halfHourTimeChecker       = TimeChecker{30 * 60 * time.Second}
-		fifteenMinutesTimeChecker = TimeChecker{15 * 60 * time.Second}
-		fiveMinutesTimeChecker    = TimeChecker{5 * 60 * time.Second}
-		oneMinuteTimeChecker      = TimeChecker{60 * time.Second}
-		zeroTimeChecker           = TimeChecker{-1 * time.Second}
-
-		fsm   LevelTimeCheckingMachine
-		rules = Ruleset{}
-	)
-
-	rules.AddTransition(hourTimeChecker, halfHourTimeChecker)
-	rules.AddTransition(halfHourTimeChecker, fifteenMinutesTimeChecker)
-	rules.AddTransition(fifteenMinutesTimeChecker, fiveMinutesTimeChecker)
-	rules.AddTransition(fiveMinutesTimeChecker, oneMinuteTimeChecker)
-	rules.AddTransition(oneMinuteTimeChecker, zeroTimeChecker)
-
-	fsm = NewLevelTimeCheckingMachine(hourTimeChecker, &rules)
-
-	return &fsm
+// initTimeLevelChecking creates level time checking machine with the state for each
+// threshold, thresholds are in minutes from the largest to the smallest
+func initTimeLevelChecking(minutes []int) *LevelTimeCheckingMachine {
+	return NewLevelTimeCheckingMachine(minutesToDurations(minutes))
 }
 
 func main() {
@@ -567,9 +464,7 @@ func main() {
 		err           error
 		updates       chan tb.Message
 		update        tb.Message
-		engine        en.API
 		commandsStore *CommandStore
-		fsm           *LevelTimeCheckingMachine
 	)
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
@@ -581,85 +476,42 @@ func main() {
 	FailOnError(err, "Can't connect to bot server")
 
 	initChannels()
-	fsm = initTimeLevelChecking()
-
-	go func() {
-		defer func() {
-			if p := recover(); p != nil {
-				log.Println(fmt.Errorf("[main/anonymous] внутренняя ошибка: %v", p))
-			}
-		}()
-
-		for {
-			select {
-			// case ts := <-telegramSenderChan:
-			// mi.Message.Send(ts.Recipient, ts.Message.Body(), ts.Options)
-			// recepient := message.GetRecepient()
-			// options := message.GetOptions()
-			// body := message.GetBody()
-			//
-			// switch si.Type {
-			case message := <-messageChan:
-				message.Send(bot)
-			// case PhotoMessage:
-			// 	bot.SendPhoto(recipient, body, options)
-			// case CoordinatesMessage:
-			// 	bot.SendVenue(recipient, venue, options)
-			// }
-			// case nsi := <-sendInfoChan:
-			// 	log.Print("Send text to Telegram chat")
-			// 	//log.Println(nsi.ToText())
-			// 	//log.Println(mainChat)
-			// 	text := nsi.ToText()
-			// 	//if len(text) > 4096 {
-			// 	//	for
-			// 	//}
-			// 	err := bot.SendMessage(mainChat, text,
-			// 		&tb.SendOptions{ParseMode: tb.ModeMarkdown,
-			// 			DisableWebPagePreview: true,
-			// 			ReplyTo:               nsi.ReplyTo()})
-			// 	if err != nil {
-			// 		log.Println(err)
-			// 	}
-			// case ci := <-coordsInfoChan:
-			// 	log.Print("Send coordinates to chat")
-			// 	bot.SendVenue(ci.Recepient, ci.Location, ci.Options)
-			// case pi := <-photoInfoChan:
-			// 	log.Print("Send images to Telegram chat")
-			// 	bot.SendPhoto(pi.Recepient, pi.Photo, pi.Options)
-			case li := <-levelInfoChan:
-				//log.Println("Receive level from channel")
-				if isNewLevel(engine.CurrentLevel, li) {
-					log.Printf("New level #%d", li.Number)
-					engine.CurrentLevel = li
-					engine.CurrentLevel.ProcessText()
-					fsm.ResetState(li.Timeout * time.Second)
-
-					// sendLevelInfo(engine.CurrentLevel, sendInfoChan, nil)
-					SendImageFromUrl(mainChat, engine.CurrentLevel.Images)
-					SendCoords(mainChat, engine.CurrentLevel.Coords)
-				}
-				go CheckHelps(engine.CurrentLevel, li)
-				//go CheckMixedActions(en.CurrentLevel, li)
-				go CheckBonuses(engine.CurrentLevel, li)
-				go CheckSectors(engine.CurrentLevel, li)
-				go CheckLevelTimeLeft(fsm, li)
-				engine.CurrentLevel = li
-			}
-		}
-	}()
-
-	jar, _ := cookiejar.New(nil)
-	engine = en.API{
-		Username:      envConfig.User,
-		Password:      envConfig.Password,
-		Client:        &http.Client{Jar: jar},
-		CurrentGameID: envConfig.GameID,
-		CurrentLevel:  nil,
-		Domain:        envConfig.EngineDomain,
-		Levels:        list.New()}
-	engine.Login2(envConfig.User, envConfig.Password)
-	// engine.Login()
+	games = NewGameRegistry()
+	settingsMachines = NewSettingsMachines()
+
+	if envConfig.DbName != "" {
+		db := pg.Connect(&pg.Options{
+			Addr:     envConfig.DbAddr,
+			User:     envConfig.DbUser,
+			Password: envConfig.DbPassword,
+			Database: envConfig.DbName,
+		})
+		defer db.Close()
+		settingsRepository = NewPgGameSettingsRepository(db)
+		eventStore = NewPgEventStore(db)
+		tokens, err = NewTokenStore(NewPgTokenRepository(db))
+	} else {
+		log.Print("[WARNING] Database is not configured, game settings are kept in memory")
+		settingsRepository = NewMemoryGameSettingsRepository()
+		eventStore = NewFileEventStore(envConfig.HistoryFile)
+		tokens, err = NewTokenStore(NewMemoryTokenRepository())
+	}
+	FailOnError(err, "Can't load tokens of the API")
+
+	imageService = NewImageService(envConfig.ImagesDir)
+	go imageService.Run(messageChan)
+	streams = NewEventBroker()
+	permissions = NewPermissions(bot, envConfig.Owners)
+	telegramBot := NewTelegramBot(bot, envConfig.BotToken)
+	go NewDispatcher(telegramBot).Run(messageChan)
+
+	defaultSettings = &GameSettings{
+		ChatID:   envConfig.MainChat,
+		Domain:   envConfig.EngineDomain,
+		GameID:   envConfig.GameID,
+		UserName: envConfig.User,
+		Password: envConfig.Password,
+	}
 
 	log.Printf("Authorized on account %s", bot.Identity.Username)
 	updates = make(chan tb.Message, 50)
@@ -669,50 +521,56 @@ func main() {
 	go bot.Start(30 * time.Second)
 	// bot.Listen(updates, 30*time.Second)
 
-	// setChat(initChat(bot, envConfig.MainChat))
-	// engine.CurrentLevel, _ = engine.GetLevelInfo()
-	// if engine.CurrentLevel != nil {
-	// 	fsm.ResetState(engine.CurrentLevel.TimeoutSecondsRemain * time.Second)
-	// 	log.Printf("MAIN fsm: %.0f minute(s)", fsm.CurrentState().(TimeChecker).compareTime.Minutes())
-	// }
-	// startWatching(&engine)
+	restoreGames(bot)
+	if _, err := games.Get(envConfig.MainChat); envConfig.MainChat != 0 && err != nil {
+		setChat(initChat(bot, envConfig.MainChat))
+	}
 
-	go startServer(&engine)
+	go startServer(envConfig, NewAPIServer(games, tokens))
 
 	commandsStore = NewCommandStore()
 	commandsStore.init()
+	go registerCommands(telegramBot, commandsStore)
 
 	for {
 		select {
 		case update = <-bot.Messages:
 			//log.Printf("Read updates from Telegram: %s", update.Text)
 			if update.Text != "" {
-				log.Printf("[INFO] [%s@%s(%d)] %s", update.Sender.Username, update.Chat.Title, update.Chat.ID,
-					update.Text)
 				if IsBotCommand(&update) {
+					log.Printf("[INFO] [%s@%s(%d)] %s", update.Sender.Username, update.Chat.Title, update.Chat.ID,
+						update.Text)
 					commandName, arguments := extractCommandAndArguments(update)
-					commandHandler, err := commandsStore.Get(commandName)
+					// Game can be nil if nothing was configured for the chat yet, commands
+					// should handle this case by themselves
+					game, _ := games.Get(update.Chat.ID)
+					role := permissions.Role(update.Chat, update.Sender, game)
+					commandHandler, err := commandsStore.Get(commandName, role)
 					if err != nil {
 						log.Printf("[WARNING] %s", err)
 					}
-					// TODO: according to the sender/chat need to find corresponding game and get current level for it
-					// TODO: pass Game struct rather than just levelInfo, because there is no levelInfo at the start
-					levelInfo, err := engine.GetLevelInfo()
-					command, err := commandHandler(messageChan, update, levelInfo)
+					command, err := commandHandler(messageChan, update, game)
 					if err != nil {
 						log.Printf("[ERROR] Something bad happened when constructing command handler: %s", err)
 					}
 					go command.Process(arguments)
-					// go ProcessBotCommand(&update, &engine, bot)
+				} else {
+					// Text is not logged here, because it can be a password entered while
+					// configuring the game
+					go settingsMachines.ProcessMessage(update)
 				}
-
 			}
 		// case callback := <-callbacks:
 		case callback := <-bot.Callbacks:
 			log.Printf("CALLBACK: %s %s", callback.Sender.Username, callback.Data)
 			bot.AnswerCallbackQuery(&callback, &tb.CallbackResponse{CallbackID: callback.ID})
-			// TODO: maybe store fsm for separate chat in redis, or in memory. and when
-			//       get update for certain chat retrieve object with correct state
+			switch {
+			case strings.HasPrefix(callback.Data, SettingsCallbackPrefix):
+				go settingsMachines.Process(callback.Message.Chat.ID, callback.Sender.ID,
+					strings.TrimPrefix(callback.Data, SettingsCallbackPrefix))
+			case strings.HasPrefix(callback.Data, PenaltyHelpCallbackPrefix):
+				go processPenaltyHelpCallback(callback.Message, callback.Sender, callback.Data)
+			}
 
 			//bot.SendMessage(update.Chat, fmt.Sprintf("Dear %s, I can't understand you", update.Sender.Username),
 			//	&telebot.SendOptions{ReplyTo: update, ParseMode: telebot.ModeMarkdown})
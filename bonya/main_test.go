@@ -1,7 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	tb "github.com/tucnak/telebot"
@@ -78,3 +82,27 @@ func TestSendMessage(t *testing.T) {
 		t.Errorf("Expected recipient \"%s\", got \"%s\"", message.Recipient.Destination(), sender.recipient.Destination())
 	}
 }
+
+func TestDeleteMessage(t *testing.T) {
+	var request deleteMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottoken/deleteMessage" || json.NewDecoder(r.Body).Decode(&request) != nil {
+			t.Errorf("Unexpected request %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"ok":true,"result":true}`)
+	}))
+	defer server.Close()
+
+	bot := NewTelegramBot(nil, "token")
+	bot.APIURL = server.URL
+	if err := NewDeleteMessage(testRecipient{name: "42"}, 7).Send(bot); err != nil {
+		t.Fatalf("Can't delete message: %s", err)
+	}
+	if request.ChatID != "42" || request.MessageID != 7 {
+		t.Errorf("Unexpected request %v", request)
+	}
+
+	if err := NewDeleteMessage(testRecipient{name: "42"}, 7).Send(&testBotSender{}); err == nil {
+		t.Errorf("Expected error for the bot that can't delete messages")
+	}
+}
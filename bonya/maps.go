@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bonya_bot/en"
+	tb "github.com/tucnak/telebot"
+)
+
+// DefaultMapProvider maps that are used if chat didn't choose any
+const DefaultMapProvider = "google"
+
+// MaxCoordinateButtons how many buttons with points are added to the message
+const MaxCoordinateButtons = 8
+
+// MapProvider renders links to the points and routes on the maps
+type MapProvider struct {
+	// Name is used to choose the provider in /maps command
+	Name string
+	// Title is shown to the user
+	Title string
+
+	point func(coord en.Coordinate) string
+	// route builds the link to the route from the current location through all
+	// points, nil if provider doesn't support routes with several points
+	route func(coords en.Coordinates) string
+}
+
+// PointURL returns the link to the point on the map
+func (mp MapProvider) PointURL(coord en.Coordinate) string {
+	return mp.point(coord)
+}
+
+// RouteURL returns the link to the route through all points. Google Maps are used
+// if the provider doesn't support routes
+func (mp MapProvider) RouteURL(coords en.Coordinates) string {
+	if len(coords) == 0 {
+		return ""
+	}
+	if mp.route == nil {
+		return mapProviders[0].route(coords)
+	}
+	return mp.route(coords)
+}
+
+// latLon formats coordinate as "lat,lon"
+func latLon(coord en.Coordinate) string {
+	return fmt.Sprintf("%.6f,%.6f", coord.Lat, coord.Lon)
+}
+
+// lonLat formats coordinate as "lon,lat", the order used by Yandex
+func lonLat(coord en.Coordinate) string {
+	return fmt.Sprintf("%.6f,%.6f", coord.Lon, coord.Lat)
+}
+
+// mapProviders all supported maps, the first one is used for the routes if the
+// chosen provider doesn't support them
+var mapProviders = []MapProvider{
+	{
+		Name:  "google",
+		Title: "Google Maps",
+		point: func(coord en.Coordinate) string {
+			return "https://www.google.com/maps/search/?api=1&query=" + latLon(coord)
+		},
+		route: func(coords en.Coordinates) string {
+			var (
+				last      = coords[len(coords)-1]
+				waypoints []string
+			)
+			for _, coord := range coords[:len(coords)-1] {
+				waypoints = append(waypoints, latLon(coord))
+			}
+			link := "https://www.google.com/maps/dir/?api=1&destination=" + latLon(last)
+			if len(waypoints) > 0 {
+				link += "&waypoints=" + url.QueryEscape(strings.Join(waypoints, "|"))
+			}
+			return link
+		},
+	},
+	{
+		Name:  "yandex",
+		Title: "Яндекс Карты",
+		point: func(coord en.Coordinate) string {
+			return fmt.Sprintf("https://yandex.ru/maps/?pt=%s&z=17&l=map", lonLat(coord))
+		},
+		route: func(coords en.Coordinates) string {
+			var points []string
+			for _, coord := range coords {
+				points = append(points, latLon(coord))
+			}
+			// route starts from the current location when the first point is empty
+			return "https://yandex.ru/maps/?rtt=auto&rtext=" + url.QueryEscape("~"+strings.Join(points, "~"))
+		},
+	},
+	{
+		Name:  "osm",
+		Title: "OpenStreetMap",
+		point: func(coord en.Coordinate) string {
+			return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.6f&mlon=%.6f#map=17/%.6f/%.6f",
+				coord.Lat, coord.Lon, coord.Lat, coord.Lon)
+		},
+	},
+	{
+		Name:  "waze",
+		Title: "Waze",
+		point: func(coord en.Coordinate) string {
+			return "https://waze.com/ul?navigate=yes&ll=" + latLon(coord)
+		},
+	},
+	{
+		Name:  "apple",
+		Title: "Apple Maps",
+		point: func(coord en.Coordinate) string {
+			return "https://maps.apple.com/?ll=" + latLon(coord) + "&q=" + url.QueryEscape(coord.OriginalString)
+		},
+		route: func(coords en.Coordinates) string {
+			var points []string
+			for _, coord := range coords {
+				points = append(points, latLon(coord))
+			}
+			return "https://maps.apple.com/?daddr=" + strings.Join(points, "+to:")
+		},
+	},
+}
+
+// findMapProvider returns the provider by its name, default provider is returned if
+// name is unknown
+func findMapProvider(name string) (MapProvider, bool) {
+	for _, provider := range mapProviders {
+		if provider.Name == name {
+			return provider, true
+		}
+	}
+	return mapProviders[0], false
+}
+
+// mapProviderNames returns names of all providers
+func mapProviderNames() []string {
+	var names []string
+	for _, provider := range mapProviders {
+		names = append(names, provider.Name)
+	}
+	return names
+}
+
+// coordinatesMessage one message with the links to all points and inline buttons to
+// open them on the map. The last button opens the route through all points of the
+// current level
+func coordinatesMessage(recipient tb.Recipient, provider MapProvider, coords en.Coordinates,
+	route en.Coordinates, replyTo tb.Message) *TextMessage {
+	var (
+		lines    []string
+		buttons  []tb.KeyboardButton
+		keyboard [][]tb.KeyboardButton
+	)
+	for i, coord := range coords {
+		line := fmt.Sprintf(CoordinateString, i+1, coord.Lat, coord.Lon, provider.PointURL(coord))
+		if name := strings.TrimSpace(coord.OriginalString); name != "" {
+			line += " " + escapeMarkdown(name)
+		}
+		lines = append(lines, line+fmt.Sprintf(CoordinateSourceString, coord.Source))
+		if i < MaxCoordinateButtons {
+			buttons = append(buttons, tb.KeyboardButton{Text: fmt.Sprintf("%d", i+1), URL: provider.PointURL(coord)})
+		}
+	}
+	if len(buttons) > 0 {
+		keyboard = append(keyboard, buttons)
+	}
+	if len(route) > 1 {
+		keyboard = append(keyboard, []tb.KeyboardButton{{Text: RouteButtonString, URL: provider.RouteURL(route)}})
+	}
+
+	var text = fmt.Sprintf(CoordinatesString, strings.Join(lines, "\n"))
+	if len(coords) == 0 {
+		text = NoCoordinatesString
+	}
+	message := NewTextMessage(recipient, text, replyTo)
+	message.Options.ReplyMarkup = tb.ReplyMarkup{InlineKeyboard: keyboard}
+	return message
+}
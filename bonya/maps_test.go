@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bonya_bot/en"
+	tb "github.com/tucnak/telebot"
+)
+
+var mapsCoords = en.Coordinates{
+	{Lat: 55.751244, Lon: 37.618423, OriginalString: "Кремль"},
+	{Lat: 59.939095, Lon: 30.315868, Source: en.Source{Kind: en.BonusSource, Number: 2}},
+}
+
+func TestMapProviderURLs(t *testing.T) {
+	for _, example := range []struct {
+		name  string
+		point string
+		route string
+	}{
+		{"google", "https://www.google.com/maps/search/?api=1&query=55.751244,37.618423",
+			"https://www.google.com/maps/dir/?api=1&destination=59.939095,30.315868&waypoints=55.751244%2C37.618423"},
+		{"yandex", "https://yandex.ru/maps/?pt=37.618423,55.751244&z=17&l=map",
+			"https://yandex.ru/maps/?rtt=auto&rtext=~55.751244%2C37.618423~59.939095%2C30.315868"},
+		{"osm", "https://www.openstreetmap.org/?mlat=55.751244&mlon=37.618423#map=17/55.751244/37.618423",
+			"https://www.google.com/maps/dir/?api=1&destination=59.939095,30.315868&waypoints=55.751244%2C37.618423"},
+		{"waze", "https://waze.com/ul?navigate=yes&ll=55.751244,37.618423",
+			"https://www.google.com/maps/dir/?api=1&destination=59.939095,30.315868&waypoints=55.751244%2C37.618423"},
+		{"apple", "https://maps.apple.com/?ll=55.751244,37.618423&q=%D0%9A%D1%80%D0%B5%D0%BC%D0%BB%D1%8C",
+			"https://maps.apple.com/?daddr=55.751244,37.618423+to:59.939095,30.315868"},
+	} {
+		provider, ok := findMapProvider(example.name)
+		if !ok {
+			t.Errorf("%s: provider is not found", example.name)
+			continue
+		}
+		if link := provider.PointURL(mapsCoords[0]); link != example.point {
+			t.Errorf("%s: expected point %q, got %q", example.name, example.point, link)
+		}
+		if link := provider.RouteURL(mapsCoords); link != example.route {
+			t.Errorf("%s: expected route %q, got %q", example.name, example.route, link)
+		}
+	}
+	if provider, ok := findMapProvider("bing"); ok || provider.Name != DefaultMapProvider {
+		t.Errorf("Expected default provider for unknown maps, got %q", provider.Name)
+	}
+}
+
+func TestCoordinatesMessage(t *testing.T) {
+	provider, _ := findMapProvider("yandex")
+	message := coordinatesMessage(&tb.Chat{ID: 1}, provider, mapsCoords[:1], mapsCoords, tb.Message{})
+
+	expected := "*Координаты:*\n1. [55.751244, 37.618423](https://yandex.ru/maps/?pt=37.618423,55.751244&z=17&l=map) Кремль (задание)"
+	if message.Text != expected {
+		t.Errorf("Expected text %q, got %q", expected, message.Text)
+	}
+	keyboard := message.Options.ReplyMarkup.InlineKeyboard
+	if len(keyboard) != 2 || len(keyboard[0]) != 1 || keyboard[0][0].Text != "1" {
+		t.Fatalf("Unexpected keyboard %+v", keyboard)
+	}
+	if route := keyboard[1][0]; route.Text != RouteButtonString || !strings.Contains(route.URL, "rtext=") {
+		t.Errorf("Unexpected route button %+v", route)
+	}
+
+	if message = coordinatesMessage(&tb.Chat{ID: 1}, provider, nil, nil, tb.Message{}); message.Text != NoCoordinatesString {
+		t.Errorf("Expected %q, got %q", NoCoordinatesString, message.Text)
+	}
+}
+
+func TestCoordinatesMessageButtons(t *testing.T) {
+	var coords en.Coordinates
+	for i := 0; i < MaxCoordinateButtons+2; i++ {
+		coords = append(coords, en.Coordinate{Lat: float64(i + 1), Lon: float64(i + 1)})
+	}
+	message := coordinatesMessage(&tb.Chat{ID: 1}, mapProviders[0], coords, coords, tb.Message{})
+	if buttons := message.Options.ReplyMarkup.InlineKeyboard[0]; len(buttons) != MaxCoordinateButtons {
+		t.Errorf("Expected %d buttons, got %d", MaxCoordinateButtons, len(buttons))
+	}
+	if lines := strings.Count(message.Text, "\n"); lines != len(coords) {
+		t.Errorf("Expected %d coordinates in text, got %d", len(coords), lines)
+	}
+}
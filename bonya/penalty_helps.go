@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bonya_bot/en"
+	tb "github.com/tucnak/telebot"
+)
+
+const (
+	// PenaltyHelpCallbackPrefix prefix for the data of the buttons that confirm
+	// the request of the penalty hint, the rest of the data is the id of the hint
+	PenaltyHelpCallbackPrefix = "penalty:"
+
+	penaltyHelpCancel = "no"
+)
+
+// listPenaltyHelps returns the list of the penalty hints with their cost and state
+func listPenaltyHelps(level *en.Level) string {
+	var lines []string
+	for _, help := range level.PenaltyHelps {
+		var (
+			comment string
+			state   = fmt.Sprintf(PenaltyHelpAvailableString, help.Number)
+		)
+		if help.PenaltyComment != "" {
+			comment = fmt.Sprintf(" (%s)", escapeMarkdown(help.PenaltyComment))
+		}
+		switch {
+		case help.HelpText != "":
+			state = PenaltyHelpOpenedString
+		case help.RemainSeconds > 0:
+			state = fmt.Sprintf(PenaltyHelpWaitString, prettyDuration(help.RemainSeconds))
+		}
+		lines = append(lines, fmt.Sprintf(PenaltyHelpString, help.Number, prettyDuration(time.Duration(help.Penalty)),
+			comment, state))
+	}
+	if len(lines) == 0 {
+		return NoPenaltyHelpsString
+	}
+	return fmt.Sprintf(PenaltyHelpsString, strings.Join(lines, "\n"))
+}
+
+// findPenaltyHelp returns the penalty hint with the number or the id on the level
+func findPenaltyHelp(level *en.Level, match func(help en.HelpInfo) bool) *en.HelpInfo {
+	if level == nil {
+		return nil
+	}
+	for i := range level.PenaltyHelps {
+		if match(level.PenaltyHelps[i]) {
+			return &level.PenaltyHelps[i]
+		}
+	}
+	return nil
+}
+
+// confirmPenaltyHelp checks that hint can be requested and asks to confirm the request
+func confirmPenaltyHelp(output chan MessageSender, message tb.Message, level *en.Level, number string) {
+	help := findPenaltyHelp(level, func(help en.HelpInfo) bool {
+		return strconv.Itoa(int(help.Number)) == number
+	})
+	switch {
+	case help == nil:
+		output <- NewTextMessage(message.Chat, fmt.Sprintf(PenaltyHelpNotFoundString, number), message)
+	case help.HelpText != "":
+		output <- NewTextMessage(message.Chat, fmt.Sprintf(PenaltyHelpAlreadyOpenedString, help.Number), message)
+	case help.RemainSeconds > 0:
+		output <- NewTextMessage(message.Chat, fmt.Sprintf(PenaltyHelpNotAvailableString, help.Number,
+			prettyDuration(help.RemainSeconds)), message)
+	default:
+		var (
+			penalty  = prettyDuration(time.Duration(help.Penalty))
+			keyboard = [][]tb.KeyboardButton{{
+				{Text: "-" + penalty, Data: fmt.Sprintf("%s%d", PenaltyHelpCallbackPrefix, help.HelpID)},
+				{Text: "Отмена", Data: PenaltyHelpCallbackPrefix + penaltyHelpCancel}}}
+		)
+		output <- NewTextInlineMessage(message.Chat, fmt.Sprintf(PenaltyHelpConfirmString, help.Number, penalty),
+			keyboard)
+	}
+}
+
+// processPenaltyHelpCallback requests the penalty hint after the request was confirmed,
+// message is the confirmation with the keyboard. Text of the hint is sent to the chat
+// when the level with the opened hint is processed
+func processPenaltyHelpCallback(message tb.Message, sender tb.User, data string) {
+	var (
+		chat   = message.Chat
+		answer = strings.TrimPrefix(data, PenaltyHelpCallbackPrefix)
+	)
+
+	game, err := games.Get(chat.ID)
+	if err != nil {
+		messageChan <- NewTextMessage(chat, NoGameString, tb.Message{})
+		return
+	}
+	// players can ask for the penalty hint, but only captains can confirm it
+	if role := permissions.Role(chat, sender, game); role < CaptainRole {
+		messageChan <- NewTextMessage(chat, fmt.Sprintf(NotAllowedString, CaptainRole.Title(), role.Title()), tb.Message{})
+		return
+	}
+	// confirmation is answered, so the keyboard is removed
+	if message.ID != 0 {
+		messageChan <- NewEditTextMessage(chat, message.ID,
+			fmt.Sprintf(PenaltyHelpAnsweredString, message.Text, senderName(sender)))
+	}
+	if answer == penaltyHelpCancel {
+		messageChan <- NewTextMessage(chat, PenaltyHelpCanceledString, tb.Message{})
+		return
+	}
+
+	help := findPenaltyHelp(game.CurrentLevel(), func(help en.HelpInfo) bool {
+		return strconv.Itoa(help.HelpID) == answer
+	})
+	if help == nil {
+		// level is changed after the confirmation was sent
+		messageChan <- NewTextMessage(chat, fmt.Sprintf(PenaltyHelpNotFoundString, answer), tb.Message{})
+		return
+	}
+	if help.HelpText != "" {
+		messageChan <- NewTextMessage(chat, fmt.Sprintf(PenaltyHelpAlreadyOpenedString, help.Number), tb.Message{})
+		return
+	}
+
+	// level of the game isn't updated until the response is processed, so the same
+	// hint can be confirmed again meanwhile
+	if !game.reservePenaltyHelp(help.HelpID) {
+		messageChan <- NewTextMessage(chat, fmt.Sprintf(PenaltyHelpRequestingString, help.Number), tb.Message{})
+		return
+	}
+
+	log.Printf("[INFO] %s requests penalty hint %d in chat %d", senderName(sender), help.HelpID, chat.ID)
+	request := game.newRequest()
+	level, err := game.Engine.RequestPenaltyHelp(help.HelpID)
+	if err != nil {
+		game.releasePenaltyHelp(help.HelpID)
+		messageChan <- NewTextMessage(chat, fmt.Sprintf(PenaltyHelpFailedString, help.Number, err), tb.Message{})
+		return
+	}
+	// level is modified by the handler of level updates, so hint is copied before
+	var opened *en.HelpInfo
+	if found := findPenaltyHelp(level, func(h en.HelpInfo) bool { return h.HelpID == help.HelpID }); found != nil {
+		copied := *found
+		opened = &copied
+	}
+	game.pushLevel(context.Background(), request, level)
+	if opened == nil {
+		game.releasePenaltyHelp(help.HelpID)
+		messageChan <- NewTextMessage(chat, fmt.Sprintf(PenaltyHelpNotFoundString, answer), tb.Message{})
+		return
+	}
+	if opened.HelpText == "" {
+		game.releasePenaltyHelp(help.HelpID)
+		messageChan <- NewTextMessage(chat, fmt.Sprintf(PenaltyHelpFailedString, help.Number,
+			opened.PenaltyMessage), tb.Message{})
+		return
+	}
+	messageChan <- NewTextMessage(chat, fmt.Sprintf(PenaltyHelpRequestedString,
+		escapeMarkdown(senderName(sender)), help.Number), tb.Message{})
+}
+
+// prettyDuration prints the duration in seconds without trailing space
+func prettyDuration(seconds time.Duration) string {
+	return strings.TrimSpace(en.PrettyTimePrint(seconds, false).String())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bonya_bot/en"
+	tb "github.com/tucnak/telebot"
+)
+
+const (
+	// MinPollInterval interval between requests when deadline of the level, hint or
+	// bonus is close
+	MinPollInterval = 500 * time.Millisecond
+	// DefaultPollInterval interval between requests after the level was changed
+	DefaultPollInterval = time.Second
+	// MaxPollInterval the longest interval between requests when nothing changes
+	MaxPollInterval = 10 * time.Second
+	// deadlineWindow time before the deadline when poller switches to MinPollInterval
+	deadlineWindow = 30 * time.Second
+)
+
+// poll requests level information for the game one request at a time and sends it
+// to the handler of level updates until the context is canceled
+func poll(ctx context.Context, game *Game) {
+	var (
+		interval = DefaultPollInterval
+		timer    = time.NewTimer(0)
+		previous levelState
+	)
+	defer timer.Stop()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Println(fmt.Errorf("[poll] внутренняя ошибка: %v", p))
+			stopPolling(ctx, game)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stop monitoring game %s", game)
+			return
+		case <-timer.C:
+		}
+
+		request := game.newRequest()
+		level, err := game.Engine.GetLevelInfoContext(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Printf("[ERROR] Can't get level information for %s: %s", game, err)
+			if errors.Is(err, en.ErrGameNotStarted) || errors.Is(err, en.ErrGameFinished) {
+				interval = MaxPollInterval
+			}
+		default:
+			state := newLevelState(level)
+			interval = pollInterval(level, state != previous, interval)
+			previous = state
+			game.pushLevel(ctx, request, level)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// stopPolling marks the game as not monitored after the poller stopped because of
+// the error, so that /watch can start it again, and notifies the chat
+func stopPolling(ctx context.Context, game *Game) {
+	game.Lock()
+	// context is canceled if watching was stopped or restarted already
+	if ctx.Err() == nil && game.cancel != nil {
+		game.cancel()
+		game.cancel = nil
+	}
+	game.Unlock()
+	messageChan <- NewTextMessage(game.Chat, PollingStoppedString, tb.Message{})
+}
+
+// pollInterval returns the interval before the next request. Interval grows while
+// nothing changes on the level and is short when some deadline is close, so that
+// notifications are sent in time
+func pollInterval(level *en.Level, changed bool, interval time.Duration) time.Duration {
+	if changed {
+		interval = DefaultPollInterval
+	} else if interval += interval / 2; interval > MaxPollInterval {
+		interval = MaxPollInterval
+	}
+
+	if deadline, ok := nearestDeadline(level); ok {
+		if deadline <= deadlineWindow {
+			return MinPollInterval
+		}
+		// don't sleep through the moment when poller should become faster
+		if deadline-deadlineWindow < interval {
+			interval = deadline - deadlineWindow
+		}
+	}
+	if interval < MinPollInterval {
+		interval = MinPollInterval
+	}
+	return interval
+}
+
+// nearestDeadline returns the time left till the end of the level or till the
+// next hint or bonus, whichever is the first
+func nearestDeadline(level *en.Level) (deadline time.Duration, ok bool) {
+	var update = func(seconds time.Duration) {
+		if seconds > 0 && (!ok || seconds*time.Second < deadline) {
+			deadline, ok = seconds*time.Second, true
+		}
+	}
+
+	if level.Timeout > 0 {
+		update(level.TimeoutSecondsRemain)
+	}
+	for _, help := range level.Helps {
+		update(help.RemainSeconds)
+	}
+	for _, help := range level.PenaltyHelps {
+		update(help.RemainSeconds)
+	}
+	for _, bonus := range level.Bonuses {
+		update(bonus.SecondsToStart)
+		update(bonus.SecondsLeft)
+	}
+	return
+}
+
+// levelState short summary of the level that is used to find out whether something
+// noticeable happened: level is changed, sector is closed, hint is opened, etc.
+// Summary is taken before the level is sent to the handler, since handler modifies
+// texts of the level
+type levelState struct {
+	levelID         int32
+	passedSectors   int16
+	actions         int
+	openedHelps     int
+	answeredBonuses int
+	blocked         bool
+}
+
+func newLevelState(level *en.Level) levelState {
+	var state = levelState{
+		levelID:       level.LevelID,
+		passedSectors: level.PassedSectorsCount,
+		actions:       len(level.MixedActions),
+		blocked:       level.HasAnswerBlockRule && level.BlockDuration > 0,
+	}
+	for _, help := range level.Helps {
+		if help.HelpText != "" {
+			state.openedHelps++
+		}
+	}
+	for _, bonus := range level.Bonuses {
+		if bonus.IsAnswered {
+			state.answeredBonuses++
+		}
+	}
+	return state
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tb "github.com/tucnak/telebot"
+)
+
+// RoleCacheTTL how long the status of the member from Telegram is remembered
+const RoleCacheTTL = 5 * time.Minute
+
+// ErrNotAllowed returned when the role of the user is not enough to run the command
+var ErrNotAllowed = errors.New("command is not allowed")
+
+// Role of the user in the chat, roles are ordered: every role can do everything the
+// lower roles can
+type Role int8
+
+const (
+	// ObserverRole can only read information about the game
+	ObserverRole Role = iota
+	// PlayerRole can send codes, it is the role of all members by default
+	PlayerRole
+	// CaptainRole manages the game in the chat: monitoring, hints, notifications and
+	// roles of the players. Telegram creator and admins of the chat are captains
+	CaptainRole
+	// OwnerRole configures the bot for the chat, it is the owners of the bot from the
+	// config and the users they assigned the role to
+	OwnerRole
+)
+
+var roleNames = map[Role]string{
+	ObserverRole: "observer",
+	PlayerRole:   "player",
+	CaptainRole:  "captain",
+	OwnerRole:    "owner",
+}
+
+var roleTitles = map[Role]string{
+	ObserverRole: "наблюдатель",
+	PlayerRole:   "игрок",
+	CaptainRole:  "капитан",
+	OwnerRole:    "владелец",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Role(%d)", r)
+}
+
+// Title returns the name of the role that is shown to the user
+func (r Role) Title() string {
+	if title, ok := roleTitles[r]; ok {
+		return title
+	}
+	return r.String()
+}
+
+// MarshalText implements encoding.TextMarshaler, roles are stored by their names
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Role) UnmarshalText(text []byte) (err error) {
+	*r, err = ParseRole(string(text))
+	return
+}
+
+// ParseRole returns the role by its name in English or Russian
+func ParseRole(name string) (Role, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for role := ObserverRole; role <= OwnerRole; role++ {
+		if name == roleNames[role] || name == roleTitles[role] {
+			return role, nil
+		}
+	}
+	return ObserverRole, fmt.Errorf("unknown role %q", name)
+}
+
+// MemberRole role that is assigned to the user in the chat with /role command
+type MemberRole struct {
+	// Name of the user when the role was assigned, used in the list of the roles
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+// ChatMemberGetter returns the status of the user in the Telegram chat, it is
+// implemented by tb.Bot
+type ChatMemberGetter interface {
+	GetChatMember(recipient tb.Recipient, user tb.User) (tb.ChatMember, error)
+}
+
+type memberKey struct {
+	chatID int64
+	userID int
+}
+
+type cachedRole struct {
+	role    Role
+	expires time.Time
+}
+
+// Permissions resolves roles of the users in the chats. Roles assigned in the chat
+// take precedence over the roles taken from the Telegram status of the member
+type Permissions struct {
+	*sync.RWMutex
+	members ChatMemberGetter
+	owners  map[int]bool
+	cache   map[memberKey]cachedRole
+}
+
+// NewPermissions constructor for the Permissions, owners are ids of the Telegram users
+// that own the bot in all chats
+func NewPermissions(members ChatMemberGetter, owners []int) *Permissions {
+	var permissions = &Permissions{
+		RWMutex: &sync.RWMutex{},
+		members: members,
+		owners:  make(map[int]bool),
+		cache:   make(map[memberKey]cachedRole),
+	}
+	for _, owner := range owners {
+		permissions.owners[owner] = true
+	}
+	return permissions
+}
+
+// IsOwner returns true if the user is the owner of the bot from the config, only
+// they can use the default settings of the bot that hold the credentials of the operator
+func (p *Permissions) IsOwner(user tb.User) bool {
+	return p.owners[user.ID]
+}
+
+// Role returns the role of the user in the chat, game is nil if it is not configured
+// for the chat
+func (p *Permissions) Role(chat tb.Chat, user tb.User, game *Game) Role {
+	if p.IsOwner(user) {
+		return OwnerRole
+	}
+	if game != nil {
+		if member, ok := game.MemberRole(user.ID); ok {
+			return member.Role
+		}
+	}
+	if chat.Type == tb.Private {
+		return CaptainRole
+	}
+	return p.telegramRole(chat, user)
+}
+
+// telegramRole returns the role from the status of the member in the Telegram chat,
+// status is requested once per RoleCacheTTL
+func (p *Permissions) telegramRole(chat tb.Chat, user tb.User) Role {
+	var key = memberKey{chat.ID, user.ID}
+	p.RLock()
+	cached, ok := p.cache[key]
+	p.RUnlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.role
+	}
+
+	var role = PlayerRole
+	member, err := p.members.GetChatMember(chat, user)
+	if err != nil {
+		log.Printf("[WARNING] Can't get status of %s in chat %d: %s", senderName(user), chat.ID, err)
+		return role
+	}
+	switch member.Status {
+	case tb.Creator, tb.Administrator:
+		role = CaptainRole
+	case tb.Left, tb.Kicked:
+		role = ObserverRole
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.cache[key] = cachedRole{role: role, expires: time.Now().Add(RoleCacheTTL)}
+	return role
+}
+
+// canAssign returns true if the user with the role can change the role of the member
+// from the current one to the new one. Owners can assign any role, others only the
+// roles lower than their own
+func canAssign(role Role, current Role, assigned Role) bool {
+	if role == OwnerRole {
+		return true
+	}
+	return current < role && assigned < role
+}
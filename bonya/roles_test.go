@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	tb "github.com/tucnak/telebot"
+)
+
+// testChatMembers returns statuses of the members of the test chats, all users that
+// are not listed are members
+type testChatMembers struct {
+	statuses map[int]tb.MemberStatus
+	calls    *int
+}
+
+func (m testChatMembers) GetChatMember(recipient tb.Recipient, user tb.User) (tb.ChatMember, error) {
+	if m.calls != nil {
+		*m.calls++
+	}
+	if user.ID < 0 {
+		return tb.ChatMember{}, errors.New("user not found")
+	}
+	status, ok := m.statuses[user.ID]
+	if !ok {
+		status = tb.Member
+	}
+	return tb.ChatMember{User: user, Status: status}, nil
+}
+
+func TestParseRole(t *testing.T) {
+	var tests = []struct {
+		name  string
+		role  Role
+		valid bool
+	}{
+		{"owner", OwnerRole, true},
+		{" Captain ", CaptainRole, true},
+		{"игрок", PlayerRole, true},
+		{"Наблюдатель", ObserverRole, true},
+		{"admin", ObserverRole, false},
+		{"", ObserverRole, false},
+	}
+	for _, test := range tests {
+		role, err := ParseRole(test.name)
+		if role != test.role || (err == nil) != test.valid {
+			t.Errorf("ParseRole(%q) = %s, %v, expected %s", test.name, role, err, test.role)
+		}
+	}
+
+	data, err := json.Marshal(map[int]MemberRole{1: {Name: "@captain", Role: CaptainRole}})
+	if err != nil || string(data) != `{"1":{"name":"@captain","role":"captain"}}` {
+		t.Fatalf("Unexpected JSON %s: %v", data, err)
+	}
+	var roles map[int]MemberRole
+	if err := json.Unmarshal(data, &roles); err != nil || roles[1].Role != CaptainRole {
+		t.Errorf("Unexpected roles %v: %v", roles, err)
+	}
+	if err := json.Unmarshal([]byte(`{"role":"king"}`), &MemberRole{}); err == nil {
+		t.Errorf("Expected error for unknown role")
+	}
+}
+
+func TestPermissionsRole(t *testing.T) {
+	var (
+		calls   int
+		members = testChatMembers{
+			statuses: map[int]tb.MemberStatus{1: tb.Creator, 2: tb.Administrator, 3: tb.Left, 4: tb.Administrator},
+			calls:    &calls,
+		}
+		perms = NewPermissions(members, []int{100})
+		group = tb.Chat{ID: testChatID, Type: tb.SuperGroup}
+		game  = NewGame(group, NewGameSettings())
+	)
+	game.SetMemberRole(4, MemberRole{Name: "demoted", Role: ObserverRole})
+	game.SetMemberRole(100, MemberRole{Name: "owner", Role: ObserverRole})
+
+	var tests = []struct {
+		chat tb.Chat
+		user int
+		game *Game
+		role Role
+	}{
+		// creator of the chat is not the owner of the bot
+		{group, 1, game, CaptainRole},
+		{group, 2, game, CaptainRole},
+		{group, 3, game, ObserverRole},
+		{group, 5, game, PlayerRole},
+		// assigned role is more important than the status in the chat
+		{group, 4, game, ObserverRole},
+		{group, 4, nil, CaptainRole},
+		// owners of the bot can't be demoted
+		{group, 100, game, OwnerRole},
+		{tb.Chat{ID: 5, Type: tb.Private}, 5, nil, CaptainRole},
+		// status is unknown
+		{group, -1, game, PlayerRole},
+	}
+	for _, test := range tests {
+		if role := perms.Role(test.chat, tb.User{ID: test.user}, test.game); role != test.role {
+			t.Errorf("Role of %d in chat %d is %s, expected %s", test.user, test.chat.ID, role, test.role)
+		}
+	}
+
+	calls = 0
+	perms.Role(group, tb.User{ID: 2}, nil)
+	if calls != 0 {
+		t.Errorf("Expected status from the cache, got %d requests", calls)
+	}
+	perms.cache[memberKey{group.ID, 2}] = cachedRole{role: OwnerRole, expires: time.Now().Add(-time.Second)}
+	if role := perms.Role(group, tb.User{ID: 2}, nil); role != CaptainRole || calls != 1 {
+		t.Errorf("Expected expired status to be requested again, got %s after %d requests", role, calls)
+	}
+}
+
+func TestCanAssign(t *testing.T) {
+	var tests = []struct {
+		role, current, assigned Role
+		allowed                 bool
+	}{
+		{OwnerRole, OwnerRole, ObserverRole, true},
+		{OwnerRole, PlayerRole, OwnerRole, true},
+		{CaptainRole, PlayerRole, ObserverRole, true},
+		{CaptainRole, ObserverRole, PlayerRole, true},
+		{CaptainRole, PlayerRole, CaptainRole, false},
+		{CaptainRole, CaptainRole, PlayerRole, false},
+		{PlayerRole, PlayerRole, ObserverRole, false},
+	}
+	for _, test := range tests {
+		if allowed := canAssign(test.role, test.current, test.assigned); allowed != test.allowed {
+			t.Errorf("canAssign(%s, %s, %s) = %v", test.role, test.current, test.assigned, allowed)
+		}
+	}
+}
+
+func TestCommandStoreRoles(t *testing.T) {
+	var (
+		store    = NewCommandStore()
+		messages = collectMessages()
+		message  = tb.Message{Text: "/maps google", Chat: tb.Chat{ID: testChatID}}
+	)
+	defer messages.stop()
+	store.init()
+
+	if _, err := store.Get("maps", CaptainRole); err != nil {
+		t.Errorf("Captain is not allowed to run maps: %s", err)
+	}
+	if _, err := store.Get("с", PlayerRole); err != nil {
+		t.Errorf("Player is not allowed to send codes: %s", err)
+	}
+	if _, err := store.Get("unknown", ObserverRole); err == nil || errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Expected unknown command error, got %v", err)
+	}
+	for key, role := range map[string]Role{"maps": PlayerRole, "с": ObserverRole, "setchat": CaptainRole} {
+		if _, err := store.Get(key, role); !errors.Is(err, ErrNotAllowed) {
+			t.Errorf("Expected %s to be not allowed for %s, got %v", key, role, err)
+		}
+	}
+
+	factory, _ := store.Get("maps", PlayerRole)
+	command, _ := factory(messageChan, message, nil)
+	command.Process("google")
+	messages.waitFor(t, "Недостаточно прав: нужна роль «капитан», ваша роль — «игрок»", time.Second)
+}
+
+func TestRoleCommand(t *testing.T) {
+	var (
+		messages = collectMessages()
+		chat     = tb.Chat{ID: testChatID, Type: tb.SuperGroup}
+		game     = NewGame(chat, NewGameSettings())
+		captain  = tb.User{ID: testCaptainID, Username: "captain"}
+		player   = tb.User{ID: 7, Username: "player"}
+		reply    = func(sender tb.User, target tb.User) tb.Message {
+			return tb.Message{Chat: chat, Sender: sender, ReplyTo: &tb.Message{Sender: target}}
+		}
+	)
+	defer messages.stop()
+
+	command, _ := NewRoleCommand(messageChan, reply(captain, player), game)
+	command.Process("observer")
+	messages.waitFor(t, "@player теперь наблюдатель", time.Second)
+	if member, ok := game.MemberRole(player.ID); !ok || member.Role != ObserverRole || member.Name != "@player" {
+		t.Errorf("Unexpected role %+v", member)
+	}
+	command.Process("captain")
+	messages.waitFor(t, "Вы не можете изменить роль @player", time.Second)
+	command.Process("king")
+	messages.waitFor(t, `Неизвестная роль "king"`, time.Second)
+
+	command, _ = NewRoleCommand(messageChan, reply(player, captain), game)
+	command.Process("observer")
+	if member, ok := game.MemberRole(captain.ID); ok {
+		t.Errorf("Observer changed the role of the captain to %s", member.Role)
+	}
+
+	command, _ = NewRolesCommand(messageChan, tb.Message{Chat: chat, Sender: player}, game)
+	command.Process("")
+	messages.waitFor(t, "*Ваша роль:* наблюдатель", time.Second)
+	messages.waitFor(t, "@player — наблюдатель", time.Second)
+
+	command, _ = NewRoleCommand(messageChan, reply(captain, player), game)
+	command.Process("reset")
+	messages.waitFor(t, "Роль @player сброшена, теперь игрок", time.Second)
+	if _, ok := game.MemberRole(player.ID); ok {
+		t.Errorf("Role is not reset")
+	}
+	command, _ = NewRoleCommand(messageChan, tb.Message{Chat: chat, Sender: captain}, game)
+	command.Process("player")
+	messages.waitFor(t, RoleUsageString, time.Second)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"log"
 
 	tb "github.com/tucnak/telebot"
@@ -31,6 +32,22 @@ type Message struct {
 	Recipient tb.Recipient
 	// Options some options required by messenger
 	Options *tb.SendOptions
+	// Urgent messages are sent before the other messages queued for the chat,
+	// it is used for the results of the entered codes
+	Urgent bool
+}
+
+// chat returns the key of the chat where message is sent, messages for the same
+// chat are sent in the order they were queued
+func (m Message) chat() string {
+	if m.Recipient == nil {
+		return ""
+	}
+	return m.Recipient.Destination()
+}
+
+func (m Message) urgent() bool {
+	return m.Urgent
 }
 
 // TextMessage represents text message type
@@ -42,14 +59,56 @@ type TextMessage struct {
 	Text string
 }
 
-// Send implementation of Sender interface for TextMessage type
+// Send implementation of Sender interface for TextMessage type, long text is
+// sent in several messages
 func (tm TextMessage) Send(bot BotSender) error {
-	log.Print("[INFO] Send message to chat")
-	err := bot.SendMessage(tm.Recipient, tm.Text, tm.Options)
-	if err != nil {
-		log.Printf("ERROR: Cannot send message: %s", err)
+	for _, part := range tm.split() {
+		log.Print("[INFO] Send message to chat")
+		if err := bot.SendMessage(part.Recipient, part.Text, part.Options); err != nil {
+			log.Printf("ERROR: Cannot send message: %s", err)
+			return err
+		}
 	}
-	return err
+	return nil
+}
+
+// split splits the text into messages that fit into MaxMessageLength. Only the
+// first message is a reply and only the last one has the keyboard
+func (tm TextMessage) split() []TextMessage {
+	var (
+		markdown = tm.Options != nil && tm.Options.ParseMode == tb.ModeMarkdown
+		chunks   = splitText(tm.Text, MaxMessageLength, markdown)
+		parts    = make([]TextMessage, len(chunks))
+	)
+	if len(chunks) == 1 {
+		return []TextMessage{tm}
+	}
+	for i, chunk := range chunks {
+		parts[i] = tm
+		parts[i].Text = chunk
+		if tm.Options == nil {
+			continue
+		}
+		options := *tm.Options
+		if i > 0 {
+			options.ReplyTo = tb.Message{}
+		}
+		if i < len(chunks)-1 {
+			options.ReplyMarkup = tb.ReplyMarkup{}
+		}
+		parts[i].Options = &options
+	}
+	return parts
+}
+
+// parts returns messages the text is split into, so that dispatcher can send
+// and retry them one by one
+func (tm TextMessage) parts() []MessageSender {
+	var messages []MessageSender
+	for _, part := range tm.split() {
+		messages = append(messages, part)
+	}
+	return messages
 }
 
 // NewTextMessage constructor for the TextMessage type
@@ -63,6 +122,31 @@ func NewTextMessage(recipient tb.Recipient, message string, replyTo tb.Message)
 	return textMessage
 }
 
+// ReportedMessage text message that reports whether it was sent, e.g. the private
+// message can't be sent if the user didn't start the chat with the bot
+type ReportedMessage struct {
+	TextMessage
+
+	// Result receives nil or the error when the dispatcher is done with the message
+	Result chan error
+}
+
+// NewReportedMessage constructor for the ReportedMessage
+func NewReportedMessage(recipient tb.Recipient, message string, replyTo tb.Message) *ReportedMessage {
+	return &ReportedMessage{TextMessage: *NewTextMessage(recipient, message, replyTo), Result: make(chan error, 1)}
+}
+
+// parts returns the message itself, so that the result is reported once for the
+// whole text
+func (rm ReportedMessage) parts() []MessageSender {
+	return []MessageSender{rm}
+}
+
+// sent is called by the dispatcher with the result of sending
+func (rm ReportedMessage) sent(err error) {
+	rm.Result <- err
+}
+
 // TextInlineMessage the same as TextMessage, but with inline keyboard
 type TextInlineMessage struct {
 	TextMessage
@@ -114,6 +198,40 @@ func NewPhotoMessage(recipient tb.Recipient, photo *tb.Photo, replyTo tb.Message
 	return photoMessage
 }
 
+// MediaGroupSender is implemented by the bots that can send several photos as one
+// media group
+type MediaGroupSender interface {
+	// SendMediaGroup function to send photos as album to recipient (chat, user),
+	// FileID of the photos is set after they are uploaded
+	SendMediaGroup(recipient tb.Recipient, photos []*tb.Photo, options *tb.SendOptions) error
+}
+
+// AlbumMessage represents several photos that are sent as one media group
+type AlbumMessage struct {
+	Message
+
+	Photos []*tb.Photo
+}
+
+// Send implementation of Sender interface for AlbumMessage type. If bot can't send
+// media groups, photos are sent one by one
+func (am AlbumMessage) Send(bot BotSender) error {
+	if sender, ok := bot.(MediaGroupSender); ok && len(am.Photos) > 1 {
+		log.Printf("[INFO] Send %d photos to chat", len(am.Photos))
+		err := sender.SendMediaGroup(am.Recipient, am.Photos, am.Options)
+		if err != nil {
+			log.Printf("WARNING: Cannot send message: %s", err)
+		}
+		return err
+	}
+	for _, photo := range am.Photos {
+		if err := (PhotoMessage{Message: am.Message, Photo: photo}).Send(bot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // LocationMessage represents location message type
 type LocationMessage struct {
 	Message
@@ -142,3 +260,103 @@ func NewLocationMessage(recipient tb.Recipient, venue *tb.Venue, replyTo tb.Mess
 	locationMessage.Location = venue
 	return locationMessage
 }
+
+// MessageEditor is implemented by the bots that can edit sent messages
+type MessageEditor interface {
+	// EditMessageText function to replace text of the message, keyboard is removed
+	// if options don't have one
+	EditMessageText(recipient tb.Recipient, messageID int, text string, options *tb.SendOptions) (*tb.Message, error)
+}
+
+// EditTextMessage replaces text of the message sent by the bot, e.g. to remove the
+// keyboard after it was answered
+type EditTextMessage struct {
+	Message
+
+	// MessageID id of the message to edit
+	MessageID int
+	// Text new text of the message
+	Text string
+}
+
+// Send implementation of Sender interface for EditTextMessage type
+func (em EditTextMessage) Send(bot BotSender) error {
+	editor, ok := bot.(MessageEditor)
+	if !ok {
+		return errors.New("bot can't edit messages")
+	}
+	log.Print("[INFO] Edit message in chat")
+	_, err := editor.EditMessageText(em.Recipient, em.MessageID, em.Text, em.Options)
+	if err != nil {
+		log.Printf("WARNING: Cannot edit message: %s", err)
+	}
+	return err
+}
+
+// NewEditTextMessage constructor for the EditTextMessage type
+func NewEditTextMessage(recipient tb.Recipient, messageID int, text string) *EditTextMessage {
+	editMessage := new(EditTextMessage)
+	editMessage.Recipient = recipient
+	editMessage.MessageID = messageID
+	editMessage.Text = text
+	return editMessage
+}
+
+// MessageDeleter is implemented by the bots that can delete messages in the chat
+type MessageDeleter interface {
+	// DeleteMessage function to delete message with the id from the chat
+	DeleteMessage(recipient tb.Recipient, messageID int) error
+}
+
+// DeleteMessage removes message from the chat, e.g. the password entered while
+// configuring the game
+type DeleteMessage struct {
+	Message
+
+	// MessageID id of the message to delete
+	MessageID int
+}
+
+// Send implementation of Sender interface for DeleteMessage type
+func (dm DeleteMessage) Send(bot BotSender) error {
+	deleter, ok := bot.(MessageDeleter)
+	if !ok {
+		return errors.New("bot can't delete messages")
+	}
+	log.Print("[INFO] Delete message in chat")
+	err := deleter.DeleteMessage(dm.Recipient, dm.MessageID)
+	if err != nil {
+		log.Printf("WARNING: Cannot delete message: %s", err)
+	}
+	return err
+}
+
+// NewDeleteMessage constructor for the DeleteMessage type
+func NewDeleteMessage(recipient tb.Recipient, messageID int) *DeleteMessage {
+	deleteMessage := new(DeleteMessage)
+	deleteMessage.Recipient = recipient
+	deleteMessage.MessageID = messageID
+	return deleteMessage
+}
+
+// Batch related messages that are queued together, e.g. photos or locations of
+// the level. Messages of the batch are sent in the order they were added
+type Batch struct {
+	Messages []MessageSender
+}
+
+// Send implementation of Sender interface for Batch type, sends all messages and
+// returns the first error
+func (b Batch) Send(bot BotSender) (err error) {
+	for _, message := range b.Messages {
+		if e := message.Send(bot); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// NewBatch constructor for the Batch type
+func NewBatch(messages ...MessageSender) *Batch {
+	return &Batch{Messages: messages}
+}
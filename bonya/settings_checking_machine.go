@@ -0,0 +1,457 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bonya_bot/en"
+	"github.com/bonya_bot/fsm"
+	"github.com/tucnak/telebot"
+)
+
+// SettingsCallbackPrefix prefix for the data of inline buttons that are used
+// while configuring the game
+const SettingsCallbackPrefix = "settings:"
+
+const (
+	settingsConfirm = "yes"
+	settingsCancel  = "no"
+)
+
+// DomainChecker state of the settings machine that asks user to choose the domain
+// where game is running
+type DomainChecker struct {
+	Channel  *chan MessageSender
+	Settings *GameSettings
+	Text     string
+}
+
+// getKeyboard returns keyboard with all available buttons.
+// TODO: move all available to DB
+func (gsc DomainChecker) getKeyboard() (keyboard [][]telebot.KeyboardButton) {
+	var domains []string
+	for domain := range QuestDomains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for i, domain := range domains {
+		if i%ButtonsPerRow == 0 {
+			keyboard = append(keyboard, []telebot.KeyboardButton{})
+		}
+		keyboard[len(keyboard)-1] = append(keyboard[len(keyboard)-1],
+			telebot.KeyboardButton{Text: domain, Data: SettingsCallbackPrefix + domain})
+	}
+	return
+}
+
+func (gsc DomainChecker) String() string {
+	return fmt.Sprintf("<DomainChecker %p>", gsc.Settings)
+}
+
+// Prepare sends keyboard with the domains
+func (gsc DomainChecker) Prepare() {
+	*gsc.Channel <- NewTextInlineMessage(telebot.Chat{ID: gsc.Settings.ChatID}, gsc.Text, gsc.getKeyboard())
+}
+
+// hostnameRe host name without port, credentials or path
+var hostnameRe = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+$`)
+
+// allowedDomain returns true if the domain is chosen from QuestDomains or it is the
+// subdomain of the engine, so that credentials are not sent to the arbitrary host
+func allowedDomain(domain string) bool {
+	if _, ok := QuestDomains[domain]; ok {
+		return true
+	}
+	if !hostnameRe.MatchString(domain) || net.ParseIP(domain) != nil {
+		return false
+	}
+	for _, suffix := range EngineDomainSuffixes {
+		if strings.HasSuffix(domain, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Process checks that domain is chosen from the keyboard or entered manually
+func (gsc DomainChecker) Process(args ...interface{}) bool {
+	var domain = strings.TrimSpace(strings.ToLower(args[0].(string)))
+
+	domain = strings.TrimPrefix(strings.TrimPrefix(domain, "http://"), "https://")
+	domain = strings.TrimRight(domain, "/")
+	if !allowedDomain(domain) {
+		*gsc.Channel <- NewTextMessage(telebot.Chat{ID: gsc.Settings.ChatID},
+			fmt.Sprintf(IncorrectDomainString, args[0]), telebot.Message{})
+		return false
+	}
+
+	gsc.Settings.Domain = domain
+	*gsc.Channel <- NewTextMessage(telebot.Chat{ID: gsc.Settings.ChatID},
+		fmt.Sprintf(DomainChosenString, gsc.Settings.Domain), telebot.Message{})
+	return true
+}
+
+// GameChecker helps to check that all the settings are set correctly
+// and if not then promt the user to set them
+type GameChecker struct {
+	Channel  *chan MessageSender
+	Settings *GameSettings
+	Text     string
+}
+
+func (gc GameChecker) String() string {
+	return fmt.Sprintf("<GameChecker %p>", gc.Settings)
+}
+
+// Prepare asks user to enter the id of the game
+func (gc GameChecker) Prepare() {
+	*gc.Channel <- NewTextMessage(telebot.Chat{ID: gc.Settings.ChatID}, gc.Text, telebot.Message{})
+}
+
+// Process accepts either the id of the game, or the link to the game, e.g.
+// http://quest.ua/GameDetails.aspx?gid=58438
+func (gc GameChecker) Process(args ...interface{}) bool {
+	var (
+		input = strings.TrimSpace(args[0].(string))
+		reGid = regexp.MustCompile("gid=(\\d+)")
+	)
+
+	if mr := reGid.FindStringSubmatch(input); len(mr) > 0 {
+		input = mr[1]
+	}
+	gameID, err := strconv.ParseInt(input, 10, 32)
+	if err != nil || gameID <= 0 {
+		*gc.Channel <- NewTextMessage(telebot.Chat{ID: gc.Settings.ChatID},
+			fmt.Sprintf(IncorrectGameString, args[0]), telebot.Message{})
+		return false
+	}
+
+	gc.Settings.GameID = int32(gameID)
+	return true
+}
+
+// LoginChecker state of the settings machine that asks the user name that will be
+// used to login to the engine
+type LoginChecker struct {
+	Channel  *chan MessageSender
+	Settings *GameSettings
+	Text     string
+}
+
+func (lc LoginChecker) String() string {
+	return fmt.Sprintf("<LoginChecker %p>", lc.Settings)
+}
+
+// Prepare asks user to enter the login
+func (lc LoginChecker) Prepare() {
+	*lc.Channel <- NewTextMessage(telebot.Chat{ID: lc.Settings.ChatID}, lc.Text, telebot.Message{})
+}
+
+// Process stores the login
+func (lc LoginChecker) Process(args ...interface{}) bool {
+	var login = strings.TrimSpace(args[0].(string))
+	if login == "" {
+		return false
+	}
+	lc.Settings.UserName = login
+	return true
+}
+
+// PasswordChecker state of the settings machine that asks for the password and
+// tries to login to the engine with entered credentials
+type PasswordChecker struct {
+	Channel  *chan MessageSender
+	Settings *GameSettings
+	Text     string
+
+	// LoginError error of the last attempt to login, nil if login was successful
+	LoginError error
+}
+
+func (pc *PasswordChecker) String() string {
+	return fmt.Sprintf("<PasswordChecker %p>", pc.Settings)
+}
+
+// Prepare asks user to enter the password
+func (pc *PasswordChecker) Prepare() {
+	*pc.Channel <- NewTextMessage(telebot.Chat{ID: pc.Settings.ChatID}, pc.Text, telebot.Message{})
+}
+
+// Process stores the password and checks that it is possible to login to the engine
+// with entered credentials. Message with the password is deleted from the chat
+func (pc *PasswordChecker) Process(args ...interface{}) bool {
+	pc.Settings.Password = args[0].(string)
+	if len(args) > 1 {
+		if message, ok := args[1].(telebot.Message); ok && message.ID != 0 {
+			*pc.Channel <- NewDeleteMessage(message.Chat, message.ID)
+			if message.Chat.Type != telebot.Private {
+				*pc.Channel <- NewTextMessage(message.Chat, PasswordDeletedString, telebot.Message{})
+			}
+		}
+	}
+	engine := en.NewAPI(pc.Settings.Domain, pc.Settings.UserName, pc.Settings.Password, pc.Settings.GameID)
+	pc.LoginError = engine.Login2(pc.Settings.UserName, pc.Settings.Password)
+	if pc.LoginError != nil {
+		*pc.Channel <- NewTextMessage(telebot.Chat{ID: pc.Settings.ChatID},
+			fmt.Sprintf(LoginFailedString, pc.LoginError), telebot.Message{})
+	}
+	return true
+}
+
+// ConfirmChecker state of the settings machine that shows all entered settings and
+// asks user to confirm them
+type ConfirmChecker struct {
+	Channel  *chan MessageSender
+	Settings *GameSettings
+	Text     string
+
+	// Confirmed is true if user confirmed the settings
+	Confirmed bool
+}
+
+func (cc *ConfirmChecker) String() string {
+	return fmt.Sprintf("<ConfirmChecker %p>", cc.Settings)
+}
+
+// Prepare sends entered settings with the keyboard to confirm or cancel them
+func (cc *ConfirmChecker) Prepare() {
+	var keyboard = [][]telebot.KeyboardButton{{
+		{Text: "Сохранить", Data: SettingsCallbackPrefix + settingsConfirm},
+		{Text: "Отмена", Data: SettingsCallbackPrefix + settingsCancel}}}
+	*cc.Channel <- NewTextInlineMessage(telebot.Chat{ID: cc.Settings.ChatID},
+		fmt.Sprintf(cc.Text, cc.Settings.Domain, cc.Settings.GameID, cc.Settings.UserName), keyboard)
+}
+
+// Process accepts only answers from the keyboard
+func (cc *ConfirmChecker) Process(args ...interface{}) bool {
+	switch args[0].(string) {
+	case settingsConfirm:
+		cc.Confirmed = true
+	case settingsCancel:
+		cc.Confirmed = false
+	default:
+		return false
+	}
+	return true
+}
+
+// SettingsSaver final state of the settings machine, creates the game for the chat
+// with entered settings
+type SettingsSaver struct {
+	Channel  *chan MessageSender
+	Settings *GameSettings
+	Text     string
+}
+
+func (ss SettingsSaver) String() string {
+	return fmt.Sprintf("<SettingsSaver %p>", ss.Settings)
+}
+
+// Prepare registers the game and saves the settings
+func (ss SettingsSaver) Prepare() {
+	var (
+		chat = telebot.Chat{ID: ss.Settings.ChatID}
+		game *Game
+	)
+	keepChatSettings(ss.Settings)
+	game = NewGame(chat, ss.Settings)
+	if err := game.Engine.Login2(ss.Settings.UserName, ss.Settings.Password); err != nil {
+		log.Printf("[ERROR] Can't login for chat %d: %s", chat.ID, err)
+	}
+	games.Register(game)
+	saveSettings(game)
+	*ss.Channel <- NewTextMessage(chat, ss.Text, telebot.Message{})
+}
+
+// Process final state doesn't accept any input
+func (ss SettingsSaver) Process(args ...interface{}) bool {
+	return false
+}
+
+// SettingsCanceller final state of the settings machine when user didn't confirm the settings
+type SettingsCanceller struct {
+	Channel  *chan MessageSender
+	Settings *GameSettings
+	Text     string
+}
+
+func (sc SettingsCanceller) String() string {
+	return fmt.Sprintf("<SettingsCanceller %p>", sc.Settings)
+}
+
+// Prepare notifies that settings were not saved
+func (sc SettingsCanceller) Prepare() {
+	*sc.Channel <- NewTextMessage(telebot.Chat{ID: sc.Settings.ChatID}, sc.Text, telebot.Message{})
+}
+
+// Process final state doesn't accept any input
+func (sc SettingsCanceller) Process(args ...interface{}) bool {
+	return false
+}
+
+// Steps of the settings machine
+const (
+	stepDomain   fsm.State = "domain"
+	stepGame     fsm.State = "game"
+	stepLogin    fsm.State = "login"
+	stepPassword fsm.State = "password"
+	stepConfirm  fsm.State = "confirm"
+	stepSaved    fsm.State = "saved"
+	stepCanceled fsm.State = "canceled"
+)
+
+// SettingsStep step of the configuration. Prepare is called when machine enters
+// the step, Process returns true if input of the user is accepted
+type SettingsStep interface {
+	Prepare()
+	Process(args ...interface{}) bool
+}
+
+// GameSettingsCheckingMachine guides user through the configuration of the game for
+// the chat: domain -> game -> login -> password -> confirmation
+type GameSettingsCheckingMachine struct {
+	*fsm.Machine
+	// mutex input is processed one by one, steps can take a while, e.g. login
+	mutex sync.Mutex
+
+	// Settings that are filled step by step
+	Settings *GameSettings
+	// UserID id of the user who started configuration, only his input is accepted
+	UserID int
+
+	steps map[fsm.State]SettingsStep
+}
+
+// NewGameSettingsCheckingMachine creates new instance of GameSettingsCheckingMachine
+// for the chat, machine should be started with ResetState
+func NewGameSettingsCheckingMachine(output *chan MessageSender, chatID int64, userID int) *GameSettingsCheckingMachine {
+	var (
+		settings = &GameSettings{ChatID: chatID}
+		password = &PasswordChecker{Channel: output, Settings: settings, Text: EnterPasswordString}
+		confirm  = &ConfirmChecker{Channel: output, Settings: settings, Text: ConfirmSettingsString}
+		sm       = &GameSettingsCheckingMachine{
+			Machine:  fsm.New(stepDomain),
+			Settings: settings,
+			UserID:   userID,
+			steps: map[fsm.State]SettingsStep{
+				stepDomain:   &DomainChecker{output, settings, ChooseDomainString},
+				stepGame:     &GameChecker{output, settings, EnterGameString},
+				stepLogin:    &LoginChecker{output, settings, EnterLoginString},
+				stepPassword: password,
+				stepConfirm:  confirm,
+				stepSaved:    &SettingsSaver{output, settings, SettingsSavedString},
+				stepCanceled: &SettingsCanceller{output, settings, SettingsCanceledString},
+			},
+		}
+	)
+
+	sm.AddTransition(stepDomain, stepGame, nil)
+	sm.AddTransition(stepGame, stepLogin, nil)
+	sm.AddTransition(stepLogin, stepPassword, nil)
+	sm.AddTransition(stepPassword, stepConfirm, func(input interface{}) bool {
+		return password.LoginError == nil
+	})
+	sm.AddTransition(stepPassword, stepLogin, nil)
+	sm.AddTransition(stepConfirm, stepSaved, func(input interface{}) bool {
+		return confirm.Confirmed
+	})
+	sm.AddTransition(stepConfirm, stepCanceled, nil)
+
+	for state, step := range sm.steps {
+		var step = step
+		sm.OnEnter(state, func(from fsm.State, to fsm.State) {
+			log.Printf("[INFO] Settings for chat %d: %s -> %s", chatID, from, to)
+			step.Prepare()
+		})
+	}
+	return sm
+}
+
+// ResetState starts configuration from the beginning
+func (sm *GameSettingsCheckingMachine) ResetState() {
+	sm.Reset()
+}
+
+// Process passes user input to the current step, if step accepts it then machine
+// moves to the next step. Additional args, e.g. the message with the input, are
+// passed to the step as well. Returns true when configuration is finished
+func (sm *GameSettingsCheckingMachine) Process(input string, args ...interface{}) bool {
+	if sm.steps[sm.Current()].Process(append([]interface{}{input}, args...)...) {
+		sm.Fire(input)
+	}
+	return sm.IsFinal()
+}
+
+// SettingsMachines stores the settings machines for the chats where configuration
+// is in progress
+type SettingsMachines struct {
+	*sync.RWMutex
+	machines map[int64]*GameSettingsCheckingMachine
+}
+
+// NewSettingsMachines creates a new storage and returns a reference to it
+func NewSettingsMachines() *SettingsMachines {
+	return &SettingsMachines{
+		RWMutex:  &sync.RWMutex{},
+		machines: make(map[int64]*GameSettingsCheckingMachine),
+	}
+}
+
+// Start creates new settings machine for the chat and starts it, configuration
+// that was in progress for the chat is dropped
+func (sm SettingsMachines) Start(output *chan MessageSender, chatID int64, userID int) {
+	var fsm = NewGameSettingsCheckingMachine(output, chatID, userID)
+
+	sm.Lock()
+	sm.machines[chatID] = fsm
+	sm.Unlock()
+
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+	fsm.ResetState()
+}
+
+// Process passes input of the user to the settings machine of the chat. Returns false
+// if there is no configuration in progress for the chat or input is from another user
+func (sm SettingsMachines) Process(chatID int64, userID int, input string) bool {
+	return sm.process(chatID, userID, input)
+}
+
+// ProcessMessage the same as Process, but the message is passed to the steps too,
+// so that the message with the password can be deleted from the chat
+func (sm SettingsMachines) ProcessMessage(message telebot.Message) bool {
+	return sm.process(message.Chat.ID, message.Sender.ID, message.Text, message)
+}
+
+// process runs the step without the lock of the storage, so that slow engine of one
+// chat doesn't block configuration in the others
+func (sm SettingsMachines) process(chatID int64, userID int, input string, args ...interface{}) bool {
+	sm.RLock()
+	fsm, exist := sm.machines[chatID]
+	sm.RUnlock()
+	if !exist || fsm.UserID != userID {
+		return false
+	}
+
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+	if fsm.IsFinal() {
+		// configuration was finished while input was waiting
+		return false
+	}
+	if fsm.Process(input, args...) {
+		sm.Lock()
+		if sm.machines[chatID] == fsm {
+			delete(sm.machines, chatID)
+		}
+		sm.Unlock()
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MaxMessageLength the longest text of the message accepted by Telegram, in UTF-16 code units
+const MaxMessageLength = 4096
+
+// longestMarker the longest Markdown marker that can be added to close the entity
+const longestMarker = 3
+
+// markdownText text with the information where it can be split. Markers are the
+// Markdown entities opened before each rune, noBreak is true if text can't be
+// split before the rune
+type markdownText struct {
+	runes   []rune
+	markers []string
+	noBreak []bool
+}
+
+// parseMarkdown finds Markdown entities in the text according to the rules of the
+// Telegram legacy Markdown: *bold*, _italic_, `code`, ```pre``` and [link](url).
+// Entities can't be nested. If markdown is false, text is treated as plain
+func parseMarkdown(text string, markdown bool) *markdownText {
+	var (
+		runes = []rune(text)
+		mt    = &markdownText{
+			runes:   runes,
+			markers: make([]string, len(runes)+1),
+			noBreak: make([]bool, len(runes)+1),
+		}
+		marker string
+	)
+	if !markdown {
+		return mt
+	}
+
+	for i := 0; i < len(runes); i++ {
+		mt.markers[i] = marker
+		switch {
+		case runes[i] == '\\' && marker == "" && i+1 < len(runes) && strings.ContainsRune("_*`[", runes[i+1]):
+			i++
+			mt.markers[i], mt.noBreak[i] = marker, true
+		case marker == "" && hasPrefix(runes[i:], "```"), marker == "```" && hasPrefix(runes[i:], "```"):
+			// pre block is opened or closed, text can't be split inside the marker
+			// and right after the opening or before the closing one
+			if marker == "" {
+				marker = "```"
+				mt.noBreak[i+1], mt.noBreak[i+2], mt.noBreak[i+3] = true, true, true
+			} else {
+				mt.noBreak[i], mt.noBreak[i+1], mt.noBreak[i+2] = true, true, true
+				marker = ""
+			}
+			mt.markers[i+1], mt.markers[i+2] = mt.markers[i], mt.markers[i]
+			i += 2
+		case marker == "" && strings.ContainsRune("*_`", runes[i]):
+			marker = string(runes[i])
+			mt.noBreak[i+1] = true
+		case marker != "" && marker != "```" && string(runes[i]) == marker:
+			mt.noBreak[i] = true
+			marker = ""
+		case marker == "" && runes[i] == '[':
+			end := linkEnd(runes, i)
+			if end < 0 {
+				continue
+			}
+			for j := i + 1; j <= end; j++ {
+				mt.markers[j], mt.noBreak[j] = "", true
+			}
+			i = end
+		}
+	}
+	mt.markers[len(runes)] = marker
+	return mt
+}
+
+// linkEnd returns the index of the closing parenthesis of the link that starts
+// at the index or -1 if there is no link
+func linkEnd(runes []rune, start int) int {
+	var i = start + 1
+	for i < len(runes) && runes[i] != ']' && runes[i] != '\n' {
+		i++
+	}
+	if i+1 >= len(runes) || runes[i] != ']' || runes[i+1] != '(' {
+		return -1
+	}
+	for i += 2; i < len(runes) && runes[i] != ')'; i++ {
+		if unicode.IsSpace(runes[i]) {
+			return -1
+		}
+	}
+	if i == len(runes) {
+		return -1
+	}
+	return i
+}
+
+func hasPrefix(runes []rune, prefix string) bool {
+	var prefixRunes = []rune(prefix)
+	if len(runes) < len(prefixRunes) {
+		return false
+	}
+	return string(runes[:len(prefixRunes)]) == prefix
+}
+
+// utf16Len returns the length of the runes in UTF-16 code units, the way Telegram
+// counts the length of the message
+func utf16Len(runes []rune) (length int) {
+	for _, r := range runes {
+		if r >= 0x10000 {
+			length += 2
+		} else {
+			length++
+		}
+	}
+	return
+}
+
+// breakPriority returns how good is the place before the rune to split the text:
+// paragraphs are better than lines, lines are better than words
+func (mt *markdownText) breakPriority(i int) int {
+	switch {
+	case mt.noBreak[i]:
+		return -1
+	case i >= 2 && mt.runes[i-1] == '\n' && mt.runes[i-2] == '\n':
+		return 3
+	case mt.runes[i-1] == '\n':
+		return 2
+	case unicode.IsSpace(mt.runes[i-1]):
+		return 1
+	}
+	return 0
+}
+
+// nextBreak returns the place to split the text that starts at the index, so that
+// the chunk with the markers fits into size
+func (mt *markdownText) nextBreak(start int, size int) int {
+	var (
+		budget = size - utf16Len([]rune(mt.markers[start])) - longestMarker
+		end    = start
+		length int
+	)
+	for end < len(mt.runes) && length+utf16Len(mt.runes[end:end+1]) <= budget {
+		length += utf16Len(mt.runes[end : end+1])
+		end++
+	}
+	if end == len(mt.runes) {
+		return end
+	}
+	if end == start {
+		// size is too small even for one rune
+		return start + 1
+	}
+
+	// better places are used only if the chunk is not shorter than half of the size
+	for priority := 3; priority >= 0; priority-- {
+		for i := end; i > start; i-- {
+			if mt.breakPriority(i) == priority && (priority == 0 || i-start >= (end-start)/2) {
+				return i
+			}
+		}
+	}
+	// text can't be split without breaking the entity, e.g. link is too long
+	return end
+}
+
+// SplitText splits the text with Markdown into chunks that are not longer than size
+// in UTF-16 code units. Text is split between paragraphs, lines or words if it is
+// possible and never inside the link. Formatting that is open at the end of the
+// chunk is closed and reopened in the next chunk
+func SplitText(text string, size int) []string {
+	return splitText(text, size, true)
+}
+
+// splitText splits the text into chunks, if markdown is false text is treated as plain
+func splitText(text string, size int, markdown bool) (chunks []string) {
+	if utf16Len([]rune(text)) <= size {
+		return []string{text}
+	}
+
+	var mt = parseMarkdown(text, markdown)
+	for start := 0; start < len(mt.runes); {
+		var (
+			end   = mt.nextBreak(start, size)
+			chunk = string(mt.runes[start:end])
+		)
+		if !isCode(mt.markers[end]) {
+			chunk = strings.TrimRightFunc(chunk, unicode.IsSpace)
+		}
+		if chunk != "" {
+			chunks = append(chunks, mt.markers[start]+chunk+mt.markers[end])
+		}
+		// spaces between the chunks are dropped, except the ones inside the code
+		for start = end; start < len(mt.runes) && unicode.IsSpace(mt.runes[start]) && !mt.noBreak[start] &&
+			!isCode(mt.markers[start]); start++ {
+		}
+	}
+	return
+}
+
+// isCode returns true if the marker opens the code, spaces inside the code are kept
+func isCode(marker string) bool {
+	return marker == "`" || marker == "```"
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	tb "github.com/tucnak/telebot"
+)
+
+func TestSplitText(t *testing.T) {
+	for _, example := range []struct {
+		name     string
+		text     string
+		size     int
+		expected []string
+	}{
+		{"short text", "Задание *уровня*", 20, []string{"Задание *уровня*"}},
+		{"cyrillic words", "Первое второе третье", 12, []string{"Первое", "второе", "третье"}},
+		{"emoji counts as two units", "😀😀😀😀😀😀", 9, []string{"😀😀😀", "😀😀😀"}},
+		{"paragraph is preferred", "Один два\n\nтри четыре пять", 20, []string{"Один два", "три четыре пять"}},
+		{"line is preferred", "Один два\nтри четыре", 16, []string{"Один два", "три четыре"}},
+		{"bold is reopened", "*первое второе третье*", 18, []string{"*первое второе*", "*третье*"}},
+		{"link is not split", "см. [ссылка](http://en.cx/x) тут", 30, []string{"см.", "[ссылка](http://en.cx/x)", "тут"}},
+		{"escaped marker", "a\\_b c\\_d e\\_f", 9, []string{"a\\_b", "c\\_d", "e\\_f"}},
+		{"code keeps spaces", "`a b c d e f`", 10, []string{"`a b c `", "`d e f`"}},
+	} {
+		chunks := SplitText(example.text, example.size)
+		if !reflect.DeepEqual(chunks, example.expected) {
+			t.Errorf("%s: expected %q, got %q", example.name, example.expected, chunks)
+		}
+	}
+}
+
+func TestSplitTextPlain(t *testing.T) {
+	expected := []string{"*первое", "второе", "третье*"}
+	if chunks := splitText("*первое второе третье*", 10, false); !reflect.DeepEqual(chunks, expected) {
+		t.Errorf("Expected %q, got %q", expected, chunks)
+	}
+}
+
+func TestSplitTextLength(t *testing.T) {
+	var text = strings.Repeat("Найдите *место*, где [стоит](http://en.cx/) памятник 😀.\n", 200)
+	for _, chunk := range SplitText(text, MaxMessageLength) {
+		if length := utf16Len([]rune(chunk)); length > MaxMessageLength {
+			t.Errorf("Chunk is too long: %d", length)
+		}
+		if strings.Count(chunk, "*")%2 != 0 {
+			t.Errorf("Bold is not closed in chunk %q", chunk)
+		}
+	}
+}
+
+func TestTextMessageSplit(t *testing.T) {
+	var message = NewTextInlineMessage(nil, strings.Repeat("слово ", 1000),
+		[][]tb.KeyboardButton{{{Text: "OK", Data: "ok"}}})
+	message.Options.ReplyTo.ID = 1
+	parts := message.split()
+	if len(parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %d", len(parts))
+	}
+	if parts[0].Options.ReplyTo.ID != 1 || parts[1].Options.ReplyTo.ID != 0 {
+		t.Errorf("Only the first part should be a reply")
+	}
+	if parts[0].Options.ReplyMarkup.InlineKeyboard != nil || parts[1].Options.ReplyMarkup.InlineKeyboard == nil {
+		t.Errorf("Only the last part should have a keyboard")
+	}
+}
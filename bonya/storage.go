@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/go-pg/pg"
+)
+
+// GameSettingsRepository interface to store settings of the games, so that the
+// games can be restored after restart of the bot
+type GameSettingsRepository interface {
+	// Save creates or updates settings for the chat
+	Save(settings *GameSettings) error
+	// Delete removes settings for the chat
+	Delete(chatID int64) error
+	// All returns settings for all chats
+	All() ([]GameSettings, error)
+}
+
+// PgGameSettingsRepository stores game settings in PostgreSQL database, table is
+// created by the migrations
+type PgGameSettingsRepository struct {
+	db *pg.DB
+}
+
+// NewPgGameSettingsRepository constructor for the PgGameSettingsRepository
+func NewPgGameSettingsRepository(db *pg.DB) *PgGameSettingsRepository {
+	return &PgGameSettingsRepository{db: db}
+}
+
+// Save implements GameSettingsRepository interface
+func (r *PgGameSettingsRepository) Save(settings *GameSettings) error {
+	_, err := r.db.Model(settings).
+		OnConflict("(chat_id) DO UPDATE").
+		Set("domain = EXCLUDED.domain").
+		Set("game_id = EXCLUDED.game_id").
+		Set("user_name = EXCLUDED.user_name").
+		Set("password = EXCLUDED.password").
+		Set("watching = EXCLUDED.watching").
+		Set("alerts = EXCLUDED.alerts").
+		Set("map_provider = EXCLUDED.map_provider").
+		Set("roles = EXCLUDED.roles").
+		Insert()
+	return err
+}
+
+// Delete implements GameSettingsRepository interface
+func (r *PgGameSettingsRepository) Delete(chatID int64) error {
+	_, err := r.db.Model(&GameSettings{}).Where("chat_id = ?", chatID).Delete()
+	return err
+}
+
+// All implements GameSettingsRepository interface
+func (r *PgGameSettingsRepository) All() (settings []GameSettings, err error) {
+	err = r.db.Model(&settings).Order("chat_id").Select()
+	return
+}
+
+// MemoryGameSettingsRepository keeps game settings in memory, it is used when
+// database is not configured, so settings are lost after restart
+type MemoryGameSettingsRepository struct {
+	*sync.RWMutex
+	settings map[int64]GameSettings
+}
+
+// NewMemoryGameSettingsRepository constructor for the MemoryGameSettingsRepository
+func NewMemoryGameSettingsRepository() *MemoryGameSettingsRepository {
+	return &MemoryGameSettingsRepository{
+		RWMutex:  &sync.RWMutex{},
+		settings: make(map[int64]GameSettings),
+	}
+}
+
+// Save implements GameSettingsRepository interface
+func (r *MemoryGameSettingsRepository) Save(settings *GameSettings) error {
+	r.Lock()
+	defer r.Unlock()
+	r.settings[settings.ChatID] = *settings
+	return nil
+}
+
+// Delete implements GameSettingsRepository interface
+func (r *MemoryGameSettingsRepository) Delete(chatID int64) error {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.settings, chatID)
+	return nil
+}
+
+// All implements GameSettingsRepository interface
+func (r *MemoryGameSettingsRepository) All() (settings []GameSettings, err error) {
+	r.RLock()
+	defer r.RUnlock()
+	for _, s := range r.settings {
+		settings = append(settings, s)
+	}
+	return
+}
+
+// keepChatSettings copies thresholds of the notifications, maps and roles from the game
+// that is already configured for the chat, so that they are not lost when the game is changed
+func keepChatSettings(settings *GameSettings) {
+	if game, err := games.Get(settings.ChatID); err == nil {
+		alerts := game.Alerts()
+		settings.Alerts = &alerts
+		settings.MapProvider = game.MapProvider().Name
+		settings.Roles = game.MemberRoles()
+	}
+}
+
+// saveSettings stores the current settings of the game in the repository
+func saveSettings(game *Game) {
+	game.RLock()
+	settings := *game.Settings
+	settings.ChatID = game.Chat.ID
+	settings.Watching = game.cancel != nil
+	game.RUnlock()
+
+	if err := settingsRepository.Save(&settings); err != nil {
+		log.Printf("[ERROR] Can't save settings %s: %s", settings, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bonya_bot/en"
+)
+
+const (
+	// StreamBuffer how many events are queued for the subscriber, events are dropped
+	// for the subscribers that are too slow
+	StreamBuffer = 32
+	// StreamHeartbeat how often the comment is sent to keep the connection open
+	StreamHeartbeat = 15 * time.Second
+)
+
+// Names of the events in the stream of the game
+const (
+	// LevelStreamEvent state of the current level, sent on every level update
+	LevelStreamEvent = "level"
+	// HistoryStreamEvent new record in the history: level change, code, hint, sector or bonus
+	HistoryStreamEvent = "history"
+)
+
+// StreamEvent event that is pushed to the subscribers of the game, Data is encoded as json
+type StreamEvent struct {
+	Name string
+	Data interface{}
+}
+
+// WriteTo writes the event in the format of Server-Sent Events
+func (se StreamEvent) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.Marshal(se.Data)
+	if err != nil {
+		return 0, err
+	}
+	n, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", se.Name, data)
+	return int64(n), err
+}
+
+// EventBroker delivers events of the games to the subscribers of their chats
+type EventBroker struct {
+	*sync.RWMutex
+	subscribers map[int64]map[chan StreamEvent]struct{}
+}
+
+// NewEventBroker constructor for the EventBroker
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		RWMutex:     &sync.RWMutex{},
+		subscribers: make(map[int64]map[chan StreamEvent]struct{}),
+	}
+}
+
+// Subscribe returns the channel that receives events of the chat
+func (b *EventBroker) Subscribe(chatID int64) chan StreamEvent {
+	var events = make(chan StreamEvent, StreamBuffer)
+	b.Lock()
+	defer b.Unlock()
+	if b.subscribers[chatID] == nil {
+		b.subscribers[chatID] = make(map[chan StreamEvent]struct{})
+	}
+	b.subscribers[chatID][events] = struct{}{}
+	return events
+}
+
+// Unsubscribe stops sending events to the channel
+func (b *EventBroker) Unsubscribe(chatID int64, events chan StreamEvent) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.subscribers[chatID], events)
+	if len(b.subscribers[chatID]) == 0 {
+		delete(b.subscribers, chatID)
+	}
+}
+
+// Subscribed returns true if somebody listens to the events of the chat
+func (b *EventBroker) Subscribed(chatID int64) bool {
+	b.RLock()
+	defer b.RUnlock()
+	return len(b.subscribers[chatID]) > 0
+}
+
+// Publish sends the event to all subscribers of the chat without blocking, event is
+// dropped for the subscriber whose queue is full
+func (b *EventBroker) Publish(chatID int64, event StreamEvent) {
+	b.RLock()
+	defer b.RUnlock()
+	for events := range b.subscribers[chatID] {
+		select {
+		case events <- event:
+		default:
+			log.Printf("[WARNING] Subscriber of chat %d is too slow, %q event is dropped", chatID, event.Name)
+		}
+	}
+}
+
+// publishLevel sends the state of the level to the subscribers of the game, texts are
+// rendered as HTML for the dashboard
+func publishLevel(game *Game, level *en.Level) {
+	if level == nil || !streams.Subscribed(game.Chat.ID) {
+		return
+	}
+	streams.Publish(game.Chat.ID, StreamEvent{Name: LevelStreamEvent, Data: levelResponse(level, en.HTML)})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tb "github.com/tucnak/telebot"
+)
+
+func TestEventBroker(t *testing.T) {
+	var (
+		broker = NewEventBroker()
+		first  = broker.Subscribe(1)
+		second = broker.Subscribe(1)
+		other  = broker.Subscribe(2)
+	)
+	broker.Publish(1, StreamEvent{Name: HistoryStreamEvent, Data: "code"})
+	for _, events := range []chan StreamEvent{first, second} {
+		if event := <-events; event.Name != HistoryStreamEvent || event.Data != "code" {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	}
+	if len(other) != 0 {
+		t.Errorf("Event of the other chat is received")
+	}
+
+	broker.Unsubscribe(1, first)
+	for i := 0; i < StreamBuffer+1; i++ {
+		broker.Publish(1, StreamEvent{Name: LevelStreamEvent})
+	}
+	if len(first) != 0 || len(second) != StreamBuffer {
+		t.Errorf("Expected %d events for the slow subscriber only, got %d and %d", StreamBuffer, len(first), len(second))
+	}
+	broker.Unsubscribe(1, second)
+	if broker.Subscribed(1) || !broker.Subscribed(2) {
+		t.Errorf("Unexpected subscribers %v", broker.subscribers)
+	}
+}
+
+// readStreamEvent reads the next event from Server-Sent Events stream
+func readStreamEvent(t *testing.T, reader *bufio.Reader) (name string, data string) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Can't read stream: %s", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && name != "":
+			return
+		}
+	}
+}
+
+func TestEventsStreamEndToEnd(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		game     = newTestGame(t, engine)
+		messages = collectMessages()
+		server   = httptest.NewServer(NewAPIServer(games, tokens))
+	)
+	defer engine.Close()
+	defer messages.stop()
+	defer server.Close()
+
+	historyDir, err := ioutil.TempDir("", "bonya")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(historyDir)
+	eventStore = NewFileEventStore(filepath.Join(historyDir, "history.jsonl"))
+
+	startWatching(game)
+	defer stopWatching(game)
+	waitForLevel(t, game)
+
+	token, _ := tokens.Issue(game.Chat.ID, "test")
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(fmt.Sprintf("%s%s/%d/events?token=%s", server.URL, APIPrefix, game.Chat.ID, token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected event stream, got %q", contentType)
+	}
+	reader := bufio.NewReader(response.Body)
+
+	var level LevelResponse
+	name, data := readStreamEvent(t, reader)
+	if err := json.Unmarshal([]byte(data), &level); err != nil || name != LevelStreamEvent || level.Name != "First" {
+		t.Fatalf("Expected state of the level first, got %s %s", name, data)
+	}
+
+	sendCode(game, []string{"code1"}, tb.Message{})
+	var codes, sectors int
+	for codes == 0 || sectors == 0 {
+		name, data = readStreamEvent(t, reader)
+		if name != HistoryStreamEvent {
+			continue
+		}
+		var record HistoryResponse
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			t.Fatalf("Can't decode history record %s: %s", data, err)
+		}
+		switch record.Type {
+		case "CodeEntered":
+			codes++
+		case "SectorClosed":
+			sectors++
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	NewAPIServer(games, tokens).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "EventSource") {
+		t.Errorf("Expected dashboard page, got %d", recorder.Code)
+	}
+	// scripts and styles from CDN must be checked, the page keeps the token
+	if body := recorder.Body.String(); strings.Count(body, "https://unpkg.com/") != strings.Count(body, "integrity=") {
+		t.Errorf("Expected integrity of all resources from CDN to be checked")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	tb "github.com/tucnak/telebot"
+)
+
+// TelegramAPIURL address of the Telegram Bot API
+const TelegramAPIURL = "https://api.telegram.org"
+
+// TelegramBot bot with the methods of Telegram Bot API that are missing in telebot
+type TelegramBot struct {
+	*tb.Bot
+	token  string
+	client *http.Client
+
+	// APIURL address of the Telegram Bot API
+	APIURL string
+}
+
+// NewTelegramBot constructor for the TelegramBot
+func NewTelegramBot(bot *tb.Bot, token string) *TelegramBot {
+	return &TelegramBot{
+		Bot:    bot,
+		token:  token,
+		client: &http.Client{Timeout: time.Minute},
+		APIURL: TelegramAPIURL,
+	}
+}
+
+// inputMediaPhoto photo of the media group in Telegram API
+type inputMediaPhoto struct {
+	Type    string `json:"type"`
+	Media   string `json:"media"`
+	Caption string `json:"caption,omitempty"`
+}
+
+// mediaGroupResponse response of Telegram API to sendMediaGroup request
+type mediaGroupResponse struct {
+	Ok          bool
+	Description string
+	Result      []struct {
+		Photo []struct {
+			FileID string `json:"file_id"`
+		}
+	}
+}
+
+// SendMediaGroup sends photos as one album, local files are uploaded and FileID of
+// the photos is set from the response
+func (b *TelegramBot) SendMediaGroup(recipient tb.Recipient, photos []*tb.Photo, options *tb.SendOptions) error {
+	var (
+		body   = &bytes.Buffer{}
+		writer = multipart.NewWriter(body)
+		media  = make([]inputMediaPhoto, len(photos))
+	)
+	for i, photo := range photos {
+		media[i] = inputMediaPhoto{Type: "photo", Media: photo.FileID, Caption: photo.Caption}
+		if photo.Exists() {
+			continue
+		}
+		name := "photo" + strconv.Itoa(i)
+		media[i].Media = "attach://" + name
+		if err := attachFile(writer, name, photo.Local()); err != nil {
+			return err
+		}
+	}
+	encoded, err := json.Marshal(media)
+	if err != nil {
+		return err
+	}
+	writer.WriteField("chat_id", recipient.Destination())
+	writer.WriteField("media", string(encoded))
+	if options != nil {
+		if options.ReplyTo.ID != 0 {
+			writer.WriteField("reply_to_message_id", strconv.Itoa(options.ReplyTo.ID))
+		}
+		if options.DisableNotification {
+			writer.WriteField("disable_notification", "true")
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/bot%s/sendMediaGroup", b.APIURL, b.token)
+	response, err := b.client.Post(url, writer.FormDataContentType(), body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var result mediaGroupResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Ok {
+		// the same format as telebot errors, so that rate limits are detected
+		return fmt.Errorf("telebot: %s", result.Description)
+	}
+	for i, message := range result.Result {
+		if i < len(photos) && len(message.Photo) > 0 {
+			photos[i].FileID = message.Photo[len(message.Photo)-1].FileID
+		}
+	}
+	return nil
+}
+
+// Scopes of the commands in Telegram, users see the commands of the most specific scope
+// that applies to them
+const (
+	DefaultCommandScope      = "default"
+	PrivateChatsCommandScope = "all_private_chats"
+	AdminsCommandScope       = "all_chat_administrators"
+)
+
+// BotCommand command in the menu of the bot in Telegram
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// commandScope scope of the commands in Telegram API
+type commandScope struct {
+	Type string `json:"type"`
+}
+
+// setMyCommandsRequest request of Telegram API to set the commands of the bot
+type setMyCommandsRequest struct {
+	Commands []BotCommand `json:"commands"`
+	Scope    commandScope `json:"scope"`
+}
+
+// SetMyCommands replaces the list of the commands of the bot that is shown to the
+// users in the scope
+func (b *TelegramBot) SetMyCommands(commands []BotCommand, scope string) error {
+	encoded, err := json.Marshal(setMyCommandsRequest{Commands: commands, Scope: commandScope{Type: scope}})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/bot%s/setMyCommands", b.APIURL, b.token)
+	response, err := b.client.Post(url, "application/json", bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var result struct {
+		Ok          bool
+		Description string
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Ok {
+		return fmt.Errorf("telebot: %s", result.Description)
+	}
+	return nil
+}
+
+// deleteMessageRequest request of Telegram API to delete the message
+type deleteMessageRequest struct {
+	ChatID    string `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+}
+
+// DeleteMessage deletes the message in the chat, bot should be an administrator
+// of the group to delete messages of the other users
+func (b *TelegramBot) DeleteMessage(recipient tb.Recipient, messageID int) error {
+	encoded, err := json.Marshal(deleteMessageRequest{ChatID: recipient.Destination(), MessageID: messageID})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/bot%s/deleteMessage", b.APIURL, b.token)
+	response, err := b.client.Post(url, "application/json", bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var result struct {
+		Ok          bool
+		Description string
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Ok {
+		return fmt.Errorf("telebot: %s", result.Description)
+	}
+	return nil
+}
+
+func attachFile(writer *multipart.Writer, name string, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	part, err := writer.CreateFormFile(name, filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
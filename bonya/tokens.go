@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg"
+)
+
+const (
+	// TokenSize number of random bytes in the token of the API
+	TokenSize = 32
+	// TokenSendTimeout how long to wait until the token is sent to the private chat
+	TokenSendTimeout = time.Minute
+)
+
+// APIToken token that gives access to the API for the game of the chat. Only the hash
+// of the token is stored, the token itself is shown once when it is issued
+type APIToken struct {
+	tableName struct{} `sql:"api_tokens"`
+
+	Hash   string `sql:",pk"`
+	ChatID int64
+	// Author telegram user who issued the token
+	Author    string
+	CreatedAt time.Time
+}
+
+// TokenRepository interface to store tokens of the API, so that they stay valid
+// after restart of the bot
+type TokenRepository interface {
+	// Save adds the token
+	Save(token *APIToken) error
+	// Delete removes all tokens of the chat, returns the number of removed tokens
+	Delete(chatID int64) (int, error)
+	// All returns tokens of all chats
+	All() ([]APIToken, error)
+}
+
+// PgTokenRepository stores tokens in PostgreSQL database, table is created by the migrations
+type PgTokenRepository struct {
+	db *pg.DB
+}
+
+// NewPgTokenRepository constructor for the PgTokenRepository
+func NewPgTokenRepository(db *pg.DB) *PgTokenRepository {
+	return &PgTokenRepository{db: db}
+}
+
+// Save implements TokenRepository interface
+func (r *PgTokenRepository) Save(token *APIToken) error {
+	return r.db.Insert(token)
+}
+
+// Delete implements TokenRepository interface
+func (r *PgTokenRepository) Delete(chatID int64) (int, error) {
+	result, err := r.db.Model(&APIToken{}).Where("chat_id = ?", chatID).Delete()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// All implements TokenRepository interface
+func (r *PgTokenRepository) All() (tokens []APIToken, err error) {
+	err = r.db.Model(&tokens).Select()
+	return
+}
+
+// MemoryTokenRepository keeps tokens in memory, it is used when database is not
+// configured, so tokens are revoked after restart
+type MemoryTokenRepository struct {
+	*sync.RWMutex
+	tokens map[string]APIToken
+}
+
+// NewMemoryTokenRepository constructor for the MemoryTokenRepository
+func NewMemoryTokenRepository() *MemoryTokenRepository {
+	return &MemoryTokenRepository{
+		RWMutex: &sync.RWMutex{},
+		tokens:  make(map[string]APIToken),
+	}
+}
+
+// Save implements TokenRepository interface
+func (r *MemoryTokenRepository) Save(token *APIToken) error {
+	r.Lock()
+	defer r.Unlock()
+	r.tokens[token.Hash] = *token
+	return nil
+}
+
+// Delete implements TokenRepository interface
+func (r *MemoryTokenRepository) Delete(chatID int64) (deleted int, err error) {
+	r.Lock()
+	defer r.Unlock()
+	for hash, token := range r.tokens {
+		if token.ChatID == chatID {
+			delete(r.tokens, hash)
+			deleted++
+		}
+	}
+	return
+}
+
+// All implements TokenRepository interface
+func (r *MemoryTokenRepository) All() (tokens []APIToken, err error) {
+	r.RLock()
+	defer r.RUnlock()
+	for _, token := range r.tokens {
+		tokens = append(tokens, token)
+	}
+	return
+}
+
+// TokenStore issues, checks and revokes tokens of the API. Tokens are checked on every
+// request, so chats of the tokens are cached in memory
+type TokenStore struct {
+	*sync.RWMutex
+	repository TokenRepository
+	// chats chat of the token for the hash of the token
+	chats map[string]int64
+}
+
+// NewTokenStore constructor for the TokenStore, tokens are loaded from the repository
+func NewTokenStore(repository TokenRepository) (*TokenStore, error) {
+	var store = &TokenStore{
+		RWMutex:    &sync.RWMutex{},
+		repository: repository,
+		chats:      make(map[string]int64),
+	}
+	tokens, err := repository.All()
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		store.chats[token.Hash] = token.ChatID
+	}
+	return store, nil
+}
+
+// hashToken returns the hash of the token that is stored instead of the token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue creates new token for the chat
+func (s *TokenStore) Issue(chatID int64, author string) (string, error) {
+	var random = make([]byte, TokenSize)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
+	record := &APIToken{Hash: hashToken(token), ChatID: chatID, Author: author, CreatedAt: time.Now()}
+	if err := s.repository.Save(record); err != nil {
+		return "", err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.chats[record.Hash] = chatID
+	return token, nil
+}
+
+// Revoke removes all tokens of the chat, returns the number of revoked tokens
+func (s *TokenStore) Revoke(chatID int64) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	for hash, chat := range s.chats {
+		if chat == chatID {
+			delete(s.chats, hash)
+		}
+	}
+	return s.repository.Delete(chatID)
+}
+
+// Chat returns the chat of the token, false is returned if token is unknown or revoked
+func (s *TokenStore) Chat(token string) (int64, bool) {
+	if token == "" {
+		return 0, false
+	}
+	s.RLock()
+	defer s.RUnlock()
+	chatID, ok := s.chats[hashToken(token)]
+	return chatID, ok
+}
@@ -1,59 +1,559 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bonya_bot/en"
 )
 
+const (
+	// APIPrefix path of the games in the current version of the API
+	APIPrefix = "/api/v1/games"
+	// APICodeAuthor author of the codes that are sent through the API without author
+	APICodeAuthor = "API"
+	// maxRequestSize the largest body of the request that is accepted
+	maxRequestSize = 1 << 16
+)
+
+// taskFormats formats of the texts that can be requested with `format` query parameter
+var taskFormats = map[string]en.Format{
+	"plain":      en.Plain,
+	"markdown":   en.Markdown,
+	"markdownv2": en.MarkdownV2,
+	"html":       en.HTML,
+}
+
 // CoordinatesResponse represent the response that is sent to the user
 type CoordinatesResponse struct {
 	LevelNumber int8           `json:"level"`
 	Coords      en.Coordinates `json:"coordinates"`
 }
 
-func getCoordinates(w http.ResponseWriter, r *http.Request, engine *en.API) {
-	var (
-		response = CoordinatesResponse{}
-		buf      bytes.Buffer
-	)
+// ErrorResponse is sent with all responses that have error status
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
 
-	log.Print("Get coordinates request accepted")
+// GameResponse short information about the game configured for the chat
+type GameResponse struct {
+	ChatID   int64  `json:"chat"`
+	Domain   string `json:"domain"`
+	GameID   int32  `json:"game"`
+	Watching bool   `json:"watching"`
+	// Level number of the current level, 0 if level information wasn't received yet
+	Level int8 `json:"level"`
+}
 
-	if engine.CurrentLevel != nil {
-		response.LevelNumber = engine.CurrentLevel.Number
-		//log.Printf("%p", &en.CurrentLevel.Coords)
-		_, response.Coords = en.ExtractCoordinates(engine.CurrentLevel.Tasks[0].TaskText)
-		for _, hi := range engine.CurrentLevel.Helps {
-			if hi.HelpText != "" {
-				_, coords := en.ExtractCoordinates(hi.HelpText)
-				response.Coords = append(response.Coords, coords...)
-			}
-		}
+// BlockResponse rule that blocks codes on the level, times are in seconds
+type BlockResponse struct {
+	Target   string `json:"target"`
+	Attempts int8   `json:"attempts"`
+	Period   int64  `json:"period"`
+	Remain   int64  `json:"remain"`
+}
+
+// HelpResponse hint of the level, text is empty until the hint is opened
+type HelpResponse struct {
+	Number  int8   `json:"number"`
+	Text    string `json:"text"`
+	Remain  int64  `json:"remain"`
+	Penalty int16  `json:"penalty,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// BonusResponse bonus of the level, help is empty until the bonus is answered
+type BonusResponse struct {
+	Number   int16  `json:"number"`
+	Name     string `json:"name"`
+	Task     string `json:"task"`
+	Help     string `json:"help"`
+	Answered bool   `json:"answered"`
+	Expired  bool   `json:"expired"`
+	Answer   string `json:"answer,omitempty"`
+	Award    int64  `json:"award,omitempty"`
+	Starts   int64  `json:"starts,omitempty"`
+	Remain   int64  `json:"remain,omitempty"`
+}
+
+// SectorResponse sector of the level, answer is empty until the sector is closed
+type SectorResponse struct {
+	Order    int16  `json:"order"`
+	Name     string `json:"name"`
+	Answered bool   `json:"answered"`
+	Answer   string `json:"answer,omitempty"`
+	Author   string `json:"author,omitempty"`
+}
+
+// LevelResponse state of the current level, times are in seconds and texts are
+// rendered in the format from `format` query parameter
+type LevelResponse struct {
+	Number          int8             `json:"number"`
+	Levels          int              `json:"levels,omitempty"`
+	Name            string           `json:"name"`
+	Timeout         int64            `json:"timeout"`
+	TimeoutRemain   int64            `json:"timeout_remain"`
+	TimeoutPenalty  int64            `json:"timeout_penalty"`
+	Passed          bool             `json:"passed"`
+	Dismissed       bool             `json:"dismissed"`
+	Block           *BlockResponse   `json:"block,omitempty"`
+	SectorsRequired int16            `json:"sectors_required"`
+	SectorsPassed   int16            `json:"sectors_passed"`
+	SectorsLeft     int16            `json:"sectors_left"`
+	Task            string           `json:"task"`
+	Coords          en.Coordinates   `json:"coordinates"`
+	Helps           []HelpResponse   `json:"helps"`
+	PenaltyHelps    []HelpResponse   `json:"penalty_helps"`
+	Bonuses         []BonusResponse  `json:"bonuses"`
+	Sectors         []SectorResponse `json:"sectors"`
+}
+
+// HistoryResponse one record in the history of the level
+type HistoryResponse struct {
+	Level  int8      `json:"level"`
+	Type   string    `json:"type"`
+	Text   string    `json:"text"`
+	Author string    `json:"author,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// CodeRequest body of the request to send the code
+type CodeRequest struct {
+	Code   string `json:"code"`
+	Author string `json:"author"`
+}
+
+// CodeResponse result of the sent code
+type CodeResponse struct {
+	Code    string `json:"code"`
+	Level   int8   `json:"level"`
+	Correct bool   `json:"correct"`
+}
+
+// gameHandler handles requests to the resource of the game
+type gameHandler func(w http.ResponseWriter, r *http.Request, game *Game)
+
+// route handler of the resource of the game and http method it accepts
+type route struct {
+	method  string
+	handler gameHandler
+}
+
+// APIServer serves the state of all configured games over HTTP. Resources of the game
+// are available at APIPrefix/<chat>/<resource>, every request should have the token
+// issued for the chat in `Authorization: Bearer` header or `token` query parameter
+type APIServer struct {
+	games  *GameRegistry
+	tokens *TokenStore
+	mux    *http.ServeMux
+	routes map[string]route
+}
+
+// NewAPIServer constructor for the APIServer
+func NewAPIServer(games *GameRegistry, tokens *TokenStore) *APIServer {
+	var s = &APIServer{games: games, tokens: tokens, mux: http.NewServeMux()}
+	s.routes = map[string]route{
+		"":        {http.MethodGet, s.getGame},
+		"level":   {http.MethodGet, s.getLevel},
+		"coords":  {http.MethodGet, s.getCoordinates},
+		"history": {http.MethodGet, s.getHistory},
+		"codes":   {http.MethodPost, s.postCode},
+		"events":  {http.MethodGet, s.getEvents},
 	}
+	s.mux.HandleFunc(APIPrefix, s.serveGames)
+	s.mux.HandleFunc(APIPrefix+"/", s.serveGames)
+	s.mux.HandleFunc("/coords", s.legacyCoordinates)
+	s.mux.HandleFunc("/dashboard", serveDashboard)
+	return s
+}
+
+// ServeHTTP implements http.Handler interface
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
 
-	if err := json.NewEncoder(&buf).Encode(response); err != nil {
-		http.Error(w, "Something bad happened", http.StatusInternalServerError)
+// writeJSON sends the value as json response with the status
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("[ERROR] Can't encode response: %s", err)
+		status, body = http.StatusInternalServerError, []byte(`{"error":"can't encode response"}`)
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(buf.Bytes())
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
 }
 
-func makeHandler(fn func(http.ResponseWriter, *http.Request, *en.API), en *en.API) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		fn(w, r, en)
+// writeError sends the error as json response with the status
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message})
+}
+
+// authenticate returns the chat of the token from the request, unauthorized error
+// is sent if token is missing or revoked
+func (s *APIServer) authenticate(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	var token = r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
 	}
+	chatID, ok := s.tokens.Chat(token)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "valid token is required")
+	}
+	return chatID, ok
 }
 
-func initHandlers(en *en.API) {
-	log.Print("Adding enpoint handlers...")
-	http.HandleFunc("/coords", makeHandler(getCoordinates, en))
+// serveGames finds the game from the path and passes request to the handler of the resource
+func (s *APIServer) serveGames(w http.ResponseWriter, r *http.Request) {
+	var parts = strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/"), "/")
+	tokenChat, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if parts[0] == "" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "method is not allowed")
+			return
+		}
+		s.listGames(w, r, tokenChat)
+		return
+	}
+
+	var resource string
+	if len(parts) > 1 {
+		resource = strings.Join(parts[1:], "/")
+	}
+	route, ok := s.routes[resource]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown resource %q", resource))
+		return
+	}
+	if r.Method != route.method {
+		w.Header().Set("Allow", route.method)
+		writeError(w, http.StatusMethodNotAllowed, "method is not allowed")
+		return
+	}
+	chatID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("incorrect chat %q", parts[0]))
+		return
+	}
+	if chatID != tokenChat {
+		writeError(w, http.StatusForbidden, "token is issued for another chat")
+		return
+	}
+	game, err := s.games.Get(chatID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	route.handler(w, r, game)
+}
+
+// gameResponse short information about the game
+func gameResponse(game *Game) GameResponse {
+	var response = GameResponse{
+		ChatID:   game.Chat.ID,
+		Domain:   game.Settings.Domain,
+		GameID:   game.Settings.GameID,
+		Watching: game.IsWatching(),
+	}
+	if level := game.CurrentLevel(); level != nil {
+		response.Level = level.Number
+	}
+	return response
+}
+
+// listGames sends the games that are available with the token, that is the game of
+// the chat the token is issued for
+func (s *APIServer) listGames(w http.ResponseWriter, r *http.Request, chatID int64) {
+	var response = []GameResponse{}
+	if game, err := s.games.Get(chatID); err == nil {
+		response = append(response, gameResponse(game))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *APIServer) getGame(w http.ResponseWriter, r *http.Request, game *Game) {
+	writeJSON(w, http.StatusOK, gameResponse(game))
 }
 
-func startServer(en *en.API) {
-	initHandlers(en)
-	log.Fatal(http.ListenAndServe(":8081", nil))
+// textFormat returns the format of the texts from `format` query parameter, HTML is
+// used by default
+func textFormat(r *http.Request) (en.Format, error) {
+	var name = strings.ToLower(r.URL.Query().Get("format"))
+	if name == "" {
+		return en.HTML, nil
+	}
+	if format, ok := taskFormats[name]; ok {
+		return format, nil
+	}
+	return en.Plain, fmt.Errorf("unknown format %q", name)
+}
+
+// levelResponse builds the state of the level, texts of the engine are rendered in
+// the format
+func levelResponse(level *en.Level, format en.Format) LevelResponse {
+	var response = LevelResponse{
+		Number:          level.Number,
+		Name:            level.Name,
+		Timeout:         int64(level.Timeout),
+		TimeoutRemain:   int64(level.TimeoutSecondsRemain),
+		TimeoutPenalty:  int64(level.TimeoutAward),
+		Passed:          level.IsPassed,
+		Dismissed:       level.Dismissed,
+		SectorsRequired: level.RequiredSectorsCount,
+		SectorsPassed:   level.PassedSectorsCount,
+		SectorsLeft:     level.SectorsLeftToClose,
+		Coords:          level.AllCoords(),
+		Helps:           []HelpResponse{},
+		PenaltyHelps:    []HelpResponse{},
+		Bonuses:         []BonusResponse{},
+		Sectors:         []SectorResponse{},
+	}
+	if level.Parent != nil && level.Parent.Levels != nil {
+		response.Levels = len(*level.Parent.Levels)
+	}
+	if level.HasAnswerBlockRule {
+		response.Block = &BlockResponse{
+			Target:   en.BlockTypeToString(level.BlockTargetID),
+			Attempts: level.AttemtsNumber,
+			Period:   int64(level.AttemtsPeriod),
+			Remain:   int64(level.BlockDuration),
+		}
+	}
+	if len(level.Tasks) > 0 {
+		response.Task = en.Render(level.Tasks[0].TaskText, format)
+	}
+	for _, help := range level.Helps {
+		response.Helps = append(response.Helps, HelpResponse{
+			Number: help.Number,
+			Text:   en.Render(help.HelpText, format),
+			Remain: int64(help.RemainSeconds),
+		})
+	}
+	for _, help := range level.PenaltyHelps {
+		response.PenaltyHelps = append(response.PenaltyHelps, HelpResponse{
+			Number:  help.Number,
+			Text:    en.Render(help.HelpText, format),
+			Remain:  int64(help.RemainSeconds),
+			Penalty: help.Penalty,
+			Comment: en.Render(help.PenaltyComment, format),
+		})
+	}
+	for _, bonus := range level.Bonuses {
+		response.Bonuses = append(response.Bonuses, BonusResponse{
+			Number:   bonus.Number,
+			Name:     bonus.Name,
+			Task:     en.Render(bonus.Task, format),
+			Help:     en.Render(bonus.Help, format),
+			Answered: bonus.IsAnswered,
+			Expired:  bonus.Expired,
+			Answer:   answerOf(bonus.Answer),
+			Award:    int64(bonus.AwardTime),
+			Starts:   int64(bonus.SecondsToStart),
+			Remain:   int64(bonus.SecondsLeft),
+		})
+	}
+	for _, sector := range level.Sectors {
+		response.Sectors = append(response.Sectors, SectorResponse{
+			Order:    sector.Order,
+			Name:     sector.Name,
+			Answered: sector.IsAnswered,
+			Answer:   answerOf(sector.Answer),
+			Author:   loginOf(sector.Answer),
+		})
+	}
+	return response
+}
+
+func (s *APIServer) getLevel(w http.ResponseWriter, r *http.Request, game *Game) {
+	format, err := textFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	level := game.CurrentLevel()
+	if level == nil {
+		writeError(w, http.StatusNotFound, ErrNoLevel.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, levelResponse(level, format))
+}
+
+func (s *APIServer) getCoordinates(w http.ResponseWriter, r *http.Request, game *Game) {
+	var response = CoordinatesResponse{Coords: en.Coordinates{}}
+	if level := game.CurrentLevel(); level != nil {
+		response.LevelNumber = level.Number
+		response.Coords = level.AllCoords()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// getHistory sends the history of the current level or of the level from `level`
+// query parameter
+func (s *APIServer) getHistory(w http.ResponseWriter, r *http.Request, game *Game) {
+	var levelNumber int8
+	if arg := r.URL.Query().Get("level"); arg != "" {
+		number, err := strconv.ParseInt(arg, 10, 8)
+		if err != nil || number <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("incorrect level %q", arg))
+			return
+		}
+		levelNumber = int8(number)
+	} else if level := game.CurrentLevel(); level != nil {
+		levelNumber = level.Number
+	} else {
+		writeError(w, http.StatusNotFound, ErrNoLevel.Error())
+		return
+	}
+
+	records, err := eventStore.Level(game.Chat.ID, game.Settings.GameID, levelNumber)
+	if err != nil {
+		log.Printf("[ERROR] Can't read history for chat %d: %s", game.Chat.ID, err)
+		writeError(w, http.StatusInternalServerError, "can't read history")
+		return
+	}
+	var response = []HistoryResponse{}
+	for _, record := range records {
+		response = append(response, historyResponse(record))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// historyResponse record of the history that is sent to the user
+func historyResponse(record HistoryRecord) HistoryResponse {
+	return HistoryResponse{
+		Level:  record.LevelNumber,
+		Type:   record.Type,
+		Text:   record.Text,
+		Author: record.Author,
+		Time:   record.CreatedAt,
+	}
+}
+
+// getEvents streams events of the game as Server-Sent Events. Current state of the level
+// is sent first, then level updates and new records of the history as they happen
+func (s *APIServer) getEvents(w http.ResponseWriter, r *http.Request, game *Game) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	events := streams.Subscribe(game.Chat.ID)
+	defer streams.Unsubscribe(game.Chat.ID, events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if level := game.CurrentLevel(); level != nil {
+		StreamEvent{Name: LevelStreamEvent, Data: levelResponse(level, en.HTML)}.WriteTo(w)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-events:
+			if _, err := event.WriteTo(w); err != nil {
+				log.Printf("[ERROR] Can't send %q event to chat %d subscriber: %s", event.Name, game.Chat.ID, err)
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// postCode sends the code from the body to the engine, the chat of the game is notified
+// about the result the same way as for the codes sent from Telegram
+func (s *APIServer) postCode(w http.ResponseWriter, r *http.Request, game *Game) {
+	var request CodeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "incorrect body: "+err.Error())
+		return
+	}
+	if request.Code = strings.TrimSpace(request.Code); request.Code == "" {
+		writeError(w, http.StatusBadRequest, "code is required")
+		return
+	}
+	if request.Author = strings.TrimSpace(request.Author); request.Author == "" {
+		request.Author = APICodeAuthor
+	}
+	log.Printf("Sending code %q from %s to EN engine", request.Code, request.Author)
+
+	game.codesMutex.Lock()
+	level := game.CurrentLevel()
+	correct, err := submitCode(game, request.Code, request.Author)
+	game.codesMutex.Unlock()
+
+	switch {
+	case errors.Is(err, ErrNoLevel):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, ErrLevelBlocked):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("[ERROR] Can't send code %q: %s", request.Code, err)
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	var codes en.Codes
+	if correct {
+		codes.Correct = []string{request.Code}
+	} else {
+		codes.Incorrect = []string{request.Code}
+	}
+	messageChan <- codesMessage(game.Chat, codes)
+	writeJSON(w, http.StatusOK, CodeResponse{Code: request.Code, Level: level.Number, Correct: correct})
+}
+
+// legacyCoordinates serves `/coords` endpoint that was used before the API was versioned,
+// coordinates of the game of the chat the token is issued for are sent
+func (s *APIServer) legacyCoordinates(w http.ResponseWriter, r *http.Request) {
+	log.Print("Get coordinates request accepted")
+
+	chatID, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	game, err := s.games.Get(chatID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.getCoordinates(w, r, game)
+}
+
+// startServer serves the API on the address from the config, over https if TLS is enabled
+func startServer(config EnvConfig, server *APIServer) {
+	var httpServer = &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if config.TLS {
+		log.Printf("Starting API server on %s with TLS", config.ListenAddr)
+		log.Fatal(httpServer.ListenAndServeTLS(config.TLSCert, config.TLSKey))
+	}
+	log.Printf("[WARNING] Starting API server on %s without TLS", config.ListenAddr)
+	log.Fatal(httpServer.ListenAndServe())
 }
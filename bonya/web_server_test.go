@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonya_bot/en"
+)
+
+// apiRequest sends request to the API server and decodes json response into the value
+func apiRequest(t *testing.T, server *APIServer, token, method, path, body string, value interface{}) int {
+	var (
+		request  = httptest.NewRequest(method, path, strings.NewReader(body))
+		recorder = httptest.NewRecorder()
+	)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	server.ServeHTTP(recorder, request)
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("%s %s: expected json response, got %q", method, path, contentType)
+	}
+	if value != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), value); err != nil {
+			t.Fatalf("%s %s: can't decode response %q: %s", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code
+}
+
+func TestAPIServerStatuses(t *testing.T) {
+	var (
+		engine = newTestEngine()
+		game   = newTestGame(t, engine)
+		server = NewAPIServer(games, tokens)
+		prefix = fmt.Sprintf("%s/%d", APIPrefix, game.Chat.ID)
+	)
+	defer engine.Close()
+
+	token, err := tokens.Issue(game.Chat.ID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := tokens.Issue(1, "test")
+	revoked, _ := tokens.Issue(2, "test")
+	if count, err := tokens.Revoke(2); err != nil || count != 1 {
+		t.Fatalf("Expected one revoked token, got %d %v", count, err)
+	}
+
+	for _, example := range []struct {
+		token  string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"", http.MethodGet, prefix + "/level", "", http.StatusUnauthorized},
+		{"wrong", http.MethodGet, prefix + "/level", "", http.StatusUnauthorized},
+		{revoked, http.MethodGet, APIPrefix, "", http.StatusUnauthorized},
+		{other, http.MethodGet, prefix + "/coords", "", http.StatusForbidden},
+		{other, http.MethodGet, APIPrefix + "/1/level", "", http.StatusNotFound},
+		{"", http.MethodGet, "/coords", "", http.StatusUnauthorized},
+		{"", http.MethodGet, prefix + "/coords?token=" + token, "", http.StatusOK},
+		{token, http.MethodGet, "/coords", "", http.StatusOK},
+		{token, http.MethodGet, APIPrefix, "", http.StatusOK},
+		{token, http.MethodPost, APIPrefix, "", http.StatusMethodNotAllowed},
+		{token, http.MethodGet, prefix, "", http.StatusOK},
+		{token, http.MethodGet, APIPrefix + "/abc/level", "", http.StatusBadRequest},
+		{token, http.MethodGet, APIPrefix + "/1/level", "", http.StatusForbidden},
+		{token, http.MethodGet, prefix + "/unknown", "", http.StatusNotFound},
+		{token, http.MethodGet, prefix + "/level", "", http.StatusNotFound},
+		{token, http.MethodGet, prefix + "/history", "", http.StatusNotFound},
+		{token, http.MethodGet, prefix + "/history?level=abc", "", http.StatusBadRequest},
+		{token, http.MethodGet, prefix + "/codes", "", http.StatusMethodNotAllowed},
+		{token, http.MethodPost, prefix + "/codes", "{", http.StatusBadRequest},
+		{token, http.MethodPost, prefix + "/codes", `{"code": " "}`, http.StatusBadRequest},
+		{token, http.MethodPost, prefix + "/codes", `{"code": "code1"}`, http.StatusNotFound},
+		{token, http.MethodGet, prefix + "/coords", "", http.StatusOK},
+	} {
+		if status := apiRequest(t, server, example.token, example.method, example.path, example.body, nil); status != example.status {
+			t.Errorf("%s %s: expected status %d, got %d", example.method, example.path, example.status, status)
+		}
+	}
+}
+
+func TestAPIServerEndToEnd(t *testing.T) {
+	var (
+		engine   = newTestEngine()
+		game     = newTestGame(t, engine)
+		messages = collectMessages()
+		server   = NewAPIServer(games, tokens)
+		prefix   = fmt.Sprintf("%s/%d", APIPrefix, game.Chat.ID)
+	)
+	defer engine.Close()
+	defer messages.stop()
+
+	historyDir, err := ioutil.TempDir("", "bonya")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(historyDir)
+	eventStore = NewFileEventStore(filepath.Join(historyDir, "history.jsonl"))
+	token, _ := tokens.Issue(game.Chat.ID, "test")
+
+	engine.Update(func(level *en.Level) {
+		level.Tasks = en.LevelTasks{{TaskText: "Едем <b>к фонтану</b> 55.751244, 37.618423"}}
+	})
+	startWatching(game)
+	defer stopWatching(game)
+	waitForLevel(t, game)
+
+	var level LevelResponse
+	if status := apiRequest(t, server, token, http.MethodGet, prefix+"/level?format=markdown", "", &level); status != http.StatusOK {
+		t.Fatalf("Expected level, got status %d", status)
+	}
+	if level.Number != 1 || level.Name != "First" || len(level.Sectors) != 3 || len(level.Bonuses) != 1 ||
+		len(level.Helps) != 1 || level.Helps[0].Remain != 600 {
+		t.Errorf("Unexpected level %+v", level)
+	}
+	if !strings.Contains(level.Task, "*к фонтану*") || len(level.Coords) != 1 {
+		t.Errorf("Unexpected task %q with coordinates %v", level.Task, level.Coords)
+	}
+	if status := apiRequest(t, server, token, http.MethodGet, prefix+"/level?format=pdf", "", nil); status != http.StatusBadRequest {
+		t.Errorf("Expected unknown format to be rejected, got status %d", status)
+	}
+
+	var code CodeResponse
+	status := apiRequest(t, server, token, http.MethodPost, prefix+"/codes", `{"code": "code1", "author": "scout"}`, &code)
+	if status != http.StatusOK || !code.Correct || code.Level != 1 {
+		t.Errorf("Expected correct code, got status %d %+v", status, code)
+	}
+	messages.waitFor(t, "*+* code1", time.Second)
+	// level from the response to the code has the same task, its coordinates stay
+	// available after the update
+	for deadline := time.Now().Add(time.Second); game.CurrentLevel().PassedSectorsCount == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var coords CoordinatesResponse
+	apiRequest(t, server, token, http.MethodGet, prefix+"/coords", "", &coords)
+	if game.CurrentLevel().PassedSectorsCount == 0 || len(coords.Coords) != 1 {
+		t.Errorf("Expected coordinates after the level update, got %+v", coords)
+	}
+	apiRequest(t, server, token, http.MethodPost, prefix+"/codes", `{"code": "wrong"}`, &code)
+	if code.Correct {
+		t.Errorf("Expected incorrect code, got %+v", code)
+	}
+
+	var history []HistoryResponse
+	apiRequest(t, server, token, http.MethodGet, prefix+"/history", "", &history)
+	var authors []string
+	for _, record := range history {
+		if record.Type == "CodeEntered" {
+			authors = append(authors, record.Author)
+		}
+	}
+	if strings.Join(authors, ",") != "scout,"+APICodeAuthor {
+		t.Errorf("Expected codes from scout and API in history, got %+v", history)
+	}
+
+	engine.Block(time.Minute)
+	blocked, _ := game.Engine.GetLevelInfo()
+	game.setCurrentLevel(blocked)
+	if status := apiRequest(t, server, token, http.MethodPost, prefix+"/codes", `{"code": "code2"}`, nil); status != http.StatusConflict {
+		t.Errorf("Expected blocked level, got status %d", status)
+	}
+}
@@ -3,6 +3,7 @@ package en
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,7 +11,9 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/cookiejar"
 	"strings"
+	"sync"
 )
 
 const (
@@ -27,29 +30,16 @@ const (
 
 	// SendCodeEndpoint send code endpoint
 	SendCodeEndpoint
-	// SendBonusCodeEndpoint
 
-	DEBUG = true
-)
+	// SendBonusCodeEndpoint send bonus code endpoint, engine accepts bonus codes
+	// on the same page as level codes, the difference is only in the payload
+	SendBonusCodeEndpoint
 
-const (
-	captcha = iota + 1
-	incorrectLogin
-	incorrectUser
-	ipBlacklisted
-	serverFault
-	bruteForce
-)
+	// PenaltyHelpEndpoint endpoint to request penalty hint with the id
+	PenaltyHelpEndpoint = "GameEngines/Encounter/Play/%d?pid=%d&pact=1&json=1"
 
-// ServerError map with possible login errors from EN server
-var ServerError = map[int32]string{
-	captcha:        "Captcha input is required",
-	incorrectLogin: "Incorrect login/password",
-	incorrectUser:  "Incorrect user",
-	ipBlacklisted:  "IP is blaclisted",
-	serverFault:    "Server fault",
-	bruteForce:     "Brootforce?",
-}
+	DEBUG = true
+)
 
 type enResponse interface {
 	createFromResponse(resp *http.Response) error
@@ -62,12 +52,14 @@ type enResponse interface {
 // `Result` - json with the Result
 // `StatusCode` - http status code
 // `Description` - error string in case request was not successful
+// `Err` - error in case request was not successful
 type APIAuthResponse struct {
 	Ok          bool
 	Cookies     []*http.Cookie
 	Result      json.RawMessage
 	StatusCode  int
 	Description string
+	Err         error
 }
 
 func (apiResp *APIAuthResponse) createFromResponse(resp *http.Response) error {
@@ -89,7 +81,8 @@ func (apiResp *APIAuthResponse) createFromResponse(resp *http.Response) error {
 
 	apiResp.Ok = respBody["Error"].(float64) == 0
 	if !apiResp.Ok {
-		apiResp.Description = ServerError[int32(respBody["Error"].(float64))]
+		apiResp.Err = loginError(int32(respBody["Error"].(float64)))
+		apiResp.Description = apiResp.Err.Error()
 	} else {
 		apiResp.Description = ""
 	}
@@ -122,9 +115,44 @@ type API struct {
 	Password      string       `json:"Password"`
 	Client        *http.Client `json:"-"`
 	CurrentGameID int32        `json:"-"`
-	CurrentLevel  *Level       `json:"-"`
-	Domain        string       `json:"-"`
-	Levels        *list.List   `json:"-"`
+	// CurrentLevel last known level, codes are sent to it. Level and SetLevel should be
+	// used when API is shared between goroutines
+	CurrentLevel *Level     `json:"-"`
+	Domain       string     `json:"-"`
+	Levels       *list.List `json:"-"`
+
+	levelMutex sync.RWMutex
+}
+
+// Level returns the last known level
+func (api *API) Level() *Level {
+	api.levelMutex.RLock()
+	defer api.levelMutex.RUnlock()
+	return api.CurrentLevel
+}
+
+// SetLevel changes the last known level
+func (api *API) SetLevel(level *Level) {
+	api.levelMutex.Lock()
+	defer api.levelMutex.Unlock()
+	api.CurrentLevel = level
+}
+
+// NewAPI creates API for the game on the domain. Client of the API restores
+// expired session with the given credentials, see ReauthTransport
+func NewAPI(domain, username, password string, gameID int32) *API {
+	var (
+		jar, _ = cookiejar.New(nil)
+		api    = &API{
+			Username:      username,
+			Password:      password,
+			CurrentGameID: gameID,
+			Domain:        domain,
+			Levels:        list.New(),
+		}
+	)
+	api.Client = &http.Client{Jar: jar, Transport: NewReauthTransport(api)}
+	return api
 }
 
 func (api *API) makeRequest2(method string, url string, body io.Reader) (*http.Response, error) {
@@ -167,7 +195,7 @@ func (api *API) verifyAuthResponse(response *http.Response) error {
 
 	ok = respBody["Error"].(float64) == 0
 	if !ok {
-		return errors.New(ServerError[int32(respBody["Error"].(float64))])
+		return loginError(int32(respBody["Error"].(float64)))
 	}
 	api.Client.Jar.SetCookies(response.Request.URL, response.Cookies())
 
@@ -257,7 +285,10 @@ func (api *API) Login() error {
 	}
 	if !authResponse.Ok {
 		log.Printf("Failed to login to server: %s", authResponse.Description)
-		return errors.New(authResponse.Description)
+		if authResponse.Err == nil {
+			return errors.New("Failed to login to server")
+		}
+		return authResponse.Err
 	}
 	log.Printf("Successfully logged in on server %q as user %q", api.Domain, api.Username)
 	return err
@@ -266,14 +297,31 @@ func (api *API) Login() error {
 // GetLevelInfo returns pointer to the LevelResponse object
 // with level information or empty object and the occurred error
 func (api *API) GetLevelInfo() (*Level, error) {
+	return api.GetLevelInfoContext(context.Background())
+}
+
+// GetLevelInfoContext is the same as GetLevelInfo, but the request is canceled
+// together with the context
+func (api *API) GetLevelInfoContext(ctx context.Context) (*Level, error) {
 	//gameUrl := "http://demo.en.cx/GameEngines/Encounter/Play/25733?json=1"
-	var (
-		gameURL = fmt.Sprintf(EnAddress, api.Domain, fmt.Sprintf(LevelInfoEndpoint, api.CurrentGameID))
-		lvl     *Level
-		err     error
-	)
+	return api.getLevel(ctx, fmt.Sprintf(EnAddress, api.Domain, fmt.Sprintf(LevelInfoEndpoint, api.CurrentGameID)))
+}
 
+// RequestPenaltyHelp asks engine to open penalty hint, returns level information
+// or error. Hint is opened only if it is available already, so the caller should
+// check the state of the hint in the returned level
+func (api *API) RequestPenaltyHelp(helpID int) (*Level, error) {
+	return api.getLevel(context.Background(),
+		fmt.Sprintf(EnAddress, api.Domain, fmt.Sprintf(PenaltyHelpEndpoint, api.CurrentGameID, helpID)))
+}
+
+// getLevel sends GET request to the game page and parses level information
+func (api *API) getLevel(ctx context.Context, gameURL string) (*Level, error) {
 	request, err := http.NewRequest("GET", gameURL, nil)
+	if err != nil {
+		return NewLevel(nil), err
+	}
+	request = request.WithContext(ctx)
 	// url, err := url.Parse(gameURL)
 	// for _, cookie := range api.Client.Jar.Cookies(url) {
 	// 	request.AddCookie(cookie)
@@ -284,8 +332,13 @@ func (api *API) GetLevelInfo() (*Level, error) {
 		log.Println("Error on GET request:", err)
 		return NewLevel(nil), err
 	}
+	return parseLevel(resp)
+}
 
-	if strings.HasPrefix(resp.Header["Content-Type"][0], "text/html") {
+// parseLevel parses level information from the response of the engine, events of the
+// game and responses without level are returned as errors
+func parseLevel(resp *http.Response) (*Level, error) {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
 		if resp.StatusCode == 504 {
 			log.Println("Timeout on server")
 		} else {
@@ -297,7 +350,7 @@ func (api *API) GetLevelInfo() (*Level, error) {
 			defer resp.Body.Close()
 			log.Printf("%s", buf)
 		}
-		return NewLevel(nil), errors.New("Incorrect cookies, need to re-login")
+		return NewLevel(nil), httpError(resp.StatusCode)
 	}
 	// buf, err := ioutil.ReadAll(resp.Body)
 	// if err != nil {
@@ -306,13 +359,19 @@ func (api *API) GetLevelInfo() (*Level, error) {
 	// defer resp.Body.Close()
 	// log.Printf("===> Body: %s", buf)
 
-	lvl = NewLevel(resp)
+	lvl := NewLevel(resp)
 	//lvl, err = parseLevelJSON(resp.Body)
-	if lvl == nil {
-		return lvl, errors.New("No level info")
+	if lvl.Parent == nil {
+		return lvl, ErrNoLevelInfo
+	}
+	if err, ok := eventErrors[lvl.Parent.Event]; ok {
+		return lvl, err
+	}
+	if lvl.LevelID == 0 {
+		return lvl, ErrNoLevelInfo
 	}
 
-	return lvl, err
+	return lvl, nil
 }
 
 type sendCodeResponse struct {
@@ -325,15 +384,15 @@ func (api *API) SendCode(code string) (*Level, error) {
 		codeURL = fmt.Sprintf(EnAddress, api.Domain, fmt.Sprintf(SendCodeEndpoint, api.CurrentGameID))
 		resp    *http.Response
 		body    SendCodeRequest
-		lvl     *Level
 		//bodyJSON []byte
 		err error
 	)
 
+	level := api.Level()
 	body = SendCodeRequest{
 		codeRequest: codeRequest{
-			LevelID:     api.CurrentLevel.LevelID,
-			LevelNumber: api.CurrentLevel.Number},
+			LevelID:     level.LevelID,
+			LevelNumber: level.Number},
 		LevelAction: code,
 	}
 
@@ -350,13 +409,32 @@ func (api *API) SendCode(code string) (*Level, error) {
 	//	return nil, err
 	//}
 
-	lvl = NewLevel(resp)
-	//lvl, err = parseLevelJSON(resp.Body)
-	return lvl, err
+	return parseLevel(resp)
 }
 
 // SendBonusCode sends post request with bonus code to EN server,
 // returns level information or error
-func (api *API) SendBonusCode() {
+func (api *API) SendBonusCode(code string) (*Level, error) {
+	var (
+		codeURL = fmt.Sprintf(EnAddress, api.Domain, fmt.Sprintf(SendBonusCodeEndpoint, api.CurrentGameID))
+		resp    *http.Response
+		body    SendBonusCodeRequest
+		err     error
+	)
+
+	level := api.Level()
+	body = SendBonusCodeRequest{
+		codeRequest: codeRequest{
+			LevelID:     level.LevelID,
+			LevelNumber: level.Number},
+		LevelAction: code,
+	}
+
+	resp, err = api.makeRequest(codeURL, body)
+	if err != nil {
+		log.Println("Error while sending bonus code:", err)
+		return nil, err
+	}
 
+	return parseLevel(resp)
 }
@@ -0,0 +1,313 @@
+package en_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"testing"
+	"time"
+
+	"github.com/bonya_bot/en"
+	"github.com/bonya_bot/en/entest"
+)
+
+const (
+	testLogin    = "player"
+	testPassword = "secret"
+	testGameID   = 25733
+)
+
+func newTestEngine() *entest.Engine {
+	var engine = entest.NewEngine(testLogin, testPassword, testGameID)
+
+	engine.AddLevel(en.Level{
+		Name:                 "First",
+		Timeout:              3600,
+		TimeoutSecondsRemain: 3600,
+		RequiredSectorsCount: 2,
+		SectorsLeftToClose:   2,
+		Sectors: en.LevelSectors{
+			{SectorId: 1, Order: 1, Name: "Sector 1"},
+			{SectorId: 2, Order: 2, Name: "Sector 2"},
+		},
+		Bonuses: en.LevelBonuses{
+			{BonusId: 10, Number: 1, Name: "Bonus 1", Help: "bonus help"},
+		},
+		Helps: en.LevelHelps{
+			{HelpID: 20, Number: 1, RemainSeconds: 600},
+		},
+	})
+	engine.AddLevel(en.Level{Name: "Second", Timeout: 1800, TimeoutSecondsRemain: 1800})
+	engine.AddSectorCode(1, 1, "code1")
+	engine.AddSectorCode(1, 2, "code2")
+	engine.AddBonusCode(1, 10, "bonus1")
+	return engine
+}
+
+func newTestAPI(engine *entest.Engine) *en.API {
+	var jar, _ = cookiejar.New(nil)
+	return &en.API{
+		Client:        &http.Client{Jar: jar},
+		CurrentGameID: testGameID,
+		Domain:        engine.Domain(),
+	}
+}
+
+func TestLogin2(t *testing.T) {
+	var engine = newTestEngine()
+	defer engine.Close()
+
+	if err := newTestAPI(engine).Login2(testLogin, "wrong"); !errors.Is(err, en.ErrIncorrectLogin) {
+		t.Errorf("Expected error %q for incorrect password, got %v", en.ErrIncorrectLogin, err)
+	}
+	if err := newTestAPI(engine).Login2(testLogin, testPassword); err != nil {
+		t.Errorf("Not expected errors, got %s", err)
+	}
+}
+
+func TestGetLevelInfo(t *testing.T) {
+	var (
+		engine = newTestEngine()
+		api    = newTestAPI(engine)
+	)
+	defer engine.Close()
+
+	if _, err := api.GetLevelInfo(); err == nil {
+		t.Errorf("Expected error without login, got nil")
+	}
+
+	api.Login2(testLogin, testPassword)
+	level, err := api.GetLevelInfo()
+	if err != nil {
+		t.Fatalf("Not expected errors, got %s", err)
+	}
+	if level.Number != 1 || level.Name != "First" || len(level.Sectors) != 2 {
+		t.Errorf("Expected first level with 2 sectors, got #%d %q with %d sectors",
+			level.Number, level.Name, len(level.Sectors))
+	}
+	if level.Parent == nil || level.Parent.Levels.Len() != 2 {
+		t.Errorf("Expected game with 2 levels")
+	}
+
+	engine.ExpireSessions()
+	if _, err := api.GetLevelInfo(); err == nil {
+		t.Errorf("Expected error for expired session, got nil")
+	}
+}
+
+func TestSendCode(t *testing.T) {
+	var (
+		engine = newTestEngine()
+		api    = newTestAPI(engine)
+	)
+	defer engine.Close()
+
+	api.Login2(testLogin, testPassword)
+	api.CurrentLevel, _ = api.GetLevelInfo()
+
+	for _, example := range []struct {
+		code        string
+		correct     bool
+		sectorsLeft int16
+	}{
+		{"wrong", false, 2},
+		{"CODE1", true, 1},
+	} {
+		level, err := api.SendCode(example.code)
+		if err != nil {
+			t.Fatalf("Not expected errors, got %s", err)
+		}
+		if level.MixedActions[0].Answer != example.code || level.MixedActions[0].IsCorrect != example.correct {
+			t.Errorf("For %q expected correct=%t, got %+v", example.code, example.correct, level.MixedActions[0])
+		}
+		if level.SectorsLeftToClose != example.sectorsLeft {
+			t.Errorf("For %q expected %d sectors left, got %d", example.code, example.sectorsLeft,
+				level.SectorsLeftToClose)
+		}
+	}
+
+	api.SendCode("code2")
+	if level, _ := api.GetLevelInfo(); level.Number != 2 {
+		t.Errorf("Expected level #2 after all sectors are closed, got #%d", level.Number)
+	}
+}
+
+func TestSendBonusCode(t *testing.T) {
+	var (
+		engine = newTestEngine()
+		api    = newTestAPI(engine)
+	)
+	defer engine.Close()
+
+	api.Login2(testLogin, testPassword)
+	api.CurrentLevel, _ = api.GetLevelInfo()
+
+	level, err := api.SendBonusCode("bonus1")
+	if err != nil {
+		t.Fatalf("Not expected errors, got %s", err)
+	}
+	if !level.Bonuses[0].IsAnswered {
+		t.Errorf("Expected bonus %q to be answered", level.Bonuses[0].Name)
+	}
+	if level.MixedActions[0].Kind != en.BonusAnswer {
+		t.Errorf("Expected bonus answer in actions, got %+v", level.MixedActions[0])
+	}
+}
+
+func TestRequestPenaltyHelp(t *testing.T) {
+	var (
+		engine = newTestEngine()
+		api    = newTestAPI(engine)
+	)
+	defer engine.Close()
+
+	engine.AddPenaltyHelp(1, en.HelpInfo{HelpID: 30, Number: 1, Penalty: 600}, "penalty hint")
+	engine.AddPenaltyHelp(1, en.HelpInfo{HelpID: 31, Number: 2, Penalty: 600, RemainSeconds: 60}, "later")
+	api.Login2(testLogin, testPassword)
+
+	for _, example := range []struct {
+		helpID int
+		text   string
+	}{
+		{31, ""},
+		{30, "penalty hint"},
+	} {
+		level, err := api.RequestPenaltyHelp(example.helpID)
+		if err != nil {
+			t.Fatalf("Not expected errors, got %s", err)
+		}
+		for _, help := range level.PenaltyHelps {
+			if help.HelpID == example.helpID && help.HelpText != example.text {
+				t.Errorf("Expected text %q of the hint %d, got %q", example.text, example.helpID, help.HelpText)
+			}
+		}
+	}
+}
+
+func TestGetLevelInfoErrors(t *testing.T) {
+	var (
+		engine = newTestEngine()
+		api    = newTestAPI(engine)
+	)
+	defer engine.Close()
+
+	api.Login2(testLogin, testPassword)
+	for _, example := range []struct {
+		scenario func()
+		expected *en.Error
+	}{
+		{func() { engine.FailNext(504, 1) }, en.ErrServerTimeout},
+		{func() { engine.SetEvent(5) }, en.ErrGameNotStarted},
+		{func() { engine.SetEvent(6) }, en.ErrGameFinished},
+		{func() { engine.SetEvent(0); engine.ExpireSessions() }, en.ErrNotAuthenticated},
+	} {
+		example.scenario()
+		_, err := api.GetLevelInfo()
+		if !errors.Is(err, example.expected) {
+			t.Errorf("Expected error %q, got %v", example.expected, err)
+		}
+		var enErr *en.Error
+		if errors.As(err, &enErr) && enErr.Code != example.expected.Code {
+			t.Errorf("Expected code %d, got %d", example.expected.Code, enErr.Code)
+		}
+	}
+}
+
+func TestSendCodeErrors(t *testing.T) {
+	var (
+		engine = newTestEngine()
+		api    = newTestAPI(engine)
+	)
+	defer engine.Close()
+
+	api.Login2(testLogin, testPassword)
+	level, _ := api.GetLevelInfo()
+	api.SetLevel(level)
+
+	engine.SetEvent(5)
+	if _, err := api.SendCode("code1"); !errors.Is(err, en.ErrGameNotStarted) {
+		t.Errorf("Expected error %q for the code, got %v", en.ErrGameNotStarted, err)
+	}
+	engine.SetEvent(6)
+	if _, err := api.SendBonusCode("bonus1"); !errors.Is(err, en.ErrGameFinished) {
+		t.Errorf("Expected error %q for the bonus code, got %v", en.ErrGameFinished, err)
+	}
+	if task := (&en.Level{}).GetLevelTask(); task != "" {
+		t.Errorf("Expected empty task for the level without tasks, got %q", task)
+	}
+}
+
+func newReauthAPI(engine *entest.Engine, password string) *en.API {
+	var api = en.NewAPI(engine.Domain(), testLogin, password, testGameID)
+
+	transport := api.Client.Transport.(*en.ReauthTransport)
+	transport.Backoff = time.Millisecond
+	transport.MaxBackoff = 10 * time.Millisecond
+	return api
+}
+
+func TestReauthTransport(t *testing.T) {
+	var (
+		engine = newTestEngine()
+		api    = newReauthAPI(engine, testPassword)
+	)
+	defer engine.Close()
+
+	// first request logs in without explicit Login2
+	if _, err := api.GetLevelInfo(); err != nil {
+		t.Fatalf("Not expected errors, got %s", err)
+	}
+	engine.ExpireSessions()
+	if _, err := api.GetLevelInfo(); err != nil {
+		t.Fatalf("Not expected errors after session is expired, got %s", err)
+	}
+
+	engine.ExpireSessions()
+	api.CurrentLevel = &en.Level{LevelID: engine.CurrentLevel().LevelID, Number: 1}
+	level, err := api.SendCode("code1")
+	if err != nil || len(level.MixedActions) != 1 || !level.MixedActions[0].IsCorrect {
+		t.Fatalf("Expected code to be sent once after login, got %v", err)
+	}
+
+	engine.FailNext(502, 2)
+	if _, err := api.GetLevelInfo(); err != nil {
+		t.Errorf("Expected request to be repeated after server failures, got %s", err)
+	}
+	engine.FailNext(504, en.DefaultRetries+1)
+	if _, err := api.GetLevelInfo(); !errors.Is(err, en.ErrServerTimeout) {
+		t.Errorf("Expected error %q when retries are over, got %v", en.ErrServerTimeout, err)
+	}
+	if logins := engine.LoginRequests(); logins != 3 {
+		t.Errorf("Expected 3 logins, got %d", logins)
+	}
+
+	// engine could accept the code before it failed, so codes are not repeated
+	engine.FailNext(502, 1)
+	if _, err := api.SendCode("code2"); err == nil {
+		t.Errorf("Expected code not to be repeated after server failure")
+	}
+	if level, _ := api.GetLevelInfo(); level.Number != 1 {
+		t.Errorf("Expected code not to be sent, got level #%d", level.Number)
+	}
+}
+
+func TestReauthTransportLoginBackoff(t *testing.T) {
+	var (
+		engine = newTestEngine()
+		api    = newReauthAPI(engine, "wrong")
+	)
+	defer engine.Close()
+
+	transport := api.Client.Transport.(*en.ReauthTransport)
+	transport.Backoff, transport.MaxBackoff = time.Minute, time.Minute
+
+	for i := 0; i < 2; i++ {
+		if _, err := api.GetLevelInfo(); !errors.Is(err, en.ErrIncorrectLogin) {
+			t.Errorf("Expected error %q, got %v", en.ErrIncorrectLogin, err)
+		}
+	}
+	// the second request doesn't try to login until backoff expires
+	if logins := engine.LoginRequests(); logins != 1 {
+		t.Errorf("Expected 1 login, got %d", logins)
+	}
+}
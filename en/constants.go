@@ -36,6 +36,10 @@ const (
 	// HelpInfoString hint information
 	HelpInfoString = `
 *Подсказка:* %d
+*Текст:* %s`
+	// PenaltyHelpInfoString penalty hint information
+	PenaltyHelpInfoString = `
+*Штрафная подсказка:* %d
 *Текст:* %s`
 	//MixedActionInfoString = `
 	//*%s* вбил код *%q*.`
@@ -45,6 +49,10 @@ const (
 	//IncorrectAnswerString = `*-* %q *%s*`
 	IncorrectAnswerString = "*-* %s\n"
 
+	// BonusCodeString correct bonus code with the name of the bonus it closed, name is
+	// escaped and can't be inside the entity, escapes don't work there in Markdown
+	BonusCodeString = "%s (бонус \"%s\")"
+
 	// NotSentAnswersString codes that were not sent because of block
 	NotSentAnswersString = "*блок:* %s"
 
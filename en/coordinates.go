@@ -0,0 +1,415 @@
+package en
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SourceKind kind of the text where coordinate was found
+type SourceKind string
+
+const (
+	// TaskSource coordinate is from the level task
+	TaskSource SourceKind = "task"
+	// HelpSource coordinate is from the hint
+	HelpSource SourceKind = "help"
+	// PenaltyHelpSource coordinate is from the penalty hint
+	PenaltyHelpSource SourceKind = "penalty_help"
+	// BonusSource coordinate is from the bonus
+	BonusSource SourceKind = "bonus"
+)
+
+// Source text where coordinate was found, Number is the number of the hint or bonus
+type Source struct {
+	Kind   SourceKind `json:"kind"`
+	Number int        `json:"number,omitempty"`
+}
+
+func (s Source) String() string {
+	switch s.Kind {
+	case HelpSource:
+		return fmt.Sprintf("подсказка %d", s.Number)
+	case PenaltyHelpSource:
+		return fmt.Sprintf("штрафная подсказка %d", s.Number)
+	case BonusSource:
+		return fmt.Sprintf("бонус %d", s.Number)
+	}
+	return "задание"
+}
+
+// Coordinate place found in the text of the task, hint or bonus
+type Coordinate struct {
+	Lat            float64 `json:"lattitude"`
+	Lon            float64 `json:"longtitude"`
+	OriginalString string  `json:"name"`
+	Source         Source  `json:"source"`
+}
+
+// Coordinates - array of Coordinate objects
+type Coordinates []Coordinate
+
+func (c Coordinate) String() (text string) {
+	text = fmt.Sprintf("%s (%f, %f)", c.OriginalString, c.Lat, c.Lon)
+	return
+}
+
+// valid returns true if coordinate is in the range of latitudes and longitudes.
+// Zero coordinate is treated as invalid, usually it is some number in the text
+func (c Coordinate) valid() bool {
+	return math.Abs(c.Lat) <= 90 && math.Abs(c.Lon) <= 180 && (c.Lat != 0 || c.Lon != 0)
+}
+
+// same returns true if coordinates point to the same place
+func (c Coordinate) same(other Coordinate) bool {
+	const precision = 1e-6
+	return math.Abs(c.Lat-other.Lat) < precision && math.Abs(c.Lon-other.Lon) < precision
+}
+
+// Add appends coordinates that are not in the list yet
+func (cs Coordinates) Add(coords ...Coordinate) Coordinates {
+	for _, coord := range coords {
+		var exist bool
+		for _, c := range cs {
+			if c.same(coord) {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			cs = append(cs, coord)
+		}
+	}
+	return cs
+}
+
+const (
+	// latHemispheres letters of the northern and southern hemispheres, Cyrillic ones too
+	latHemispheres = "NSСЮ"
+	// lonHemispheres letters of the eastern and western hemispheres, Cyrillic ones too
+	lonHemispheres = "EWВЗ"
+	// number with the decimal point or comma
+	numberRe = `\d+(?:[.,]\d+)?`
+	// minDecimalDigits digits after the point in the decimal degrees without hemisphere
+	// letters, so that numbers like prices are not taken for coordinates
+	minDecimalDigits = 4
+)
+
+// angleRe degrees with optional minutes and seconds, e.g. 49°58'34.5" or 49°58.567'
+// or 49.976°, hemisphere letter can be before or after the angle
+func angleRe(hemispheres string) string {
+	return `(?:([` + hemispheres + `])\s*)?(-?` + numberRe + `)\s*°\s*` +
+		`(?:(` + numberRe + `)\s*['′’]?\s*)?` +
+		`(?:(` + numberRe + `)\s*(?:"|″|”|'')\s*)?` +
+		`([` + hemispheres + `])?`
+}
+
+// decimalRe degrees with the decimal fraction, e.g. 49.976136 or N49.976136
+func decimalRe(hemispheres string, digits int) string {
+	return `(?:([` + hemispheres + `])\s*)?(-?\d{1,` + strconv.Itoa(digits) + `}[.,]\d{3,})([` + hemispheres + `])?`
+}
+
+var (
+	// <a href="geo:49.976136, 36.267256">49.976136, 36.267256</a>, quotes can be escaped
+	anchorRe = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*\\?["']([^"'\\]+)\\?["'][^>]*>(.*?)</a>`)
+	// https://www.google.com.ua/maps/@50.0363257,36.2120039,19z
+	urlRe = regexp.MustCompile(`(?:https?://|geo:)[^\s"'<>]+`)
+	// 49°58'34"N 36°16'02"E, N 49° 58.567 E 036° 16.033
+	angleCoordsRe = regexp.MustCompile(angleRe(latHemispheres) + `\s*[,;]?\s*` + angleRe(lonHemispheres))
+	// 49.976136, 36.267256
+	decimalCoordsRe = regexp.MustCompile(decimalRe(latHemispheres, 2) + `(?:\s*[,;]\s*|\s+)` + decimalRe(lonHemispheres, 3))
+	// lat,lon pair in the URL
+	urlPairRe = regexp.MustCompile(`(-?\d{1,2}\.\d{3,})\s*(?:,|%2C)\s*(-?\d{1,3}\.\d{3,})`)
+	tagRe     = regexp.MustCompile(`<[^>]*>`)
+)
+
+// coordinateMatch coordinate found in the text at [start, end), replacement is the
+// text that replaces the match
+type coordinateMatch struct {
+	start, end  int
+	coordinate  Coordinate
+	replacement string
+}
+
+// parseNumber parses number with the decimal point or comma
+func parseNumber(text string) (float64, bool) {
+	number, err := strconv.ParseFloat(strings.Replace(text, ",", ".", 1), 64)
+	return number, err == nil
+}
+
+// parsePair parses "first,second" where numbers are separated with comma
+func parsePair(text string) (float64, float64, bool) {
+	parts := strings.Split(text, ",")
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	first, ok1 := parseNumber(strings.TrimSpace(parts[0]))
+	second, ok2 := parseNumber(strings.TrimSpace(parts[1]))
+	return first, second, ok1 && ok2
+}
+
+// parseMapURL returns coordinate from the link to the map: geo: URI, Google Maps,
+// Yandex Maps, OpenStreetMap or 2GIS. Yandex and 2GIS put longitude first
+func parseMapURL(link string) (lat float64, lon float64, ok bool) {
+	link = strings.Replace(link, "&amp;", "&", -1)
+	if strings.HasPrefix(strings.ToLower(link), "geo:") {
+		value := strings.SplitN(strings.SplitN(link[4:], ";", 2)[0], "?", 2)[0]
+		return parsePair(value)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return 0, 0, false
+	}
+	var (
+		host  = strings.ToLower(u.Host)
+		query = u.Query()
+	)
+	switch {
+	case strings.Contains(host, "yandex."):
+		for _, key := range []string{"pt", "whatshere[point]", "ll"} {
+			if lon, lat, ok = parsePair(query.Get(key)); ok {
+				return lat, lon, true
+			}
+		}
+		if lat, lon, ok = parsePair(query.Get("text")); ok {
+			return lat, lon, true
+		}
+	case strings.Contains(host, "2gis."):
+		if m := query.Get("m"); m != "" {
+			if lon, lat, ok = parsePair(strings.Split(m, "/")[0]); ok {
+				return lat, lon, true
+			}
+		}
+		for _, segment := range strings.Split(u.Path, "/") {
+			if lon, lat, ok = parsePair(segment); ok && strings.Contains(segment, ".") {
+				return lat, lon, true
+			}
+		}
+	case strings.Contains(host, "openstreetmap.") || strings.Contains(host, "osm.org"):
+		lat, ok1 := parseNumber(query.Get("mlat"))
+		lon, ok2 := parseNumber(query.Get("mlon"))
+		if ok1 && ok2 {
+			return lat, lon, true
+		}
+		if parts := strings.Split(strings.TrimPrefix(u.Fragment, "map="), "/"); len(parts) == 3 {
+			lat, ok1 = parseNumber(parts[1])
+			lon, ok2 = parseNumber(parts[2])
+			if ok1 && ok2 {
+				return lat, lon, true
+			}
+		}
+	}
+	for _, key := range []string{"q", "query", "daddr", "destination", "ll", "center"} {
+		if lat, lon, ok = parsePair(query.Get(key)); ok {
+			return lat, lon, true
+		}
+	}
+	if match := urlPairRe.FindStringSubmatch(link); match != nil {
+		lat, _ = parseNumber(match[1])
+		lon, _ = parseNumber(match[2])
+		return lat, lon, true
+	}
+	return 0, 0, false
+}
+
+// parseAngle converts degrees, minutes and seconds into the decimal degrees, the
+// angle is negative for southern and western hemispheres
+func parseAngle(degrees, minutes, seconds, hemisphere string) (float64, bool) {
+	var (
+		value, ok = parseNumber(degrees)
+		m, s      float64
+	)
+	if !ok {
+		return 0, false
+	}
+	if minutes != "" {
+		if m, ok = parseNumber(minutes); !ok || m >= 60 {
+			return 0, false
+		}
+	}
+	if seconds != "" {
+		if s, ok = parseNumber(seconds); !ok || s >= 60 {
+			return 0, false
+		}
+	}
+	value = math.Abs(value) + m/60 + s/3600
+	if strings.HasPrefix(degrees, "-") || strings.ContainsAny(hemisphere, "SЮWЗ") {
+		value = -value
+	}
+	return value, true
+}
+
+// hemisphereOf returns the hemisphere letter of the angle, letter after the angle
+// is ignored if it is the first letter of the next word
+func hemisphereOf(text string, index []int, before int, after int) string {
+	if index[2*before] >= 0 {
+		return text[index[2*before]:index[2*before+1]]
+	}
+	if start, end := index[2*after], index[2*after+1]; start >= 0 {
+		if r, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && unicode.IsLetter(r) {
+			return ""
+		}
+		return text[start:end]
+	}
+	return ""
+}
+
+// matchEnd returns the end of the match without the hemisphere letter if it is the
+// first letter of the next word
+func matchEnd(text string, end int, after []int) int {
+	if after[0] >= 0 && after[1] == end {
+		if r, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && unicode.IsLetter(r) {
+			return after[0]
+		}
+	}
+	return end
+}
+
+// decimalPair returns true if the match of decimalCoordsRe without hemisphere letters
+// looks like coordinates: both numbers have at least minDecimalDigits digits after
+// the point or they are separated with comma
+func decimalPair(text string, index []int) bool {
+	var (
+		lat       = text[index[4]:index[5]]
+		lon       = text[index[10]:index[11]]
+		separator = text[index[5]:index[10]]
+		fraction  = func(number string) int {
+			return len(number) - strings.IndexAny(number, ".,") - 1
+		}
+	)
+	if index[8] >= 0 {
+		separator = text[index[5]:index[8]]
+	}
+	return (fraction(lat) >= minDecimalDigits && fraction(lon) >= minDecimalDigits) ||
+		strings.Contains(separator, ",")
+}
+
+// findCoordinates finds all coordinates in the text, matches don't overlap
+func findCoordinates(text string) (matches []coordinateMatch) {
+	var overlaps = func(start, end int) bool {
+		for _, m := range matches {
+			if start < m.end && m.start < end {
+				return true
+			}
+		}
+		return false
+	}
+
+	// links to the map are replaced with the text of the link
+	for _, index := range anchorRe.FindAllStringSubmatchIndex(text, -1) {
+		lat, lon, ok := parseMapURL(text[index[2]:index[3]])
+		if !ok {
+			continue
+		}
+		name := strings.TrimSpace(tagRe.ReplaceAllString(text[index[4]:index[5]], ""))
+		matches = append(matches, coordinateMatch{start: index[0], end: index[1], replacement: name,
+			coordinate: Coordinate{Lat: lat, Lon: lon, OriginalString: name}})
+	}
+	for _, index := range urlRe.FindAllStringIndex(text, -1) {
+		if overlaps(index[0], index[1]) {
+			continue
+		}
+		link := text[index[0]:index[1]]
+		if lat, lon, ok := parseMapURL(link); ok {
+			matches = append(matches, coordinateMatch{start: index[0], end: index[1], replacement: link,
+				coordinate: Coordinate{Lat: lat, Lon: lon, OriginalString: link}})
+		}
+	}
+	for _, index := range angleCoordsRe.FindAllStringSubmatchIndex(text, -1) {
+		var (
+			group = func(i int) string {
+				if index[2*i] < 0 {
+					return ""
+				}
+				return text[index[2*i]:index[2*i+1]]
+			}
+			end = matchEnd(text, index[1], index[20:22])
+		)
+		if overlaps(index[0], end) {
+			continue
+		}
+		lat, ok1 := parseAngle(group(2), group(3), group(4), hemisphereOf(text, index, 1, 5))
+		lon, ok2 := parseAngle(group(7), group(8), group(9), hemisphereOf(text, index, 6, 10))
+		if ok1 && ok2 {
+			original := strings.TrimSpace(text[index[0]:end])
+			matches = append(matches, coordinateMatch{start: index[0], end: end, replacement: original,
+				coordinate: Coordinate{Lat: lat, Lon: lon, OriginalString: original}})
+		}
+	}
+	for _, index := range decimalCoordsRe.FindAllStringSubmatchIndex(text, -1) {
+		var (
+			group = func(i int) string {
+				if index[2*i] < 0 {
+					return ""
+				}
+				return text[index[2*i]:index[2*i+1]]
+			}
+			end = matchEnd(text, index[1], index[12:14])
+		)
+		if overlaps(index[0], end) {
+			continue
+		}
+		if r, _ := utf8.DecodeLastRuneInString(text[:index[0]]); index[0] > 0 && (unicode.IsDigit(r) || r == '.') {
+			// part of the longer number
+			continue
+		}
+		var (
+			latHemisphere = hemisphereOf(text, index, 1, 3)
+			lonHemisphere = hemisphereOf(text, index, 4, 6)
+		)
+		if latHemisphere == "" && lonHemisphere == "" && !decimalPair(text, index) {
+			// e.g. prices "1.500 2.750"
+			continue
+		}
+		lat, ok1 := parseAngle(group(2), "", "", latHemisphere)
+		lon, ok2 := parseAngle(group(5), "", "", lonHemisphere)
+		if ok1 && ok2 {
+			original := text[index[0]:end]
+			matches = append(matches, coordinateMatch{start: index[0], end: end, replacement: original,
+				coordinate: Coordinate{Lat: lat, Lon: lon, OriginalString: original}})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	return
+}
+
+// ExtractCoordinatesFrom extracts coordinates from the text of the source. Links to
+// the maps are replaced with their text. Coordinates out of range and duplicates
+// are dropped
+func ExtractCoordinatesFrom(text string, source Source) (string, Coordinates) {
+	var (
+		result strings.Builder
+		coords = Coordinates{}
+		last   int
+	)
+	log.Print("[INFO] Extract coordinates from task text")
+	for _, match := range findCoordinates(text) {
+		result.WriteString(text[last:match.start])
+		result.WriteString(match.replacement)
+		last = match.end
+		if !match.coordinate.valid() {
+			log.Printf("[WARNING] Coordinate %q is out of range", match.coordinate.OriginalString)
+			continue
+		}
+		match.coordinate.Source = source
+		coords = coords.Add(match.coordinate)
+	}
+	result.WriteString(text[last:])
+	if DEBUG {
+		log.Printf("[DEBUG] Found %d coordinates", len(coords))
+	}
+	return result.String(), coords
+}
+
+// ExtractCoordinates extracts coordinates from the given text of the task and
+// returns the updated string with replaced coordinates and the list of coordinates
+func ExtractCoordinates(text string) (string, Coordinates) {
+	return ExtractCoordinatesFrom(text, Source{Kind: TaskSource})
+}
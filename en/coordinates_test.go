@@ -0,0 +1,83 @@
+package en
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExtractCoordinatesFormats(t *testing.T) {
+	for _, example := range []struct {
+		name     string
+		input    string
+		expected string
+		coords   [][2]float64
+	}{
+		{"decimal", "Точка 49.976136, 36.267256 у входа", "Точка 49.976136, 36.267256 у входа",
+			[][2]float64{{49.976136, 36.267256}}},
+		{"decimal comma", "49,976136 36,267256", "49,976136 36,267256", [][2]float64{{49.976136, 36.267256}}},
+		{"negative and three-digit longitude", "-33.856784, 151.215297 и -12.0464, -077.042793", "",
+			[][2]float64{{-33.856784, 151.215297}, {-12.0464, -77.042793}}},
+		{"hemisphere letters", "N49.976136 E36.267256", "", [][2]float64{{49.976136, 36.267256}}},
+		{"hemisphere before next word", "49.976136 36.267256 Здание", "", [][2]float64{{49.976136, 36.267256}}},
+		{"dms", `49°58'34"N 36°16'02"E`, "", [][2]float64{{49.976111, 36.267222}}},
+		{"dms south west", `33°51′24.4″S, 151°12′55.1″W`, "", [][2]float64{{-33.856778, -151.215306}}},
+		{"ddm", "N 49° 58.567 E 036° 16.033", "", [][2]float64{{49.976117, 36.267217}}},
+		{"cyrillic hemisphere", `С 49°58.567' В 36°16.033'`, "", [][2]float64{{49.976117, 36.267217}}},
+		{"geo link", `<a href="geo:49.976136, 36.267256">вход</a>`, "вход", [][2]float64{{49.976136, 36.267256}}},
+		{"google link", `<a href="https://www.google.com.ua/maps/@50.0363257,36.2120039,19z" target="blank">50.036435 36.211914</a>`,
+			"50.036435 36.211914", [][2]float64{{50.0363257, 36.2120039}}},
+		{"yandex link", `<a href="https://yandex.ua/maps/?ll=36.230000%2C49.990000&amp;z=16">тут</a>`, "тут",
+			[][2]float64{{49.99, 36.23}}},
+		{"yandex point", "https://yandex.ru/maps/?pt=36.2672,49.9761&z=17", "https://yandex.ru/maps/?pt=36.2672,49.9761&z=17",
+			[][2]float64{{49.9761, 36.2672}}},
+		{"osm link", `<a href="https://www.openstreetmap.org/?mlat=49.9761&mlon=36.2672#map=17/49.9761/36.2672">OSM</a>`, "OSM",
+			[][2]float64{{49.9761, 36.2672}}},
+		{"osm fragment", "https://www.openstreetmap.org/#map=17/49.97610/36.26720", "", [][2]float64{{49.9761, 36.2672}}},
+		{"2gis link", `<a href="https://2gis.ua/kharkov/geo/36.2672,49.9761">2ГИС</a>`, "2ГИС", [][2]float64{{49.9761, 36.2672}}},
+		{"2gis map", "https://2gis.ru/moscow?m=37.617635,55.755814/16", "", [][2]float64{{55.755814, 37.617635}}},
+		{"duplicates", `<a href="geo:49.976136,36.267256">вход</a> или 49.976136, 36.267256`, "вход или 49.976136, 36.267256",
+			[][2]float64{{49.976136, 36.267256}}},
+		{"out of range", "95.123456, 36.267256", "", nil},
+		{"not coordinates", "Код 12.3456789012 и телефон 067.1234567", "", nil},
+		{"short decimals with comma", "49.976, 36.267", "", [][2]float64{{49.976, 36.267}}},
+		{"short decimals with hemisphere", "N49.976 E36.267", "", [][2]float64{{49.976, 36.267}}},
+		{"prices", "Билеты по 1.500 2.750 грн", "Билеты по 1.500 2.750 грн", nil},
+		{"prices with decimal comma", "Цены: 1,500 2,750 и 3,250 4,100", "", nil},
+		{"times", "Старт 10.30 12.45, финиш 18.000 19.300", "", nil},
+		{"other link", `<a href="http://en.cx/GameDetails.aspx?gid=25733">игра</a>`,
+			`<a href="http://en.cx/GameDetails.aspx?gid=25733">игра</a>`, nil},
+	} {
+		text, coords := ExtractCoordinatesFrom(example.input, Source{Kind: HelpSource, Number: 2})
+		if example.expected != "" && text != example.expected {
+			t.Errorf("%s: expected text %q, got %q", example.name, example.expected, text)
+		}
+		if len(coords) != len(example.coords) {
+			t.Errorf("%s: expected %d coordinates, got %v", example.name, len(example.coords), coords)
+			continue
+		}
+		for i, coord := range coords {
+			if math.Abs(coord.Lat-example.coords[i][0]) > 1e-6 || math.Abs(coord.Lon-example.coords[i][1]) > 1e-6 {
+				t.Errorf("%s: expected coordinate %v, got %f, %f", example.name, example.coords[i], coord.Lat, coord.Lon)
+			}
+			if coord.Source != (Source{Kind: HelpSource, Number: 2}) {
+				t.Errorf("%s: unexpected source %v", example.name, coord.Source)
+			}
+		}
+	}
+}
+
+func TestSourceString(t *testing.T) {
+	for _, example := range []struct {
+		source   Source
+		expected string
+	}{
+		{Source{Kind: TaskSource}, "задание"},
+		{Source{Kind: HelpSource, Number: 2}, "подсказка 2"},
+		{Source{Kind: PenaltyHelpSource, Number: 1}, "штрафная подсказка 1"},
+		{Source{Kind: BonusSource, Number: 5}, "бонус 5"},
+	} {
+		if result := example.source.String(); result != example.expected {
+			t.Errorf("Expected %q, got %q", example.expected, result)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package en
+
+import (
+	"time"
+)
+
+// EventType type of the change on the level
+type EventType int8
+
+const (
+	// LevelChanged new level is started
+	LevelChanged EventType = iota
+	// HelpOpened hint became available
+	HelpOpened
+	// PenaltyHelpOpened penalty hint became available
+	PenaltyHelpOpened
+	// SectorClosed sector is answered
+	SectorClosed
+	// BonusClosed bonus is answered
+	BonusClosed
+	// BonusAppeared new bonus appeared on the level or bonus became available
+	BonusAppeared
+	// BonusExpired time to answer the bonus is over
+	BonusExpired
+	// CodeEntered somebody from the team entered the code
+	CodeEntered
+	// BlockStarted players can't enter codes because of the block
+	BlockStarted
+	// BlockEnded block is over
+	BlockEnded
+	// TimeoutApproaching time left till the end of the level passed one of Checkpoints.Timeout
+	TimeoutApproaching
+	// BonusExpiring time left to answer the bonus passed one of Checkpoints.Bonus
+	BonusExpiring
+	// HelpApproaching time left till the hint passed one of Checkpoints.Help
+	HelpApproaching
+)
+
+var eventTypeNames = map[EventType]string{
+	LevelChanged:       "LevelChanged",
+	HelpOpened:         "HelpOpened",
+	PenaltyHelpOpened:  "PenaltyHelpOpened",
+	SectorClosed:       "SectorClosed",
+	BonusClosed:        "BonusClosed",
+	BonusAppeared:      "BonusAppeared",
+	BonusExpired:       "BonusExpired",
+	CodeEntered:        "CodeEntered",
+	BlockStarted:       "BlockStarted",
+	BlockEnded:         "BlockEnded",
+	TimeoutApproaching: "TimeoutApproaching",
+	BonusExpiring:      "BonusExpiring",
+	HelpApproaching:    "HelpApproaching",
+}
+
+func (et EventType) String() string {
+	if name, ok := eventTypeNames[et]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Checkpoints time before the deadlines when the events about approaching deadlines
+// are emitted, empty list turns the events off
+type Checkpoints struct {
+	// Timeout time before the end of the level for TimeoutApproaching event
+	Timeout []time.Duration
+	// Help time before the hint for HelpApproaching event
+	Help []time.Duration
+	// Bonus time before the end of the bonus for BonusExpiring event
+	Bonus []time.Duration
+}
+
+// DefaultCheckpoints checkpoints that are used by Diff
+var DefaultCheckpoints = Checkpoints{
+	Timeout: []time.Duration{
+		60 * time.Minute,
+		30 * time.Minute,
+		15 * time.Minute,
+		5 * time.Minute,
+		1 * time.Minute,
+	},
+	Bonus: []time.Duration{
+		5 * time.Minute,
+	},
+}
+
+// Event represents the change on the level. Level is the new level, the rest
+// of the fields are set according to the Type and point to the items of the new level
+type Event struct {
+	Type   EventType
+	Level  *Level
+	Help   *HelpInfo
+	Sector *SectorInfo
+	Bonus  *BonusInfo
+	Action *MixedActionInfo
+	// Remaining time left till the end of the level for TimeoutApproaching event,
+	// till the end of the bonus for BonusExpiring event or till the hint for
+	// HelpApproaching event
+	Remaining time.Duration
+}
+
+// Diff compares two states of the level and returns events that happened between
+// them. Items of the level are matched by their ids, so items can be added or
+// removed. If the level is changed, the only LevelChanged event is returned
+func Diff(old *Level, new *Level) []Event {
+	return DiffWith(old, new, DefaultCheckpoints)
+}
+
+// DiffWith works as Diff, but events about approaching deadlines are emitted
+// according to the provided checkpoints
+func DiffWith(old *Level, new *Level, checkpoints Checkpoints) (events []Event) {
+	if new == nil {
+		return nil
+	}
+	if old == nil || old.LevelID != new.LevelID {
+		return []Event{{Type: LevelChanged, Level: new}}
+	}
+
+	events = append(events, diffHelps(old, new, checkpoints.Help)...)
+	events = append(events, diffSectors(old, new)...)
+	events = append(events, diffBonuses(old, new, checkpoints.Bonus)...)
+	events = append(events, diffActions(old, new)...)
+
+	switch oldBlocked, newBlocked := isBlocked(old), isBlocked(new); {
+	case !oldBlocked && newBlocked:
+		events = append(events, Event{Type: BlockStarted, Level: new})
+	case oldBlocked && !newBlocked:
+		events = append(events, Event{Type: BlockEnded, Level: new})
+	}
+
+	if new.Timeout > 0 {
+		var (
+			oldRemaining = old.TimeoutSecondsRemain * time.Second
+			newRemaining = new.TimeoutSecondsRemain * time.Second
+		)
+		if crossed(oldRemaining, newRemaining, checkpoints.Timeout) {
+			events = append(events, Event{Type: TimeoutApproaching, Level: new, Remaining: newRemaining})
+		}
+	}
+	return
+}
+
+// crossed returns true if remaining time passed any of the checkpoints
+func crossed(oldRemaining time.Duration, newRemaining time.Duration, checkpoints []time.Duration) bool {
+	if newRemaining <= 0 {
+		return false
+	}
+	for _, checkpoint := range checkpoints {
+		if oldRemaining > checkpoint && newRemaining <= checkpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlocked(level *Level) bool {
+	return level.HasAnswerBlockRule && level.BlockDuration > 0
+}
+
+func diffHelps(old *Level, new *Level, checkpoints []time.Duration) (events []Event) {
+	var helps = map[int]*HelpInfo{}
+	for i := range old.Helps {
+		helps[old.Helps[i].HelpID] = &old.Helps[i]
+	}
+	for i, help := range new.Helps {
+		oldHelp, exist := helps[help.HelpID]
+		switch {
+		case help.HelpText != "" && (!exist || oldHelp.HelpText == ""):
+			events = append(events, Event{Type: HelpOpened, Level: new, Help: &new.Helps[i]})
+		case help.HelpText == "" && exist &&
+			crossed(oldHelp.RemainSeconds*time.Second, help.RemainSeconds*time.Second, checkpoints):
+			events = append(events, Event{Type: HelpApproaching, Level: new, Help: &new.Helps[i],
+				Remaining: help.RemainSeconds * time.Second})
+		}
+	}
+
+	var opened = map[int]bool{}
+
+	for _, help := range old.PenaltyHelps {
+		opened[help.HelpID] = help.HelpText != ""
+	}
+	for i, help := range new.PenaltyHelps {
+		if help.HelpText != "" && !opened[help.HelpID] {
+			events = append(events, Event{Type: PenaltyHelpOpened, Level: new, Help: &new.PenaltyHelps[i]})
+		}
+	}
+	return
+}
+
+func diffSectors(old *Level, new *Level) (events []Event) {
+	var answered = map[int32]bool{}
+	for _, sector := range old.Sectors {
+		answered[sector.SectorId] = sector.IsAnswered
+	}
+	for i, sector := range new.Sectors {
+		if sector.IsAnswered && !answered[sector.SectorId] {
+			events = append(events, Event{Type: SectorClosed, Level: new, Sector: &new.Sectors[i]})
+		}
+	}
+	return
+}
+
+func diffBonuses(old *Level, new *Level, checkpoints []time.Duration) (events []Event) {
+	var bonuses = map[int32]*BonusInfo{}
+	for i := range old.Bonuses {
+		bonuses[old.Bonuses[i].BonusId] = &old.Bonuses[i]
+	}
+	for i, bonus := range new.Bonuses {
+		var event = Event{Level: new, Bonus: &new.Bonuses[i]}
+
+		oldBonus, exist := bonuses[bonus.BonusId]
+		switch {
+		case bonus.IsAnswered && (!exist || !oldBonus.IsAnswered):
+			event.Type = BonusClosed
+		case bonus.Expired && exist && !oldBonus.Expired:
+			event.Type = BonusExpired
+		case bonus.IsAnswered || bonus.Expired || bonus.SecondsToStart > 0:
+			continue
+		case !exist || oldBonus.SecondsToStart > 0:
+			event.Type = BonusAppeared
+		case crossed(oldBonus.SecondsLeft*time.Second, bonus.SecondsLeft*time.Second, checkpoints):
+			event.Type = BonusExpiring
+			event.Remaining = bonus.SecondsLeft * time.Second
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	return
+}
+
+func diffActions(old *Level, new *Level) (events []Event) {
+	var known = map[int]bool{}
+	for _, action := range old.MixedActions {
+		known[action.ActionID] = true
+	}
+	// engine sends the latest actions first, events are emitted in the order
+	// codes were entered
+	for i := len(new.MixedActions) - 1; i >= 0; i-- {
+		if !known[new.MixedActions[i].ActionID] {
+			events = append(events, Event{Type: CodeEntered, Level: new, Action: &new.MixedActions[i]})
+		}
+	}
+	return
+}
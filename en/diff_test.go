@@ -0,0 +1,179 @@
+package en_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bonya_bot/en"
+)
+
+func diffTestLevel() *en.Level {
+	return &en.Level{
+		LevelID:              100,
+		Number:               1,
+		Timeout:              7200,
+		TimeoutSecondsRemain: 3700,
+		Sectors: en.LevelSectors{
+			{SectorId: 1, Name: "Sector 1"},
+			{SectorId: 2, Name: "Sector 2"},
+		},
+		Helps: en.LevelHelps{
+			{HelpID: 20, Number: 1, RemainSeconds: 600},
+			{HelpID: 21, Number: 2, RemainSeconds: 1200},
+		},
+		PenaltyHelps: en.LevelPenaltyHelps{
+			{HelpID: 30, Number: 1, IsPenalty: true},
+		},
+		Bonuses: en.LevelBonuses{
+			{BonusId: 10, Number: 1, Name: "Bonus 1"},
+			{BonusId: 11, Number: 2, Name: "Bonus 2", SecondsToStart: 60},
+		},
+		MixedActions: en.LevelMixedActions{
+			{ActionID: 2, Answer: "two"},
+			{ActionID: 1, Answer: "one"},
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	for _, example := range []struct {
+		name     string
+		old      *en.Level
+		update   func(level *en.Level)
+		expected []en.EventType
+	}{
+		{"no changes", diffTestLevel(), func(level *en.Level) {}, nil},
+		{"first level", nil, func(level *en.Level) {}, []en.EventType{en.LevelChanged}},
+		{"level changed", diffTestLevel(), func(level *en.Level) {
+			level.LevelID = 101
+			level.Sectors[0].IsAnswered = true
+		}, []en.EventType{en.LevelChanged}},
+		{"help opened", diffTestLevel(), func(level *en.Level) {
+			level.Helps[1].HelpText = "hint"
+		}, []en.EventType{en.HelpOpened}},
+		{"helps reordered", diffTestLevel(), func(level *en.Level) {
+			level.Helps = en.LevelHelps{{HelpID: 21, Number: 2}, {HelpID: 20, Number: 1, HelpText: "hint"}}
+		}, []en.EventType{en.HelpOpened}},
+		{"new help", diffTestLevel(), func(level *en.Level) {
+			level.Helps = append(level.Helps, en.HelpInfo{HelpID: 22, Number: 3, HelpText: "hint"})
+		}, []en.EventType{en.HelpOpened}},
+		{"penalty help opened", diffTestLevel(), func(level *en.Level) {
+			level.PenaltyHelps[0].HelpText = "penalty hint"
+		}, []en.EventType{en.PenaltyHelpOpened}},
+		{"sector closed", diffTestLevel(), func(level *en.Level) {
+			level.Sectors[1].IsAnswered = true
+		}, []en.EventType{en.SectorClosed}},
+		{"sectors removed", diffTestLevel(), func(level *en.Level) {
+			level.Sectors = level.Sectors[:1]
+		}, nil},
+		{"bonus closed", diffTestLevel(), func(level *en.Level) {
+			level.Bonuses[0].IsAnswered = true
+		}, []en.EventType{en.BonusClosed}},
+		{"bonus started", diffTestLevel(), func(level *en.Level) {
+			level.Bonuses[1].SecondsToStart = 0
+		}, []en.EventType{en.BonusAppeared}},
+		{"new bonus", diffTestLevel(), func(level *en.Level) {
+			level.Bonuses = append(level.Bonuses, en.BonusInfo{BonusId: 12, Number: 3})
+		}, []en.EventType{en.BonusAppeared}},
+		{"bonus expiring", func() *en.Level {
+			level := diffTestLevel()
+			level.Bonuses[0].SecondsLeft = 310
+			return level
+		}(), func(level *en.Level) {
+			level.Bonuses[0].SecondsLeft = 290
+		}, []en.EventType{en.BonusExpiring}},
+		{"bonus expired", diffTestLevel(), func(level *en.Level) {
+			level.Bonuses[0].Expired = true
+		}, []en.EventType{en.BonusExpired}},
+		{"codes entered", diffTestLevel(), func(level *en.Level) {
+			level.MixedActions = append(en.LevelMixedActions{
+				{ActionID: 4, Answer: "four"}, {ActionID: 3, Answer: "three"}}, level.MixedActions...)
+		}, []en.EventType{en.CodeEntered, en.CodeEntered}},
+		{"block started", diffTestLevel(), func(level *en.Level) {
+			level.HasAnswerBlockRule, level.BlockDuration = true, 30
+		}, []en.EventType{en.BlockStarted}},
+		{"block ended", func() *en.Level {
+			level := diffTestLevel()
+			level.HasAnswerBlockRule, level.BlockDuration = true, 30
+			return level
+		}(), func(level *en.Level) {
+			level.HasAnswerBlockRule, level.BlockDuration = true, 0
+		}, []en.EventType{en.BlockEnded}},
+		{"timeout approaching", diffTestLevel(), func(level *en.Level) {
+			level.TimeoutSecondsRemain = 3590
+		}, []en.EventType{en.TimeoutApproaching}},
+		{"timeout not approaching", diffTestLevel(), func(level *en.Level) {
+			level.TimeoutSecondsRemain = 3650
+		}, nil},
+		{"several changes", diffTestLevel(), func(level *en.Level) {
+			level.Sectors[0].IsAnswered = true
+			level.Helps[0].HelpText = "hint"
+			level.MixedActions = append(en.LevelMixedActions{{ActionID: 3, Answer: "code"}}, level.MixedActions...)
+		}, []en.EventType{en.HelpOpened, en.SectorClosed, en.CodeEntered}},
+	} {
+		var new = diffTestLevel()
+		example.update(new)
+
+		var types []en.EventType
+		for _, event := range en.Diff(example.old, new) {
+			types = append(types, event.Type)
+		}
+		if !reflect.DeepEqual(types, example.expected) {
+			t.Errorf("%s: expected events %v, got %v", example.name, example.expected, types)
+		}
+	}
+}
+
+func TestDiffEventItems(t *testing.T) {
+	var (
+		old = diffTestLevel()
+		new = diffTestLevel()
+	)
+	new.Helps[1].HelpText = "hint"
+	new.Sectors[1].IsAnswered = true
+	new.TimeoutSecondsRemain = 290
+	new.MixedActions = append(en.LevelMixedActions{
+		{ActionID: 4, Answer: "four"}, {ActionID: 3, Answer: "three"}}, new.MixedActions...)
+
+	events := en.Diff(old, new)
+	if len(events) != 5 {
+		t.Fatalf("Expected 5 events, got %d", len(events))
+	}
+	if events[0].Help != &new.Helps[1] || events[1].Sector != &new.Sectors[1] {
+		t.Errorf("Expected events to point to the items of the new level")
+	}
+	if events[2].Action.Answer != "three" || events[3].Action.Answer != "four" {
+		t.Errorf("Expected codes in the order they were entered, got %q, %q",
+			events[2].Action.Answer, events[3].Action.Answer)
+	}
+	if events[4].Type != en.TimeoutApproaching || events[4].Remaining != 290*time.Second {
+		t.Errorf("Expected timeout approaching with %s remaining, got %s %s",
+			290*time.Second, events[4].Type, events[4].Remaining)
+	}
+}
+
+func TestDiffWith(t *testing.T) {
+	var (
+		old         = diffTestLevel()
+		new         = diffTestLevel()
+		checkpoints = en.Checkpoints{Help: []time.Duration{5 * time.Minute}}
+	)
+	new.TimeoutSecondsRemain = 290
+	new.Helps[0].RemainSeconds = 240
+	new.Helps[1].RemainSeconds = 900
+
+	events := en.DiffWith(old, new, checkpoints)
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	if events[0].Type != en.HelpApproaching || events[0].Help != &new.Helps[0] ||
+		events[0].Remaining != 240*time.Second {
+		t.Errorf("Expected help approaching for hint 1 with %s remaining, got %s %s",
+			240*time.Second, events[0].Type, events[0].Remaining)
+	}
+
+	if events = en.Diff(old, new); len(events) != 1 || events[0].Type != en.TimeoutApproaching {
+		t.Errorf("Expected only timeout approaching with default checkpoints, got %v", events)
+	}
+}
@@ -0,0 +1,449 @@
+// Package entest provides fake Encounter engine for the tests. Engine serves the same
+// endpoints as the real one (login, level information and codes), and the state of the
+// game is changed by the test, so that different scenarios can be checked without network.
+package entest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonya_bot/en"
+)
+
+const (
+	sessionCookie = "GUID"
+
+	// LoginPage page that is returned by the engine when session is expired
+	LoginPage = `<html><body><form action="/Login.aspx">Login</form></body></html>`
+)
+
+type levelCodes struct {
+	sectors map[string]int32
+	bonuses map[string]int32
+	// penaltyHelps texts of the penalty hints by their ids
+	penaltyHelps map[int]string
+}
+
+// Engine fake EN engine on top of httptest.Server
+type Engine struct {
+	*httptest.Server
+
+	// Login and Password of the only player that can login to the engine
+	Login    string
+	Password string
+	// GameID id of the game that is served by the engine
+	GameID int32
+
+	mu            sync.Mutex
+	levels        []en.Level
+	codes         []levelCodes
+	current       int
+	sessions      map[string]bool
+	lastSession   int
+	lastAction    int
+	failures      []int
+	event         int8
+	delay         time.Duration
+	levelRequests int
+	loginRequests int
+}
+
+// NewEngine starts new fake engine, engine should be closed by the caller
+func NewEngine(login, password string, gameID int32) *Engine {
+	var engine = &Engine{
+		Login:    login,
+		Password: password,
+		GameID:   gameID,
+		sessions: make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/signin", engine.handleLogin)
+	mux.HandleFunc(fmt.Sprintf("/GameEngines/Encounter/Play/%d", gameID), engine.handlePlay)
+	engine.Server = httptest.NewServer(mux)
+	return engine
+}
+
+// Domain returns domain that should be used in en.API to connect to the engine
+func (e *Engine) Domain() string {
+	return strings.TrimPrefix(e.URL, "http://")
+}
+
+// AddLevel adds level to the end of the game. LevelID and Number are set
+// automatically if they are empty
+func (e *Engine) AddLevel(level en.Level) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if level.Number == 0 {
+		level.Number = int8(len(e.levels) + 1)
+	}
+	if level.LevelID == 0 {
+		level.LevelID = int32(100 + len(e.levels) + 1)
+	}
+	if len(level.Tasks) == 0 {
+		level.Tasks = en.LevelTasks{{}}
+	}
+	e.levels = append(e.levels, level)
+	e.codes = append(e.codes, levelCodes{map[string]int32{}, map[string]int32{}, map[int]string{}})
+}
+
+// AddSectorCode sets the code that closes the sector on the level
+func (e *Engine) AddSectorCode(levelNumber int8, sectorID int32, code string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.codes[levelNumber-1].sectors[strings.ToLower(code)] = sectorID
+}
+
+// AddBonusCode sets the code that closes the bonus on the level
+func (e *Engine) AddBonusCode(levelNumber int8, bonusID int32, code string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.codes[levelNumber-1].bonuses[strings.ToLower(code)] = bonusID
+}
+
+// AddPenaltyHelp adds penalty hint to the level, text of the hint is sent only
+// after the hint is requested
+func (e *Engine) AddPenaltyHelp(levelNumber int8, help en.HelpInfo, text string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	help.IsPenalty = true
+	help.HelpText = ""
+	e.levels[levelNumber-1].PenaltyHelps = append(e.levels[levelNumber-1].PenaltyHelps, help)
+	e.codes[levelNumber-1].penaltyHelps[help.HelpID] = text
+}
+
+// Update changes the current level with the provided function
+func (e *Engine) Update(update func(level *en.Level)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	update(&e.levels[e.current])
+}
+
+// CurrentLevel returns a copy of the current level
+func (e *Engine) CurrentLevel() en.Level {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.levels[e.current]
+}
+
+// OpenHelp opens the hint with the number on the current level
+func (e *Engine) OpenHelp(number int8, text string) {
+	e.Update(func(level *en.Level) {
+		for i := range level.Helps {
+			if level.Helps[i].Number == number {
+				level.Helps[i].HelpText = text
+				level.Helps[i].RemainSeconds = 0
+			}
+		}
+	})
+}
+
+// CloseSector closes the sector on the current level as if it was answered by
+// another player
+func (e *Engine) CloseSector(sectorID int32, answer string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closeSector(&e.levels[e.current], sectorID, answer)
+}
+
+// LevelUp moves the game to the next level
+func (e *Engine) LevelUp() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.levelUp()
+}
+
+// Block sets block for incorrect codes on the current level
+func (e *Engine) Block(duration time.Duration) {
+	e.Update(func(level *en.Level) {
+		level.HasAnswerBlockRule = true
+		level.BlockDuration = duration
+	})
+}
+
+// Tick decreases the time left for the level and hints
+func (e *Engine) Tick(d time.Duration) {
+	e.Update(func(level *en.Level) {
+		seconds := time.Duration(d.Seconds())
+		level.TimeoutSecondsRemain -= seconds
+		for i := range level.Helps {
+			if level.Helps[i].RemainSeconds > 0 {
+				level.Helps[i].RemainSeconds -= seconds
+			}
+		}
+	})
+}
+
+// ExpireSessions drops all sessions, so that engine returns login page instead
+// of level information until player logs in again
+func (e *Engine) ExpireSessions() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sessions = make(map[string]bool)
+}
+
+// FailNext makes engine to respond with the status code and html page to the
+// next n requests
+func (e *Engine) FailNext(status int, n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := 0; i < n; i++ {
+		e.failures = append(e.failures, status)
+	}
+}
+
+// SetDelay sets the time engine waits before it responds
+func (e *Engine) SetDelay(delay time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.delay = delay
+}
+
+// SetEvent sets the `Event` field of the game response, e.g. 5 - game is not started,
+// 6 - game is finished. Level is not sent for non zero event
+func (e *Engine) SetEvent(event int8) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.event = event
+}
+
+// LoginRequests returns number of login attempts, including failed ones
+func (e *Engine) LoginRequests() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.loginRequests
+}
+
+// LevelRequests returns the number of requests for level information
+func (e *Engine) LevelRequests() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.levelRequests
+}
+
+func (e *Engine) levelUp() {
+	e.levels[e.current].IsPassed = true
+	if e.current < len(e.levels)-1 {
+		e.current++
+	}
+}
+
+func (e *Engine) closeSector(level *en.Level, sectorID int32, answer string) {
+	for i := range level.Sectors {
+		if level.Sectors[i].SectorId == sectorID && !level.Sectors[i].IsAnswered {
+			level.Sectors[i].IsAnswered = true
+			level.Sectors[i].Answer = map[string]interface{}{"Answer": answer, "Login": e.Login}
+			level.PassedSectorsCount++
+			level.SectorsLeftToClose--
+		}
+	}
+	if len(level.Sectors) > 0 && level.SectorsLeftToClose <= 0 {
+		e.levelUp()
+	}
+}
+
+func (e *Engine) closeBonus(level *en.Level, bonusID int32, answer string) {
+	for i := range level.Bonuses {
+		if level.Bonuses[i].BonusId == bonusID && !level.Bonuses[i].IsAnswered {
+			level.Bonuses[i].IsAnswered = true
+			level.Bonuses[i].Answer = map[string]interface{}{"Answer": answer, "Login": e.Login}
+		}
+	}
+}
+
+func (e *Engine) addAction(level *en.Level, kind en.MixedActionKind, answer string, correct bool) {
+	e.lastAction++
+	action := en.MixedActionInfo{
+		ActionID:    e.lastAction,
+		LevelID:     int(level.LevelID),
+		LevelNumber: level.Number,
+		Kind:        kind,
+		Login:       e.Login,
+		Answer:      answer,
+		IsCorrect:   correct,
+	}
+	// Engine returns the latest actions first
+	level.MixedActions = append(en.LevelMixedActions{action}, level.MixedActions...)
+}
+
+// fail returns true if request was processed as a failure
+func (e *Engine) fail(w http.ResponseWriter) bool {
+	e.mu.Lock()
+	var (
+		delay  = e.delay
+		status int
+	)
+	if len(e.failures) > 0 {
+		status, e.failures = e.failures[0], e.failures[1:]
+	}
+	e.mu.Unlock()
+
+	time.Sleep(delay)
+	if status == 0 {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, "<html><body>Server error</body></html>")
+	return true
+}
+
+func (e *Engine) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var credentials struct{ Login, Password string }
+
+	e.mu.Lock()
+	e.loginRequests++
+	e.mu.Unlock()
+	if e.fail(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &credentials); err != nil ||
+		credentials.Login != e.Login || credentials.Password != e.Password {
+		// 2 - incorrect login or password
+		fmt.Fprint(w, `{"Error":2,"Message":"Incorrect login or password"}`)
+		return
+	}
+
+	e.mu.Lock()
+	e.lastSession++
+	session := fmt.Sprintf("session-%d", e.lastSession)
+	e.sessions[session] = true
+	e.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: session, Path: "/"})
+	fmt.Fprint(w, `{"Error":0,"Message":""}`)
+}
+
+func (e *Engine) authorized(r *http.Request) bool {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.sessions[cookie.Value]
+}
+
+func (e *Engine) handlePlay(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		e.mu.Lock()
+		e.levelRequests++
+		e.mu.Unlock()
+	}
+	if e.fail(w) {
+		return
+	}
+	if !e.authorized(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, LoginPage)
+		return
+	}
+	if r.Method == "POST" {
+		e.handleCode(r)
+	}
+	if r.URL.Query().Get("pact") == "1" {
+		e.handlePenaltyHelp(r)
+	}
+	e.writeGame(w)
+}
+
+func (e *Engine) handleCode(r *http.Request) {
+	var payload map[string]interface{}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var (
+		level = &e.levels[e.current]
+		codes = e.codes[e.current]
+	)
+	if levelID, ok := payload["LevelId"].(float64); !ok || int32(levelID) != level.LevelID {
+		return
+	}
+	if code, ok := payload["LevelAction.Answer"].(string); ok {
+		if level.HasAnswerBlockRule && level.BlockDuration > 0 {
+			return
+		}
+		sectorID, correct := codes.sectors[strings.ToLower(code)]
+		e.addAction(level, en.LevelAnswer, code, correct)
+		if correct {
+			e.closeSector(level, sectorID, code)
+		}
+	}
+	if code, ok := payload["BonusAction.Answer"].(string); ok {
+		bonusID, correct := codes.bonuses[strings.ToLower(code)]
+		e.addAction(level, en.BonusAnswer, code, correct)
+		if correct {
+			e.closeBonus(level, bonusID, code)
+		}
+	}
+}
+
+func (e *Engine) handlePenaltyHelp(r *http.Request) {
+	helpID, err := strconv.Atoi(r.URL.Query().Get("pid"))
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	level := &e.levels[e.current]
+	for i := range level.PenaltyHelps {
+		help := &level.PenaltyHelps[i]
+		if help.HelpID == helpID && help.RemainSeconds == 0 {
+			help.HelpText = e.codes[e.current].penaltyHelps[helpID]
+			help.PenaltyHelpState = en.Opened
+		}
+	}
+}
+
+func (e *Engine) writeGame(w http.ResponseWriter) {
+	e.mu.Lock()
+	var (
+		levels = en.LevelsList{}
+		level  = e.levels[e.current]
+	)
+	for _, l := range e.levels {
+		levels = append(levels, en.ShortLevelInfo{
+			LevelID:     l.LevelID,
+			LevelNumber: l.Number,
+			LevelName:   l.Name,
+			IsPassed:    l.IsPassed,
+			Dismissed:   l.Dismissed,
+		})
+	}
+	game := en.GameResponse{
+		Level:  &level,
+		Levels: &levels,
+		GameID: int(e.GameID),
+		Event:  e.event,
+	}
+	if e.event != 0 {
+		game.Level = nil
+	}
+	body, err := json.Marshal(game)
+	e.mu.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(body)
+}
@@ -0,0 +1,111 @@
+package en
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Error represents an error returned by EN engine. Code is the numeric code
+// that engine sends: `Error` field of the login response, `Event` field of the
+// game response, or http status code when engine responds with html page.
+// Codes from different sources can overlap, so errors should be compared with
+// the exported values below, e.g. `errors.Is(err, en.ErrNotAuthenticated)`
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Errors returned on login
+var (
+	// ErrCaptchaRequired engine requires captcha, usually after several failed logins
+	ErrCaptchaRequired = &Error{captcha, "Captcha input is required"}
+	// ErrIncorrectLogin incorrect login or password
+	ErrIncorrectLogin = &Error{incorrectLogin, "Incorrect login/password"}
+	// ErrIncorrectUser user is blocked or doesn't exist on this domain
+	ErrIncorrectUser = &Error{incorrectUser, "Incorrect user"}
+	// ErrIPBlacklisted login is not allowed from the ip of the bot
+	ErrIPBlacklisted = &Error{ipBlacklisted, "IP is blacklisted"}
+	// ErrServerFault engine failed to process login
+	ErrServerFault = &Error{serverFault, "Server fault"}
+	// ErrBruteForce too many login attempts
+	ErrBruteForce = &Error{bruteForce, "Too many login attempts"}
+)
+
+// Errors returned on game requests
+var (
+	// ErrGameNotFound game with the id doesn't exist on the domain
+	ErrGameNotFound = &Error{gameNotFound, "Game doesn't exist"}
+	// ErrNotAuthenticated session is expired or player is not logged in, engine
+	// responds with login page in this case
+	ErrNotAuthenticated = &Error{notAuthenticated, "Incorrect cookies, need to re-login"}
+	// ErrGameNotStarted game is not started yet
+	ErrGameNotStarted = &Error{gameNotStarted, "Game is not started yet"}
+	// ErrGameFinished game is over
+	ErrGameFinished = &Error{gameFinished, "Game is finished"}
+	// ErrNoLevelInfo response doesn't contain level information
+	ErrNoLevelInfo = &Error{noLevelInfo, "No level info"}
+	// ErrServerTimeout engine didn't respond in time
+	ErrServerTimeout = &Error{http.StatusGatewayTimeout, "Timeout on server"}
+)
+
+const (
+	captcha = iota + 1
+	incorrectLogin
+	incorrectUser
+	ipBlacklisted
+	serverFault
+	bruteForce
+)
+
+// Values of the `Event` field in the game response
+const (
+	gameNotFound     = 2
+	notAuthenticated = 4
+	gameNotStarted   = 5
+	gameFinished     = 6
+	noLevelInfo      = 12
+	gameOver         = 17
+)
+
+// ServerError map with possible login errors from EN server
+var ServerError = map[int32]*Error{
+	captcha:        ErrCaptchaRequired,
+	incorrectLogin: ErrIncorrectLogin,
+	incorrectUser:  ErrIncorrectUser,
+	ipBlacklisted:  ErrIPBlacklisted,
+	serverFault:    ErrServerFault,
+	bruteForce:     ErrBruteForce,
+}
+
+// eventErrors map with the game events that don't allow to get level information
+var eventErrors = map[int8]*Error{
+	gameNotFound:     ErrGameNotFound,
+	notAuthenticated: ErrNotAuthenticated,
+	gameNotStarted:   ErrGameNotStarted,
+	gameFinished:     ErrGameFinished,
+	noLevelInfo:      ErrNoLevelInfo,
+	gameOver:         ErrGameFinished,
+}
+
+// loginError returns error for the code from the login response
+func loginError(code int32) error {
+	if err, ok := ServerError[code]; ok {
+		return err
+	}
+	return &Error{int(code), "Unknown login error"}
+}
+
+// httpError returns error for the html page that engine sent instead of json
+func httpError(statusCode int) error {
+	switch {
+	case statusCode == http.StatusGatewayTimeout:
+		return ErrServerTimeout
+	case statusCode >= http.StatusInternalServerError:
+		return &Error{statusCode, http.StatusText(statusCode)}
+	}
+	return ErrNotAuthenticated
+}
@@ -5,7 +5,7 @@ type GameResponse struct {
 	Level         *Level
 	Levels        *LevelsList
 	EngineActions interface{} `json:"-"`
-	Event         int8        `json:"-"`
+	Event         int8
 
 	GameID        int  `json:"GameId"`
 	GameTypeID    int8 `json:"GameTypeId"`
@@ -27,7 +27,7 @@ func TestBlockTypeToString(t *testing.T) {
 
 // =================================================================================== //
 
-func TestReplaceCoordinates(t *testing.T) {
+func TestExtractCoordinates(t *testing.T) {
 	var examples = []struct {
 		input    string
 		expected string
@@ -41,7 +41,7 @@ func TestReplaceCoordinates(t *testing.T) {
 
 	//"49.976136, 36.267256"
 	for _, ex := range examples {
-		if res, _ := ReplaceCoordinates(ex.input); res != ex.expected {
+		if res, _ := ExtractCoordinates(ex.input); res != ex.expected {
 			t.Errorf("For %q\nExpected %q\nGot      %q",
 				ex.input, ex.expected, res)
 		}
@@ -3,14 +3,10 @@ package en
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
-	"os"
-	"path"
-	"strconv"
 	"strings"
 	"time"
 
@@ -84,21 +80,53 @@ func NewLevel(response *http.Response) *Level {
 		log.Println("ERROR: failed to parse level json:", err)
 		return &Level{}
 	}
+	if gameResponse.Level == nil {
+		// Engine doesn't send level when game is not started or is already finished,
+		// `Event` field of the response contains the reason
+		gameResponse.Level = &Level{}
+	}
 	gameResponse.Level.Parent = gameResponse
 
 	return gameResponse.Level
 }
 
-// ProcessText process the initial task text that is received from the server:
+// ProcessText process the initial texts of the task, opened hints and bonuses that are
+// received from the server:
 // - extracts some useful information like coordinates where to go, or images
 // - removes all html tags and leaves just the text
 func (li *Level) ProcessText() {
-	li.Tasks[0].TaskText, li.Coords = ExtractCoordinates(li.GetLevelTask())
-	li.Tasks[0].TaskText, li.Images = ExtractImages(li.GetLevelTask(), "Картинка")
-	// li.Tasks[0].TaskText = ReplaceCommonTags(li.Tasks[0].TaskText)
+	li.GetLevelTask()
+	for i := range li.Helps {
+		li.Helps[i].ProcessText()
+	}
+	for i := range li.PenaltyHelps {
+		li.PenaltyHelps[i].ProcessText()
+	}
+	for i := range li.Bonuses {
+		li.Bonuses[i].ProcessText()
+	}
+}
+
+// AllCoords returns coordinates of the task and of the hints and bonuses that were
+// processed already, coordinates are not repeated
+func (li *Level) AllCoords() Coordinates {
+	var coords = Coordinates{}.Add(li.Coords...)
+	for _, help := range li.Helps {
+		coords = coords.Add(help.Coords...)
+	}
+	for _, help := range li.PenaltyHelps {
+		coords = coords.Add(help.Coords...)
+	}
+	for _, bonus := range li.Bonuses {
+		coords = coords.Add(bonus.Coords...)
+	}
+	return coords
 }
 
 func (li *Level) getTask() string {
+	if len(li.Tasks) == 0 {
+		return ""
+	}
 	return li.Tasks[0].TaskText
 }
 
@@ -138,48 +166,12 @@ func (li *Level) GetLevelTask() string {
 	result := li.getTask()
 	result, li.Coords = ExtractCoordinates(result)
 	result, li.Images = ExtractImages(result, "Картинка")
-	result = ReplaceHTMLTags(result)
+	result = Render(result, Markdown)
 	// log.Printf("[INFO] Parsed text %s", result)
 	li.ProcessedText = result
-	// TODO: uncomment
-	// li.DownloadImages()
 	return result
 }
 
-// DownloadImages downloads all images that were found in text in order to send them to chat
-func (li *Level) DownloadImages() {
-	log.Printf("[INFO] Downloading images %d", len(li.Images))
-	downloadPath := path.Join("/tmp", "quest", strconv.Itoa(li.Parent.GameID), strconv.Itoa(int(li.Number)))
-	if _, err := os.Stat(downloadPath); os.IsNotExist(err) {
-		log.Printf("[INFO] Creating directory for level %d", li.Number)
-		os.MkdirAll(downloadPath, 0755)
-	}
-	for idx, image := range li.Images {
-		fileName := path.Join(downloadPath, path.Base(image.URL))
-		if _, err := os.Stat(fileName); os.IsNotExist(err) {
-			log.Printf("[INFO] Download file %s to temporary directory %s", fileName, downloadPath)
-			response, err := http.Get(image.URL)
-			if err != nil {
-				log.Println("[ERROR] Can't download image:", err)
-				continue
-			}
-			file, err := os.Create(fileName)
-			if err != nil {
-				log.Fatal("[ERROR] Cannot create file:", err)
-				continue
-			}
-			// Use io.Copy to just dump the response body to the file. This supports huge files
-			defer response.Body.Close()
-			defer file.Close()
-			_, err = io.Copy(file, response.Body)
-			if err != nil {
-				log.Printf("[ERORR] %s", err)
-			}
-		}
-		li.Images[idx].Filepath = fileName
-	}
-}
-
 // ToText - deprecated
 func (li *Level) ToText() (result string) {
 	var (
@@ -190,8 +182,6 @@ func (li *Level) ToText() (result string) {
 	//log.Printf("After coordinates: %s", task)
 	//task = ReplaceImages(task, "Картинка")
 	//log.Printf("After images: %s", task)
-	//task = ReplaceCommonTags(task)
-	//log.Printf("After tags: %s", task)
 
 	if li.HasAnswerBlockRule {
 		block = fmt.Sprintf("Есть"+LevelBlockInfoString, "=", BlockTypeToString(li.BlockTargetID),
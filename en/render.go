@@ -0,0 +1,478 @@
+package en
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Format defines the markup of the text rendered from the task HTML
+type Format int8
+
+const (
+	// Plain text without any markup
+	Plain Format = iota
+	// Markdown legacy Telegram Markdown, entities can't be nested
+	Markdown
+	// MarkdownV2 Telegram MarkdownV2
+	MarkdownV2
+	// HTML Telegram HTML parse mode
+	HTML
+)
+
+// style text style that is supported by Telegram
+type style int8
+
+const (
+	noStyle style = iota
+	bold
+	italic
+	underline
+	strike
+	code
+	pre
+	link
+)
+
+// tagStyles styles of the html tags, tags that are not listed here are rendered
+// as the text they contain
+var tagStyles = map[string]style{
+	"b":      bold,
+	"strong": bold,
+	"h1":     bold,
+	"h2":     bold,
+	"h3":     bold,
+	"h4":     bold,
+	"h5":     bold,
+	"h6":     bold,
+	"i":      italic,
+	"em":     italic,
+	"u":      underline,
+	"ins":    underline,
+	"s":      strike,
+	"strike": strike,
+	"del":    strike,
+	"code":   code,
+	"pre":    pre,
+	"a":      link,
+}
+
+// blockTags tags that start the new line and are followed by the new line
+var blockTags = map[string]bool{
+	"p": true, "div": true, "center": true, "blockquote": true, "table": true,
+	"ul": true, "ol": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"pre": true,
+}
+
+// skippedTags tags whose content is invisible for the user
+var skippedTags = map[string]bool{"script": true, "style": true, "head": true, "title": true}
+
+// voidTags tags that don't have the closing tag
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true, "input": true, "meta": true, "link": true, "wbr": true}
+
+var (
+	markdownV2Special = "_*[]()~`>#+-=|{}.!\\"
+	emptyLinesRe      = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+\n`)
+	spacesBeforeNlRe  = regexp.MustCompile(`[ \t]+\n`)
+)
+
+// element html tag that is being rendered, its content is collected into text
+// until the tag is closed
+type element struct {
+	tag   string
+	style style
+	href  string
+	text  strings.Builder
+	// items number of list items or table cells rendered inside the element
+	items int
+}
+
+// renderer converts html into text with the markup of the format
+type renderer struct {
+	format Format
+	stack  []*element
+	skip   int
+	// lineBreak is true right after <br>
+	lineBreak bool
+}
+
+// Render converts the html text of the task, hint or bonus into the text that can
+// be sent to Telegram with the parse mode of the format. Tags that are not supported
+// by Telegram are removed, line breaks, paragraphs, lists and tables are rendered as
+// text. Special symbols of the format are escaped
+func Render(text string, format Format) string {
+	var (
+		r         = &renderer{format: format, stack: []*element{{}}}
+		tokenizer = html.NewTokenizer(strings.NewReader(text))
+	)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			for len(r.stack) > 1 {
+				r.close()
+			}
+			return r.result()
+		case html.TextToken:
+			r.text(tokenizer.Token().Data)
+		case html.StartTagToken:
+			r.start(tokenizer.Token())
+		case html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			r.start(token)
+			if !voidTags[token.Data] {
+				r.end(token.Data)
+			}
+		case html.EndTagToken:
+			r.end(tokenizer.Token().Data)
+		}
+	}
+}
+
+func (r *renderer) top() *element {
+	return r.stack[len(r.stack)-1]
+}
+
+// find returns the closest open element with the tag or nil
+func (r *renderer) find(tags ...string) *element {
+	for i := len(r.stack) - 1; i > 0; i-- {
+		for _, tag := range tags {
+			if r.stack[i].tag == tag {
+				return r.stack[i]
+			}
+		}
+	}
+	return nil
+}
+
+// entity returns the closest element that is rendered as Telegram entity
+func (r *renderer) entity() *element {
+	for i := len(r.stack) - 1; i > 0; i-- {
+		if r.stack[i].style != noStyle {
+			return r.stack[i]
+		}
+	}
+	return nil
+}
+
+// newLine starts the new line in the element unless it is already started
+func (r *renderer) newLine() {
+	var text = r.top().text.String()
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		r.top().text.WriteString("\n")
+	}
+}
+
+// paragraph separates the paragraph with the empty line
+func (r *renderer) paragraph() {
+	r.newLine()
+	if text := r.top().text.String(); text != "" && !strings.HasSuffix(text, "\n\n") {
+		r.top().text.WriteString("\n")
+	}
+}
+
+func (r *renderer) text(text string) {
+	if r.skip > 0 {
+		return
+	}
+	text = strings.Replace(text, "\u00a0", " ", -1)
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	if r.lineBreak {
+		// line break is often followed by the new line in the source of the task
+		text = strings.TrimPrefix(text, "\n")
+	}
+	r.lineBreak = false
+	r.top().text.WriteString(r.escape(text))
+}
+
+func (r *renderer) start(token html.Token) {
+	if skippedTags[token.Data] {
+		r.skip++
+		r.stack = append(r.stack, &element{tag: token.Data})
+		return
+	}
+	if r.skip > 0 {
+		return
+	}
+
+	r.lineBreak = token.Data == "br"
+	switch token.Data {
+	case "br":
+		r.top().text.WriteString("\n")
+	case "hr":
+		r.newLine()
+		r.top().text.WriteString("\n")
+	case "li":
+		r.newLine()
+		if list := r.find("ol", "ul"); list != nil {
+			list.items++
+			if list.tag == "ol" {
+				r.top().text.WriteString(r.escape(fmt.Sprintf("%d. ", list.items)))
+			} else {
+				r.top().text.WriteString(r.escape("• "))
+			}
+		}
+	case "tr":
+		r.newLine()
+	case "td", "th":
+		if row := r.find("tr"); row != nil {
+			if row.items > 0 {
+				r.top().text.WriteString(r.escape(" | "))
+			}
+			row.items++
+		}
+	}
+	if token.Data == "p" {
+		r.paragraph()
+	} else if blockTags[token.Data] {
+		r.newLine()
+	}
+	if voidTags[token.Data] {
+		return
+	}
+
+	var el = &element{tag: token.Data, style: tagStyles[token.Data]}
+	for _, attr := range token.Attr {
+		if attr.Key == "href" {
+			el.href = strings.TrimSpace(attr.Val)
+		}
+	}
+	if el.style == link && !allowedLink(el.href) {
+		el.style = noStyle
+	}
+	if r.format == Markdown && (el.style == underline || el.style == strike || r.entity() != nil) {
+		// legacy Markdown doesn't support underline, strike and nested entities,
+		// only the outer entity is kept
+		el.style = noStyle
+	}
+	if r.format != HTML && r.format != Plain {
+		if outer := r.entity(); outer != nil && (outer.style == code || outer.style == pre) {
+			el.style = noStyle
+		}
+	}
+	r.stack = append(r.stack, el)
+}
+
+// end closes the element with the tag and all elements opened inside it. Closing
+// tags without opening ones are ignored
+func (r *renderer) end(tag string) {
+	for i := len(r.stack) - 1; i > 0; i-- {
+		if r.stack[i].tag != tag {
+			continue
+		}
+		for len(r.stack) > i {
+			r.close()
+		}
+		return
+	}
+	log.Printf("[WARNING] Found closed tag %q without opening one", tag)
+}
+
+// close renders the top element into its parent
+func (r *renderer) close() {
+	var el = r.top()
+	r.stack = r.stack[:len(r.stack)-1]
+	if skippedTags[el.tag] {
+		r.skip--
+		return
+	}
+	if r.skip > 0 {
+		return
+	}
+
+	r.top().text.WriteString(r.wrap(el))
+	if blockTags[el.tag] {
+		r.newLine()
+	}
+	if el.tag == "p" {
+		r.paragraph()
+	}
+}
+
+// wrap adds the markup of the style around the text of the element. Spaces around
+// the text are moved outside of the entity and empty entities are not rendered
+func (r *renderer) wrap(el *element) string {
+	var (
+		text    = el.text.String()
+		content = strings.TrimSpace(text)
+	)
+	if el.style == pre && content != "" {
+		// indentation is the part of the preformatted text
+		content = strings.Trim(text, "\n")
+	}
+	if el.style == noStyle || r.format == Plain || content == "" {
+		if el.style == link && r.format == Plain && content != "" && content != el.href {
+			return text + " (" + el.href + ")"
+		}
+		return text
+	}
+
+	var (
+		leading  = text[:strings.Index(text, content)]
+		trailing = text[len(leading)+len(content):]
+		open     string
+		closing  string
+	)
+	switch r.format {
+	case HTML:
+		open, closing = htmlMarkup(el)
+	case MarkdownV2:
+		open, closing = markdownV2Markup(el)
+	case Markdown:
+		open, closing = markdownMarkup(el)
+	}
+	if open == "" && closing == "" {
+		return text
+	}
+	var result = open + content + closing
+	if r.format == Markdown && open == closing {
+		// symbols escaped at the start or at the end of the entity leave empty entities
+		if strings.HasPrefix(result, open+closing+"\\") {
+			result = result[len(open+closing):]
+		}
+		if strings.HasSuffix(result, "\\"+open+open+closing) {
+			result = result[:len(result)-len(open+closing)]
+		}
+	}
+	return leading + result + trailing
+}
+
+func htmlMarkup(el *element) (string, string) {
+	switch el.style {
+	case bold:
+		return "<b>", "</b>"
+	case italic:
+		return "<i>", "</i>"
+	case underline:
+		return "<u>", "</u>"
+	case strike:
+		return "<s>", "</s>"
+	case code:
+		return "<code>", "</code>"
+	case pre:
+		return "<pre>", "</pre>"
+	case link:
+		return fmt.Sprintf(`<a href="%s">`, html.EscapeString(el.href)), "</a>"
+	}
+	return "", ""
+}
+
+func markdownV2Markup(el *element) (string, string) {
+	switch el.style {
+	case bold:
+		return "*", "*"
+	case italic:
+		return "_", "_"
+	case underline:
+		return "__", "__"
+	case strike:
+		return "~", "~"
+	case code:
+		return "`", "`"
+	case pre:
+		return "```\n", "\n```"
+	case link:
+		url := strings.NewReplacer("\\", "\\\\", ")", "\\)").Replace(el.href)
+		return "[", "](" + url + ")"
+	}
+	return "", ""
+}
+
+func markdownMarkup(el *element) (string, string) {
+	switch el.style {
+	case bold:
+		return "*", "*"
+	case italic:
+		return "_", "_"
+	case code:
+		return "`", "`"
+	case pre:
+		return "```\n", "\n```"
+	case link:
+		return "[", "](" + strings.Replace(el.href, ")", "%29", -1) + ")"
+	}
+	// underline and strike are not supported by legacy Markdown
+	return "", ""
+}
+
+// escape escapes the symbols that have special meaning in the format, rules depend
+// on the entity the text is rendered into
+func (r *renderer) escape(text string) string {
+	var outer = r.entity()
+	switch r.format {
+	case HTML:
+		return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+	case MarkdownV2:
+		if outer != nil && (outer.style == code || outer.style == pre) {
+			return strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(text)
+		}
+		var result strings.Builder
+		for _, r := range text {
+			if strings.ContainsRune(markdownV2Special, r) {
+				result.WriteRune('\\')
+			}
+			result.WriteRune(r)
+		}
+		return result.String()
+	case Markdown:
+		return escapeMarkdownEntity(text, outer)
+	}
+	return text
+}
+
+// escapeMarkdownEntity escapes text for the legacy Markdown. Symbols can't be escaped
+// inside the entity, so the entity is closed, the symbol is escaped and the entity
+// is reopened
+func escapeMarkdownEntity(text string, outer *element) string {
+	var marker string
+	if outer != nil {
+		switch outer.style {
+		case bold:
+			marker = "*"
+		case italic:
+			marker = "_"
+		case code, pre:
+			marker = "`"
+		case link:
+			return strings.Replace(text, "]", ")", -1)
+		default:
+			outer = nil
+		}
+	}
+	if outer == nil {
+		return strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[").Replace(text)
+	}
+	if outer.style == pre {
+		// pre block is closed only with ```
+		return strings.Replace(text, "```", "`\u200b``", -1)
+	}
+	return strings.Replace(text, marker, marker+"\\"+marker+marker, -1)
+}
+
+// result returns the rendered text without empty lines at the start and at the end,
+// several empty lines in a row are replaced with one
+func (r *renderer) result() string {
+	var text = r.stack[0].text.String()
+	text = spacesBeforeNlRe.ReplaceAllString(text, "\n")
+	text = emptyLinesRe.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// linkSchemes schemes of the links that are kept in the text, links with other schemes,
+// relative links and anchors are rendered as text
+var linkSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+	"geo":   true,
+	"tg":    true,
+}
+
+// allowedLink checks the scheme of the link, attribute values are unescaped by the
+// tokenizer already, so encoded characters can't hide the scheme
+func allowedLink(href string) bool {
+	link, err := url.Parse(href)
+	return err == nil && linkSchemes[strings.ToLower(link.Scheme)]
+}
@@ -0,0 +1,91 @@
+package en
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+var formatExtensions = map[Format]string{
+	Plain:      ".txt",
+	Markdown:   ".md",
+	MarkdownV2: ".mdv2",
+	HTML:       ".html",
+}
+
+func TestRenderGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "render", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, ".golden.html") {
+			continue
+		}
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for format, extension := range formatExtensions {
+			var (
+				golden = strings.TrimSuffix(file, ".html") + ".golden" + extension
+				result = Render(string(input), format)
+			)
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(result+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result+"\n" != string(expected) {
+				t.Errorf("%s\nExpected:\n%s\nGot:\n%s", golden, expected, result)
+			}
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	for _, example := range []struct {
+		input    string
+		format   Format
+		expected string
+	}{
+		{"snake_case", Markdown, "snake\\_case"},
+		{"<b>2*2=4</b>", Markdown, "*2*\\**2=4*"},
+		{"<b>*2*</b>", Markdown, "\\**2*\\*"},
+		{"<i>snake_case</i>", Markdown, "_snake_\\__case_"},
+		{"x<b> bold </b>text", Markdown, "x *bold* text"},
+		{"<b></b>empty", Markdown, "empty"},
+		{"<b>a <i>b</i></b>", Markdown, "*a b*"},
+		{"<b>a <i>b</i></b>", MarkdownV2, "*a _b_*"},
+		{"<b>a <i>b</i></b>", HTML, "<b>a <i>b</i></b>"},
+		{"1.5 - (2)", MarkdownV2, "1\\.5 \\- \\(2\\)"},
+		{`<a href="http://en.cx/a_(b)">x</a>`, MarkdownV2, "[x](http://en.cx/a_(b\\))"},
+		{`<a href="http://en.cx/?a=1&b=2">x</a>`, HTML, `<a href="http://en.cx/?a=1&amp;b=2">x</a>`},
+		{`<a href="http://en.cx/">сайт</a>`, Plain, "сайт (http://en.cx/)"},
+		{"<b>unclosed <i>tags", HTML, "<b>unclosed <i>tags</i></b>"},
+		{"closed</b> tag", Plain, "closed tag"},
+		{"a<br>b<br/><br>c", Plain, "a\nb\n\nc"},
+		{`<a href="HTTPS://en.cx/">x</a>`, HTML, `<a href="HTTPS://en.cx/">x</a>`},
+		{`<a href="geo:50.0,36.2">x</a>`, HTML, `<a href="geo:50.0,36.2">x</a>`},
+		{`<a href="JavaScript:alert(1)">x</a>`, HTML, "x"},
+		{`<a href=" javascript:alert(1)">x</a>`, HTML, "x"},
+		{`<a href="java&#09;script:alert(1)">x</a>`, HTML, "x"},
+		{`<a href="jav&#x61;script:alert(1)">x</a>`, HTML, "x"},
+		{`<a href="vbscript:msgbox(1)">x</a>`, HTML, "x"},
+		{`<a href="data:text/html;base64,PHNjcmlwdD4=">x</a>`, HTML, "x"},
+		{`<a href="/game/1">x</a>`, MarkdownV2, "x"},
+	} {
+		if result := Render(example.input, example.format); result != example.expected {
+			t.Errorf("For %q in format %d\nExpected %q\nGot      %q", example.input, example.format, example.expected, result)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package en
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetries number of times the request is repeated after server failure
+	DefaultRetries = 3
+	// DefaultBackoff delay before the first repeat, it is doubled after each failure
+	DefaultBackoff = time.Second
+	// DefaultMaxBackoff maximal delay between repeats and login attempts
+	DefaultMaxBackoff = 5 * time.Minute
+)
+
+// ReauthTransport http.RoundTripper that keeps the session of the API alive.
+// When engine responds with the login page instead of json, transport logs in
+// with the credentials of the API once and replays the request. Server failures
+// of GET requests and logins are repeated with exponential backoff, codes are not
+// repeated, since engine could accept the code before it failed. Failed logins
+// postpone the next login attempt in the same way, so that the engine isn't
+// flooded when it is down
+type ReauthTransport struct {
+	// Base transport that sends requests, http.DefaultTransport is used if nil
+	Base http.RoundTripper
+	// API which credentials are used to login
+	API *API
+
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	mu sync.Mutex
+	// session is incremented on every successful login, it allows to login only
+	// once when several requests fail at the same time
+	session      int
+	loginBackoff time.Duration
+	nextLogin    time.Time
+	loginErr     error
+}
+
+// NewReauthTransport creates transport for the api with default retry settings
+func NewReauthTransport(api *API) *ReauthTransport {
+	return &ReauthTransport{
+		API:        api,
+		Retries:    DefaultRetries,
+		Backoff:    DefaultBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+}
+
+func (t *ReauthTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *ReauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		backoff  = t.Backoff
+		isLogin  = strings.HasPrefix(req.URL.Path, "/login/")
+		retry    = isLogin || req.Method == http.MethodGet || req.Method == http.MethodHead
+		reLogged = false
+		resp     *http.Response
+		err      error
+	)
+
+	// request can't be repeated if its body can't be read once again
+	if req.Body != nil && req.GetBody == nil {
+		return t.base().RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		var session int
+		// login request is sent by the transport itself while the lock is held
+		if !isLogin {
+			t.mu.Lock()
+			session = t.session
+			t.mu.Unlock()
+		}
+
+		if resp, err = t.base().RoundTrip(t.replay(req, attempt)); err != nil {
+			if attempt >= t.Retries || !retry {
+				return nil, err
+			}
+			log.Printf("[WARNING] Request to %s failed: %s", req.URL, err)
+		} else {
+			switch {
+			case resp.StatusCode >= http.StatusInternalServerError:
+				if attempt >= t.Retries || !retry {
+					return resp, nil
+				}
+				log.Printf("[WARNING] Engine responded with %d to %s", resp.StatusCode, req.URL)
+			case !isLogin && !reLogged && needsLogin(resp):
+				discard(resp)
+				reLogged = true
+				if err := t.login(session); err != nil {
+					return nil, err
+				}
+				continue
+			default:
+				return resp, nil
+			}
+			discard(resp)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if backoff *= 2; backoff > t.MaxBackoff {
+			backoff = t.MaxBackoff
+		}
+	}
+}
+
+// replay returns request that can be sent once again, body and cookies are
+// restored, since cookies could be changed after the login
+func (t *ReauthTransport) replay(req *http.Request, attempt int) *http.Request {
+	if attempt == 0 {
+		return req
+	}
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		clone.Body, _ = req.GetBody()
+	}
+	if t.API.Client != nil && t.API.Client.Jar != nil {
+		clone.Header.Del("Cookie")
+		for _, cookie := range t.API.Client.Jar.Cookies(req.URL) {
+			clone.AddCookie(cookie)
+		}
+	}
+	return clone
+}
+
+// login logs in to the engine unless somebody already did it after the failed
+// request was sent. Failed logins are not repeated until backoff expires
+func (t *ReauthTransport) login(session int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.session != session {
+		return nil
+	}
+	if time.Now().Before(t.nextLogin) {
+		return t.loginErr
+	}
+
+	log.Printf("[INFO] Session on %s is expired, logging in again", t.API.Domain)
+	if err := t.API.Login2(t.API.Username, t.API.Password); err != nil {
+		if t.loginBackoff *= 2; t.loginBackoff == 0 {
+			t.loginBackoff = t.Backoff
+		}
+		if t.loginBackoff > t.MaxBackoff {
+			t.loginBackoff = t.MaxBackoff
+		}
+		t.nextLogin = time.Now().Add(t.loginBackoff)
+		t.loginErr = err
+		return err
+	}
+	t.session++
+	t.loginBackoff = 0
+	t.nextLogin = time.Time{}
+	t.loginErr = nil
+	return nil
+}
+
+// needsLogin checks whether engine responded with the login page or with the json
+// that says that player isn't authenticated. Body of the json response is
+// restored, so that it can be read by the caller
+func needsLogin(resp *http.Response) bool {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return true
+	}
+
+	var game struct{ Event int8 }
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(buf, &game) == nil && game.Event == notAuthenticated
+}
+
+func discard(resp *http.Response) {
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+}
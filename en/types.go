@@ -49,15 +49,25 @@ type HelpInfo struct {
 
 func (help *HelpInfo) ProcessText() {
 	//log.Printf("Before %s", help.HelpText)
-	help.HelpText, help.Coords = ExtractCoordinates(help.HelpText)
-	help.HelpText, help.Images = ExtractImages(help.HelpText, "Картинка")
-	help.HelpText = ReplaceCommonTags(help.HelpText)
+	var source = Source{Kind: HelpSource, Number: int(help.Number)}
+	if help.IsPenalty {
+		source.Kind = PenaltyHelpSource
+	}
+	text, coords := ExtractCoordinatesFrom(help.HelpText, source)
+	text, images := ExtractImages(text, "Картинка")
+	help.Coords, help.Images, help.ProcessedText = coords, images, Render(text, Markdown)
 	//log.Printf("After %s", help.HelpText)
 }
 
 func (help *HelpInfo) ToText() (result string) {
 	//result, images := ReplaceImages(help.HelpText, "Картинка")
-	result = fmt.Sprintf(HelpInfoString, help.Number, ReplaceCommonTags(help.HelpText))
+	if help.ProcessedText == "" {
+		help.ProcessText()
+	}
+	if help.IsPenalty {
+		return fmt.Sprintf(PenaltyHelpInfoString, help.Number, help.ProcessedText)
+	}
+	result = fmt.Sprintf(HelpInfoString, help.Number, help.ProcessedText)
 	return
 }
 
@@ -225,13 +235,16 @@ type BonusInfo struct {
 type LevelBonuses []BonusInfo
 
 func (bi *BonusInfo) ProcessText() {
-	bi.Help, bi.Coords = ExtractCoordinates(bi.Help)
-	bi.Help, bi.Images = ExtractImages(bi.Help, "Бонус")
-	bi.Help = ReplaceCommonTags(bi.Help)
+	text, coords := ExtractCoordinatesFrom(bi.Help, Source{Kind: BonusSource, Number: int(bi.Number)})
+	text, images := ExtractImages(text, "Бонус")
+	bi.Coords, bi.Images, bi.ProcessedText = coords, images, Render(text, Markdown)
 }
 
 func (bi *BonusInfo) ToText() (result string) {
-	result = fmt.Sprintf(BonusInfoString, bi.Name, bi.Help)
+	if bi.ProcessedText == "" {
+		bi.ProcessText()
+	}
+	result = fmt.Sprintf(BonusInfoString, bi.Name, bi.ProcessedText)
 	return
 }
 
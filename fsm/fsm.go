@@ -0,0 +1,135 @@
+// Package fsm implements finite state machine with named states, guarded
+// transitions and hooks that are called when machine enters or exits the state
+package fsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownState is returned when machine is moved to the state that is not used
+// in any transition or hook
+var ErrUnknownState = errors.New("unknown state")
+
+// State name of the state of the machine
+type State string
+
+// Guard checks whether the transition can be made for the input that is passed to Fire.
+// Transition without guard is always made
+type Guard func(input interface{}) bool
+
+// Hook is called when machine moves from one state to another
+type Hook func(from State, to State)
+
+// Transition from one state to another
+type Transition struct {
+	From  State
+	To    State
+	Guard Guard
+}
+
+// Machine finite state machine. Transitions from the state are checked in the order
+// they were added, so the first transition which guard is passed is made.
+// Machine is not safe for concurrent use
+type Machine struct {
+	initial     State
+	current     State
+	states      map[State]bool
+	transitions map[State][]Transition
+	enter       map[State][]Hook
+	exit        map[State][]Hook
+}
+
+// New creates machine in the initial state, hooks of the initial state are not called
+// until machine is reset
+func New(initial State) *Machine {
+	return &Machine{
+		initial:     initial,
+		current:     initial,
+		states:      map[State]bool{initial: true},
+		transitions: make(map[State][]Transition),
+		enter:       make(map[State][]Hook),
+		exit:        make(map[State][]Hook),
+	}
+}
+
+// AddTransition adds transition between the states, guard can be nil
+func (m *Machine) AddTransition(from State, to State, guard Guard) *Machine {
+	m.states[from], m.states[to] = true, true
+	m.transitions[from] = append(m.transitions[from], Transition{From: from, To: to, Guard: guard})
+	return m
+}
+
+// OnEnter adds hook that is called when machine enters the state
+func (m *Machine) OnEnter(state State, hook Hook) *Machine {
+	m.states[state] = true
+	m.enter[state] = append(m.enter[state], hook)
+	return m
+}
+
+// OnExit adds hook that is called when machine exits the state
+func (m *Machine) OnExit(state State, hook Hook) *Machine {
+	m.states[state] = true
+	m.exit[state] = append(m.exit[state], hook)
+	return m
+}
+
+// Current returns the current state of the machine
+func (m *Machine) Current() State {
+	return m.current
+}
+
+// Fire makes the first transition from the current state which guard accepts the
+// input. Returns the new state and true if transition was made
+func (m *Machine) Fire(input interface{}) (State, bool) {
+	for _, t := range m.transitions[m.current] {
+		if t.Guard == nil || t.Guard(input) {
+			m.move(t.To)
+			return t.To, true
+		}
+	}
+	return m.current, false
+}
+
+// Reset moves machine to the initial state, hooks are called even if machine
+// is in the initial state already
+func (m *Machine) Reset() {
+	m.move(m.initial)
+}
+
+// SetState moves machine to the state without calling the hooks
+func (m *Machine) SetState(state State) error {
+	if !m.states[state] {
+		return fmt.Errorf("%w: %q", ErrUnknownState, state)
+	}
+	m.current = state
+	return nil
+}
+
+// IsFinal returns true if there are no transitions from the current state
+func (m *Machine) IsFinal() bool {
+	return len(m.transitions[m.current]) == 0
+}
+
+// MarshalText returns the current state, so that machine can be restored later
+// with UnmarshalText
+func (m *Machine) MarshalText() ([]byte, error) {
+	return []byte(m.current), nil
+}
+
+// UnmarshalText restores the state of the machine, machine should be configured
+// with the same transitions before
+func (m *Machine) UnmarshalText(text []byte) error {
+	return m.SetState(State(text))
+}
+
+func (m *Machine) move(to State) {
+	var from = m.current
+	for _, hook := range m.exit[from] {
+		hook(from, to)
+	}
+	m.current = to
+	for _, hook := range m.enter[to] {
+		hook(from, to)
+	}
+}
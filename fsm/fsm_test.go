@@ -0,0 +1,149 @@
+package fsm_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bonya_bot/fsm"
+)
+
+const (
+	idle    fsm.State = "idle"
+	running fsm.State = "running"
+	paused  fsm.State = "paused"
+	done    fsm.State = "done"
+)
+
+func isInput(expected string) fsm.Guard {
+	return func(input interface{}) bool {
+		return input == expected
+	}
+}
+
+func newTestMachine(log *[]string) *fsm.Machine {
+	var hook = func(prefix string) fsm.Hook {
+		return func(from fsm.State, to fsm.State) {
+			*log = append(*log, prefix+" "+string(from)+"->"+string(to))
+		}
+	}
+	return fsm.New(idle).
+		AddTransition(idle, running, isInput("start")).
+		AddTransition(running, paused, isInput("pause")).
+		AddTransition(running, done, isInput("stop")).
+		AddTransition(running, done, isInput("pause")).
+		AddTransition(paused, running, nil).
+		OnExit(running, hook("exit")).
+		OnEnter(running, hook("enter")).
+		OnEnter(done, hook("enter"))
+}
+
+func TestFire(t *testing.T) {
+	for _, example := range []struct {
+		name     string
+		inputs   []string
+		expected fsm.State
+		moved    []bool
+		hooks    []string
+	}{
+		{"no input", nil, idle, nil, nil},
+		{"guard rejects", []string{"stop"}, idle, []bool{false}, nil},
+		{"guard accepts", []string{"start"}, running, []bool{true},
+			[]string{"enter idle->running"}},
+		{"first transition wins", []string{"start", "pause"}, paused, []bool{true, true},
+			[]string{"enter idle->running", "exit running->paused"}},
+		{"transition without guard", []string{"start", "pause", "anything"}, running, []bool{true, true, true},
+			[]string{"enter idle->running", "exit running->paused", "enter paused->running"}},
+		{"final state", []string{"start", "stop", "start"}, done, []bool{true, true, false},
+			[]string{"enter idle->running", "exit running->done", "enter running->done"}},
+	} {
+		var (
+			hooks []string
+			moved []bool
+			m     = newTestMachine(&hooks)
+		)
+		for _, input := range example.inputs {
+			_, ok := m.Fire(input)
+			moved = append(moved, ok)
+		}
+		if m.Current() != example.expected {
+			t.Errorf("%s: expected state %q, got %q", example.name, example.expected, m.Current())
+		}
+		if !reflect.DeepEqual(moved, example.moved) {
+			t.Errorf("%s: expected transitions %v, got %v", example.name, example.moved, moved)
+		}
+		if !reflect.DeepEqual(hooks, example.hooks) {
+			t.Errorf("%s: expected hooks %q, got %q", example.name, example.hooks, hooks)
+		}
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	for _, example := range []struct {
+		state    fsm.State
+		expected bool
+	}{
+		{idle, false},
+		{running, false},
+		{paused, false},
+		{done, true},
+	} {
+		var m = newTestMachine(new([]string))
+		if err := m.SetState(example.state); err != nil {
+			t.Fatalf("Can't set state %q: %s", example.state, err)
+		}
+		if m.IsFinal() != example.expected {
+			t.Errorf("State %q: expected final %t, got %t", example.state, example.expected, m.IsFinal())
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	var (
+		hooks []string
+		m     = newTestMachine(&hooks)
+	)
+	m.OnEnter(idle, func(from fsm.State, to fsm.State) {
+		hooks = append(hooks, "enter "+string(from)+"->"+string(to))
+	})
+	m.Fire("start")
+	m.Reset()
+	if m.Current() != idle {
+		t.Errorf("Expected state %q after reset, got %q", idle, m.Current())
+	}
+	expected := []string{"enter idle->running", "exit running->idle", "enter running->idle"}
+	if !reflect.DeepEqual(hooks, expected) {
+		t.Errorf("Expected hooks %q, got %q", expected, hooks)
+	}
+}
+
+func TestMarshalText(t *testing.T) {
+	for _, example := range []struct {
+		name     string
+		text     string
+		expected fsm.State
+		err      error
+	}{
+		{"known state", "paused", paused, nil},
+		{"final state", "done", done, nil},
+		{"unknown state", "broken", idle, fsm.ErrUnknownState},
+	} {
+		var (
+			hooks []string
+			m     = newTestMachine(&hooks)
+		)
+		err := m.UnmarshalText([]byte(example.text))
+		if !errors.Is(err, example.err) {
+			t.Errorf("%s: expected error %v, got %v", example.name, example.err, err)
+		}
+		if m.Current() != example.expected {
+			t.Errorf("%s: expected state %q, got %q", example.name, example.expected, m.Current())
+		}
+		if len(hooks) != 0 {
+			t.Errorf("%s: expected no hooks to be called, got %q", example.name, hooks)
+		}
+		if text, _ := m.MarshalText(); string(text) != string(example.expected) {
+			t.Errorf("%s: expected text %q, got %q", example.name, example.expected, text)
+		}
+	}
+}
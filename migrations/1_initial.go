@@ -5,9 +5,21 @@ import (
 	//"github.com/go-pg/pg"
 )
 
+// GameSettings initial version of the table with settings of the games, columns
+// that are added later are created by the next migrations
+type GameSettings struct {
+	tableName struct{} `sql:"game_settings"`
+
+	ChatID   int64 `sql:",pk"`
+	Domain   string
+	GameID   int32
+	UserName string
+	Password string
+}
+
 func init() {
 	//db = pg.DB{}
-	migrations.Register(func (db migrations.DB) error {
+	migrations.Register(func(db migrations.DB) error {
 		err := db.CreateTable(&GameSettings{}, nil)
 		return err
 	}, func(db migrations.DB) error {
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/go-pg/migrations"
+)
+
+func init() {
+	migrations.Register(func(db migrations.DB) error {
+		_, err := db.Exec(`
+CREATE TABLE history (
+	id bigserial PRIMARY KEY,
+	chat_id bigint NOT NULL,
+	game_id integer NOT NULL,
+	level_number smallint NOT NULL,
+	type text NOT NULL,
+	text text NOT NULL,
+	author text,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX history_chat_id_game_id_level_number_idx ON history (chat_id, game_id, level_number);`)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`DROP TABLE history`)
+		return err
+	})
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/go-pg/migrations"
+)
+
+func init() {
+	migrations.Register(func(db migrations.DB) error {
+		_, err := db.Exec(`ALTER TABLE game_settings ADD COLUMN map_provider text`)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`ALTER TABLE game_settings DROP COLUMN map_provider`)
+		return err
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/go-pg/migrations"
+)
+
+func init() {
+	migrations.Register(func(db migrations.DB) error {
+		_, err := db.Exec(`
+CREATE TABLE api_tokens (
+	hash text PRIMARY KEY,
+	chat_id bigint NOT NULL,
+	author text,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX api_tokens_chat_id_idx ON api_tokens (chat_id);`)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`DROP TABLE api_tokens`)
+		return err
+	})
+}